@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	"memes-generator/internal/repository"
+	"memes-generator/internal/usecase"
+)
+
+// runBackup writes a tar.gz backup of all memes and templates to a file or to standard output
+func runBackup(args []string) {
+	flags := flag.NewFlagSet("backup", flag.ExitOnError)
+	output := flags.String("output", "", "Archive file to write, or - for standard output")
+	flags.Usage = func() {
+		fmt.Println("Usage: generate-meme backup --output <file.tar.gz|->")
+	}
+	flags.Parse(args)
+
+	if *output == "" {
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	storage, err := repository.NewStorage()
+	if err != nil {
+		log.Fatalf("Failed to initialize storage: %v", err)
+	}
+	defer storage.Close()
+	backupUsecase := usecase.NewBackupUsecase(repository.NewBackupRepository(storage))
+
+	if *output == "-" {
+		if _, err := backupUsecase.WriteBackup(os.Stdout); err != nil {
+			log.Fatalf("Failed to write backup: %v", err)
+		}
+		return
+	}
+
+	// Write next to the target and rename, so an interrupted backup never looks complete
+	tmp, err := os.CreateTemp(filepath.Dir(*output), ".tmp-backup-*")
+	if err != nil {
+		log.Fatalf("Failed to create backup file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	manifest, err := backupUsecase.WriteBackup(tmp)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		log.Fatalf("Failed to write backup: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), *output); err != nil {
+		log.Fatalf("Failed to write backup: %v", err)
+	}
+
+	fmt.Printf("Backed up %d memes, %d templates and %d snapshots (%d files) to %s\n", manifest.Memes, manifest.Templates, manifest.Snapshots, len(manifest.Files), *output)
+}
+
+// runRestore verifies a backup archive and restores it, printing a JSON report
+func runRestore(args []string) {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	mode := flags.String("mode", "merge", "merge keeps existing memes and templates that differ; replace overwrites them and deletes what the backup does not hold")
+	flags.Usage = func() {
+		fmt.Println("Usage: generate-meme restore [--mode merge|replace] <file.tar.gz|->")
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	var src io.Reader = os.Stdin
+	if flags.Arg(0) != "-" {
+		file, err := os.Open(flags.Arg(0))
+		if err != nil {
+			log.Fatalf("Failed to open backup: %v", err)
+		}
+		defer file.Close()
+		src = file
+	}
+
+	storage, err := repository.NewStorage()
+	if err != nil {
+		log.Fatalf("Failed to initialize storage: %v", err)
+	}
+	defer storage.Close()
+	backupUsecase := usecase.NewBackupUsecase(repository.NewBackupRepository(storage))
+
+	report, err := backupUsecase.Restore(src, *mode)
+	if err != nil {
+		log.Fatalf("Failed to restore backup: %v", err)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		log.Fatalf("Failed to encode restore report: %v", err)
+	}
+
+	// Exit with a distinct status when entries were not restored, so scripts can react to them
+	if len(report.Conflicts) > 0 {
+		storage.Close()
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"memes-generator/internal/repository"
+)
+
+// runFsck checks the data directory for inconsistencies and prints a JSON report
+func runFsck(args []string) {
+	flags := flag.NewFlagSet("fsck", flag.ExitOnError)
+	repair := flags.Bool("repair", false, "Fix the inconsistencies that can be fixed automatically")
+	flags.Usage = func() {
+		fmt.Println("Usage: generate-meme fsck [--repair]")
+	}
+	flags.Parse(args)
+
+	storage, err := repository.NewStorage()
+	if err != nil {
+		log.Fatalf("Failed to initialize storage: %v", err)
+	}
+	defer storage.Close()
+
+	report, err := repository.Fsck(storage, *repair)
+	if err != nil {
+		log.Fatalf("Failed to check data directory: %v", err)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		log.Fatalf("Failed to encode fsck report: %v", err)
+	}
+
+	// Exit with a distinct status while problems remain, so scripts can react to them
+	if report.Unrepaired > 0 {
+		storage.Close()
+		os.Exit(2)
+	}
+}
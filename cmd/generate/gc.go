@@ -0,0 +1,40 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"memes-generator/internal/repository"
+)
+
+// runGC deletes the blobs no meme or template references any more and prints a JSON report
+func runGC(args []string) {
+	flags := flag.NewFlagSet("gc", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "Report the blobs that would be deleted without deleting them")
+	grace := flags.Duration("grace", time.Hour, "Keep unreferenced blobs modified within this duration")
+	flags.Usage = func() {
+		fmt.Println("Usage: generate-meme gc [--dry-run] [--grace 1h]")
+	}
+	flags.Parse(args)
+
+	storage, err := repository.NewStorage()
+	if err != nil {
+		log.Fatalf("Failed to initialize storage: %v", err)
+	}
+	defer storage.Close()
+
+	report, err := repository.CollectGarbage(storage, *grace, *dryRun)
+	if err != nil {
+		log.Fatalf("Failed to collect garbage: %v", err)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		log.Fatalf("Failed to encode gc report: %v", err)
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+
+	"memes-generator/internal/config"
+	"memes-generator/internal/repository"
+)
+
+// runImportMetadata imports existing metadata.json directories into the embedded metadata store
+func runImportMetadata(args []string) {
+	if len(args) != 0 {
+		fmt.Println("Usage: generate-meme import-metadata")
+		os.Exit(1)
+	}
+
+	store, err := repository.NewBoltStore()
+	if err != nil {
+		log.Fatalf("Failed to open metadata store: %v", err)
+	}
+	defer store.Close()
+
+	report, err := repository.ImportFileMetadata(store)
+	if err != nil {
+		log.Fatalf("Failed to import metadata: %v", err)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		log.Fatalf("Failed to encode import report: %v", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "Imported %d memes, %d templates and %d usage counters into %s\n", report.Memes, report.Templates, report.Usage, config.GetMetadataDBPath())
+	if len(report.Failures) > 0 {
+		os.Exit(2)
+	}
+}
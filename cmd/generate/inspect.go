@@ -0,0 +1,40 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"memes-generator/internal/meme"
+)
+
+// runInspect prints the provenance metadata embedded into a meme image file
+func runInspect(args []string) {
+	if len(args) != 1 {
+		fmt.Println("Usage: generate-meme inspect <file>")
+		os.Exit(1)
+	}
+
+	file, err := os.Open(args[0])
+	if err != nil {
+		log.Fatalf("Failed to open image file: %v", err)
+	}
+	defer file.Close()
+
+	prov, err := meme.ReadProvenance(file)
+	if errors.Is(err, meme.ErrNoProvenance) {
+		fmt.Printf("No provenance metadata found in %s\n", args[0])
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatalf("Failed to read provenance metadata: %v", err)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(prov); err != nil {
+		log.Fatalf("Failed to encode provenance metadata: %v", err)
+	}
+}
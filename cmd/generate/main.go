@@ -1,7 +1,8 @@
 package main
 
 import (
-	"encoding/json"
+	"bytes"
+	"context"
 	"flag"
 	"fmt"
 	"log"
@@ -9,18 +10,76 @@ import (
 	"path/filepath"
 	"strings"
 
-	"memes-generator/internal/domain"
+	"memes-generator/internal/config"
+	"memes-generator/internal/idgen"
 	"memes-generator/internal/meme"
+	"memes-generator/internal/repository"
 )
 
 func main() {
+	// Subcommands are dispatched before the default --meme-path flags are parsed
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "inspect":
+			runInspect(os.Args[2:])
+			return
+		case "detect-watermark":
+			runDetectWatermark(os.Args[2:])
+			return
+		case "import-metadata":
+			runImportMetadata(os.Args[2:])
+			return
+		case "fsck":
+			runFsck(os.Args[2:])
+			return
+		case "migrate":
+			runMigrate(os.Args[2:])
+			return
+		case "backup":
+			runBackup(os.Args[2:])
+			return
+		case "restore":
+			runRestore(os.Args[2:])
+			return
+		case "gc":
+			runGC(os.Args[2:])
+			return
+		case "export-templates":
+			runExportTemplates(os.Args[2:])
+			return
+		case "import-templates":
+			runImportTemplates(os.Args[2:])
+			return
+		}
+	}
+
+	meme.ConfigureCache(config.GetRenderCacheDir(), config.GetTemplateCacheMaxBytes(), config.GetRenderCacheMaxBytes())
+
 	var memePath string
+	var memeID string
 
 	flag.StringVar(&memePath, "meme-path", "", "Path to meme directory")
+	flag.StringVar(&memeID, "meme-id", "", "ID of a meme in the configured storage backend")
 	flag.Parse()
 
+	if memeID != "" {
+		generateByID(memeID)
+		return
+	}
+
 	if memePath == "" {
 		fmt.Println("Usage: generate-meme --meme-path <path-to-meme-directory>")
+		fmt.Println("       generate-meme --meme-id <meme-id>")
+		fmt.Println("       generate-meme inspect <file>")
+		fmt.Println("       generate-meme detect-watermark <file>")
+		fmt.Println("       generate-meme import-metadata")
+		fmt.Println("       generate-meme fsck [--repair]")
+		fmt.Println("       generate-meme migrate [--dry-run]")
+		fmt.Println("       generate-meme backup --output <file.tar.gz|->")
+		fmt.Println("       generate-meme restore [--mode merge|replace] <file.tar.gz|->")
+		fmt.Println("       generate-meme gc [--dry-run] [--grace 1h]")
+		fmt.Println("       generate-meme export-templates --output <file.zip|-> [--tag <tag>] [--category <category>] [--q <text>]")
+		fmt.Println("       generate-meme import-templates [--on-conflict skip|rename|overwrite] [--author <name>] <file.zip>")
 		fmt.Println("Example: ./generate-meme --meme-path data/memes/meme_1759442111813095000")
 		os.Exit(1)
 	}
@@ -30,17 +89,22 @@ func main() {
 		log.Fatalf("Meme path does not exist: %s", memePath)
 	}
 
-	// Read metadata.json from the meme directory
+	// Hold the meme lock so the web server does not modify the meme while it is regenerated
+	unlock, err := repository.LockEntity(memePath)
+	if err != nil {
+		log.Fatalf("Failed to lock meme: %v", err)
+	}
+	defer unlock()
+
+	// Read metadata.json from the meme directory, upgrading it from older schema versions
 	metadataPath := filepath.Join(memePath, "metadata.json")
-	metadataFile, err := os.Open(metadataPath)
+	data, err := os.ReadFile(metadataPath)
 	if err != nil {
-		log.Fatalf("Failed to open metadata file: %v", err)
+		log.Fatalf("Failed to read metadata file: %v", err)
 	}
-	defer metadataFile.Close()
 
-	var memeEntity domain.Meme
-	decoder := json.NewDecoder(metadataFile)
-	if err := decoder.Decode(&memeEntity); err != nil {
+	memeEntity, err := repository.DecodeMeme(data)
+	if err != nil {
 		log.Fatalf("Failed to decode metadata: %v", err)
 	}
 
@@ -67,9 +131,12 @@ func main() {
 		}
 	}
 
+	prov := memeEntity.Provenance(config.GetServerURL())
+
 	if sourceImagesFound {
 		// Initialize meme generator with existing images
 		generator := meme.NewGenerator(imagesDir, imageDir)
+		generator.SetProvenance(prov)
 
 		// Generate memes using text from metadata
 		fmt.Printf("Regenerating memes for ID: %s\n", memeEntity.ID)
@@ -86,12 +153,24 @@ func main() {
 		fmt.Printf("Creating meme using template '%s' with text: Top='%s', Bottom='%s'\n", memeEntity.Template, memeEntity.TextTop, memeEntity.TextBottom)
 		fmt.Printf("Output path: %s\n", imageDir)
 
-		// Try to create meme from template
+		// Draw on the template snapshot taken when the meme was created, or on the current template image
+		// for memes created before snapshots
 		outputPath := filepath.Join(imageDir, "generated_meme.png")
-		if err := meme.CreateMemeFromTemplate(memeEntity.Template, memeEntity.TextTop, memeEntity.TextBottom, outputPath); err != nil {
+		templates := repository.NewTemplateFileRepository()
+		images := repository.NewBlobImageStore(repository.NewFileBlobStore(), repository.NewFileImageStore(), repository.NewMemeFileRepository(idgen.NewULIDGenerator("meme_")), templates)
+		renderer := meme.NewRenderer(images)
+		template, _ := templates.GetByName(memeEntity.Template)
+		if memeEntity.TemplateSnapshot != nil {
+			fmt.Printf("Using template snapshot %s\n", memeEntity.TemplateSnapshot.Image)
+		}
+		spec := memeEntity.RenderSpec(renderer, template, config.GetServerURL())
+		if err := meme.RenderFile(context.Background(), spec, outputPath); err != nil {
+			if memeEntity.TemplateSnapshot != nil {
+				log.Fatalf("Failed to render meme from its template snapshot: %v", err)
+			}
 			// If template not found, create a simple default template
 			fmt.Printf("Template '%s' not found, creating meme from scratch\n", memeEntity.Template)
-			if err := meme.CreateMemeImage(memeEntity.TextTop, memeEntity.TextBottom, outputPath); err != nil {
+			if err := meme.CreateMemeImage(memeEntity.TextTop, memeEntity.TextBottom, outputPath, prov); err != nil {
 				log.Fatalf("Failed to create meme from scratch: %v", err)
 			}
 		}
@@ -113,6 +192,37 @@ func main() {
 	}
 }
 
+// generateByID renders a meme looked up by ID in the storage backend selected by STORAGE_BACKEND
+func generateByID(id string) {
+	storage, err := repository.NewStorage()
+	if err != nil {
+		log.Fatalf("Failed to initialize storage: %v", err)
+	}
+	defer storage.Close()
+
+	memeEntity, err := storage.Memes.GetByID(id)
+	if err != nil {
+		log.Fatalf("Failed to load meme: %v", err)
+	}
+
+	fmt.Printf("Regenerating meme for ID: %s\n", memeEntity.ID)
+	fmt.Printf("Using template '%s' with text: Top='%s', Bottom='%s'\n", memeEntity.Template, memeEntity.TextTop, memeEntity.TextBottom)
+
+	// Memes of deleted templates render with the default caption options
+	template, _ := storage.Templates.GetByName(memeEntity.Template)
+
+	var buf bytes.Buffer
+	renderer := meme.NewRenderer(storage.Images)
+	if _, err := memeEntity.RenderImage(context.Background(), renderer, template, config.GetServerURL(), &buf); err != nil {
+		log.Fatalf("Failed to render meme: %v", err)
+	}
+	if err := storage.Images.SaveMemeImage(memeEntity.ID, "generated_meme.png", buf.Bytes()); err != nil {
+		log.Fatalf("Failed to save meme image: %v", err)
+	}
+
+	fmt.Printf("Memes regenerated successfully for ID: %s\n", memeEntity.ID)
+}
+
 // isImageFile checks if a file is an image based on its extension
 func isImageFile(filename string) bool {
 	ext := strings.ToLower(filepath.Ext(filename))
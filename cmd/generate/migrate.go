@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"memes-generator/internal/repository"
+)
+
+// runMigrate upgrades stored metadata to the current schema version and prints a JSON report
+func runMigrate(args []string) {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "Report the documents that would be migrated without writing them")
+	flags.Usage = func() {
+		fmt.Println("Usage: generate-meme migrate [--dry-run]")
+	}
+	flags.Parse(args)
+
+	storage, err := repository.NewStorage()
+	if err != nil {
+		log.Fatalf("Failed to initialize storage: %v", err)
+	}
+	defer storage.Close()
+
+	report, err := repository.Migrate(storage, *dryRun)
+	if err != nil {
+		log.Fatalf("Failed to migrate metadata: %v", err)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		log.Fatalf("Failed to encode migration report: %v", err)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"memes-generator/internal/domain"
+	"memes-generator/internal/repository"
+	"memes-generator/internal/usecase"
+)
+
+// newTemplateUsecase creates a template usecase on top of the configured storage backend
+func newTemplateUsecase(storage *repository.Storage) *usecase.TemplateUsecase {
+	return usecase.NewTemplateUsecase(storage.Templates, storage.Memes, storage.Usage, storage.Images)
+}
+
+// runExportTemplates writes a zip template pack of the selected templates to a file or to standard output
+func runExportTemplates(args []string) {
+	flags := flag.NewFlagSet("export-templates", flag.ExitOnError)
+	output := flags.String("output", "", "Pack file to write, or - for standard output")
+	text := flags.String("q", "", "Export only templates matching this search text")
+	tag := flags.String("tag", "", "Export only templates with this tag")
+	category := flags.String("category", "", "Export only templates in this category")
+	flags.Usage = func() {
+		fmt.Println("Usage: generate-meme export-templates --output <file.zip|-> [--tag <tag>] [--category <category>] [--q <text>]")
+	}
+	flags.Parse(args)
+
+	if *output == "" || flags.NArg() != 0 {
+		flags.Usage()
+		os.Exit(1)
+	}
+	query := domain.TemplateQuery{Text: *text, Tag: *tag, Category: *category}
+
+	storage, err := repository.NewStorage()
+	if err != nil {
+		log.Fatalf("Failed to initialize storage: %v", err)
+	}
+	defer storage.Close()
+	templateUsecase := newTemplateUsecase(storage)
+
+	if *output == "-" {
+		if _, err := templateUsecase.ExportTemplates(os.Stdout, query); err != nil {
+			log.Fatalf("Failed to export templates: %v", err)
+		}
+		return
+	}
+
+	// Write next to the target and rename, so an interrupted export never looks complete
+	tmp, err := os.CreateTemp(filepath.Dir(*output), ".tmp-templates-*")
+	if err != nil {
+		log.Fatalf("Failed to create pack file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	manifest, err := templateUsecase.ExportTemplates(tmp, query)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		log.Fatalf("Failed to export templates: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), *output); err != nil {
+		log.Fatalf("Failed to export templates: %v", err)
+	}
+
+	fmt.Printf("Exported %d templates (%d images) to %s\n", len(manifest.Templates), len(manifest.Files), *output)
+}
+
+// runImportTemplates verifies a zip template pack and imports its templates, printing a JSON report
+func runImportTemplates(args []string) {
+	flags := flag.NewFlagSet("import-templates", flag.ExitOnError)
+	onConflict := flags.String("on-conflict", "skip", "What to do with templates whose name is taken: skip, rename or overwrite")
+	author := flags.String("author", os.Getenv("USER"), "Author recorded for the template versions created by the import")
+	flags.Usage = func() {
+		fmt.Println("Usage: generate-meme import-templates [--on-conflict skip|rename|overwrite] [--author <name>] <file.zip>")
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(1)
+	}
+	policy, err := domain.ParsePackConflictPolicy(*onConflict)
+	if err != nil {
+		log.Fatalf("Failed to import templates: %v", err)
+	}
+
+	// Zip archives are read from their central directory at the end, so the pack must be a file
+	file, err := os.Open(flags.Arg(0))
+	if err != nil {
+		log.Fatalf("Failed to open template pack: %v", err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		log.Fatalf("Failed to open template pack: %v", err)
+	}
+
+	storage, err := repository.NewStorage()
+	if err != nil {
+		log.Fatalf("Failed to initialize storage: %v", err)
+	}
+	defer storage.Close()
+
+	report, err := newTemplateUsecase(storage).ImportTemplates(file, info.Size(), policy, *author)
+	if err != nil {
+		log.Fatalf("Failed to import templates: %v", err)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		log.Fatalf("Failed to encode import report: %v", err)
+	}
+}
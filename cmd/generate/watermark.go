@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"log"
+	"os"
+
+	"memes-generator/internal/config"
+	"memes-generator/internal/watermark"
+)
+
+// runDetectWatermark recovers the leak-tracing watermark payload from a suspect image. The watermark names
+// the requester by a hash, so candidate requester IDs given with --requester are checked against it.
+func runDetectWatermark(args []string) {
+	flags := flag.NewFlagSet("detect-watermark", flag.ExitOnError)
+	var candidates []string
+	flags.Func("requester", "Requester ID to check against the watermark; may be repeated", func(requesterID string) error {
+		candidates = append(candidates, requesterID)
+		return nil
+	})
+	flags.Usage = func() {
+		fmt.Println("Usage: generate-meme detect-watermark [--requester <id>]... <file>")
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(1)
+	}
+	path := flags.Arg(0)
+
+	key := config.GetWatermarkKey()
+	if key == "" {
+		log.Fatalf("%s must be set to detect watermarks", config.WatermarkKeyEnv)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		log.Fatalf("Failed to open image file: %v", err)
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	if err != nil {
+		log.Fatalf("Failed to decode image: %v", err)
+	}
+
+	data, err := watermark.Detect(img, key)
+	if errors.Is(err, watermark.ErrNotFound) {
+		fmt.Printf("No watermark detected in %s\n", path)
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatalf("Failed to detect watermark: %v", err)
+	}
+
+	payload, err := watermark.ParsePayload(data)
+	if err != nil {
+		log.Fatalf("Failed to decode watermark payload: %v", err)
+	}
+	if payload.RequesterID == "" && len(candidates) > 0 {
+		for _, candidate := range candidates {
+			if payload.MatchesRequester(candidate) {
+				payload.RequesterID = candidate
+				break
+			}
+		}
+		if payload.RequesterID == "" {
+			fmt.Fprintln(os.Stderr, "None of the given requesters matches the watermark")
+		}
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(payload); err != nil {
+		log.Fatalf("Failed to encode watermark payload: %v", err)
+	}
+}
@@ -9,6 +9,8 @@ import (
 
 	"memes-generator/internal/config"
 	"memes-generator/internal/delivery/http"
+	"memes-generator/internal/domain"
+	"memes-generator/internal/meme"
 	"memes-generator/internal/repository"
 	"memes-generator/internal/usecase"
 )
@@ -42,16 +44,38 @@ func main() {
 		log.Fatalf("Failed to create templates directory: %v", err)
 	}
 
-	// Initialize repositories
-	memeRepo := repository.NewMemeFileRepository()
-	templateRepo := repository.NewTemplateFileRepository()
+	// Reject an unknown template delete policy at startup rather than on the first deletion
+	if _, err := domain.ParseTemplateDeletePolicy(config.GetTemplateDeletePolicy()); err != nil {
+		log.Fatalf("Invalid %s: %v", config.TemplateDeletePolicyEnv, err)
+	}
+
+	// Configure render caches
+	meme.ConfigureCache(config.GetRenderCacheDir(), config.GetTemplateCacheMaxBytes(), config.GetRenderCacheMaxBytes())
+
+	// Initialize repositories of the configured storage backend
+	storage, err := repository.NewStorage()
+	if err != nil {
+		log.Fatalf("Failed to initialize storage: %v", err)
+	}
+	defer storage.Close()
+	memeRepo, templateRepo, imageStore := storage.Memes, storage.Templates, storage.Images
+
+	// Initialize renderer reading template images from the image store
+	renderer := meme.NewRenderer(imageStore)
 
 	// Initialize usecases
-	memeUsecase := usecase.NewMemeUsecase(memeRepo)
-	templateUsecase := usecase.NewTemplateUsecase(templateRepo)
+	memeUsecase := usecase.NewMemeUsecase(memeRepo, templateRepo, storage.Usage, imageStore, renderer)
+	templateUsecase := usecase.NewTemplateUsecase(templateRepo, memeRepo, storage.Usage, imageStore)
+	backupUsecase := usecase.NewBackupUsecase(repository.NewBackupRepository(storage))
+
+	// Requester identities are only taken from the configured proxies
+	requester, err := http.NewTrustedRequester(config.GetRequesterHeader(), config.GetTrustedProxies())
+	if err != nil {
+		log.Fatalf("Invalid %s: %v", config.TrustedProxiesEnv, err)
+	}
 
 	// Initialize handler
-	memeHandler := http.NewMemeHandler(memeUsecase, templateUsecase, webRoot)
+	memeHandler := http.NewMemeHandler(memeUsecase, templateUsecase, backupUsecase, requester, webRoot)
 
 	// Initialize Gin router
 	router := gin.Default()
@@ -66,18 +90,40 @@ func main() {
 	{
 		api.GET("/memes", memeHandler.ListMemes)
 		api.POST("/memes", memeHandler.CreateMeme)
+		api.POST("/memes/inspect", memeHandler.InspectMemeImage)
+		api.POST("/memes/preview", memeHandler.PreviewMeme)
 		api.GET("/memes/:id", memeHandler.GetMeme)
 		api.DELETE("/memes/:id", memeHandler.DeleteMeme)
 
 		// Template routes
 		api.GET("/templates", memeHandler.ListTemplates)
 		api.POST("/templates", memeHandler.CreateTemplate)
+		api.GET("/templates/trending", memeHandler.TrendingTemplates)
+		api.GET("/templates/export", memeHandler.ExportTemplates)
+		api.POST("/templates/import", memeHandler.ImportTemplates)
+		api.GET("/templates/:name", memeHandler.GetTemplate)
+		api.PATCH("/templates/:name", memeHandler.UpdateTemplate)
+		api.DELETE("/templates/:name", memeHandler.DeleteTemplate)
+		api.POST("/templates/identify", memeHandler.IdentifyTemplate)
 		api.POST("/templates/:name/image", memeHandler.UploadTemplateImage)
+		api.GET("/templates/:name/versions", memeHandler.ListTemplateVersions)
+		api.POST("/templates/:name/rollback", memeHandler.RollbackTemplate)
+		api.POST("/templates/:name/variants/:variant/image", memeHandler.UploadVariantImage)
+		api.PATCH("/templates/:name/variants/:variant", memeHandler.UpdateVariant)
+		api.DELETE("/templates/:name/variants/:variant", memeHandler.DeleteVariant)
+
+		// Admin routes
+		api.GET("/admin/templates/duplicates", memeHandler.ListDuplicateTemplates)
+		api.POST("/admin/templates/merge", memeHandler.MergeTemplates)
+		api.GET("/admin/cache", memeHandler.GetCacheStats)
+		api.POST("/admin/cache/purge", memeHandler.PurgeCache)
+		api.GET("/admin/backup", memeHandler.DownloadBackup)
 	}
 
 	// Image routes
 	router.GET("/memes/:id/image", memeHandler.ServeMemeImage)
 	router.GET("/templates/:name/image", memeHandler.ServeTemplateImage)
+	router.GET("/templates/:name/variants/:variant/image", memeHandler.ServeVariantImage)
 
 	// Serve React app for all other routes (SPA)
 	router.NoRoute(func(c *gin.Context) {
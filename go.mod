@@ -6,7 +6,9 @@ toolchain go1.24.5
 
 require (
 	github.com/gin-gonic/gin v1.11.0
+	go.etcd.io/bbolt v1.4.3
 	golang.org/x/image v0.31.0
+	golang.org/x/text v0.29.0
 )
 
 require (
@@ -38,7 +40,6 @@ require (
 	golang.org/x/net v0.43.0 // indirect
 	golang.org/x/sync v0.17.0 // indirect
 	golang.org/x/sys v0.35.0 // indirect
-	golang.org/x/text v0.29.0 // indirect
 	golang.org/x/tools v0.36.0 // indirect
 	google.golang.org/protobuf v1.36.9 // indirect
 )
@@ -2,6 +2,7 @@ package config
 
 import (
 	"os"
+	"strconv"
 )
 
 // Environment variables
@@ -12,6 +13,38 @@ const (
 	KeySecretEnv        = "CLOUDRU_KEY_SECRET"
 	ContainerJobNameEnv = "CLOUDRU_GENERATE_MEME_JOB"
 	DataDirEnv          = "DATA_DIR"
+	ServerURLEnv        = "SERVER_URL"
+	WatermarkKeyEnv     = "WATERMARK_KEY"
+	RequesterHeaderEnv  = "REQUESTER_HEADER"
+	TrustedProxiesEnv   = "TRUSTED_PROXIES"
+
+	StorageBackendEnv    = "STORAGE_BACKEND"
+	MetadataDBEnv        = "METADATA_DB"
+	S3EndpointEnv        = "S3_ENDPOINT"
+	S3RegionEnv          = "S3_REGION"
+	S3BucketEnv          = "S3_BUCKET"
+	S3PrefixEnv          = "S3_PREFIX"
+	S3AccessKeyIDEnv     = "S3_ACCESS_KEY_ID"
+	S3SecretAccessKeyEnv = "S3_SECRET_ACCESS_KEY"
+
+	RenderCacheDirEnv        = "RENDER_CACHE_DIR"
+	RenderCacheMaxBytesEnv   = "RENDER_CACHE_MAX_BYTES"
+	TemplateCacheMaxBytesEnv = "TEMPLATE_CACHE_MAX_BYTES"
+
+	TemplateDeletePolicyEnv = "TEMPLATE_DELETE_POLICY"
+)
+
+// Storage backends selected by STORAGE_BACKEND
+const (
+	StorageBackendFile = "file"
+	StorageBackendBolt = "bolt"
+	StorageBackendS3   = "s3"
+)
+
+// Default cache limits
+const (
+	defaultRenderCacheMaxBytes   = 512 << 20
+	defaultTemplateCacheMaxBytes = 256 << 20
 )
 
 // GetGenerateMemeMode returns the meme generation mode based on environment variable
@@ -43,16 +76,151 @@ func GetDataDir() string {
 	return dataDir
 }
 
+// GetServerURL returns the public server URL recorded in meme provenance metadata
+func GetServerURL() string {
+	return os.Getenv(ServerURLEnv)
+}
+
+// GetWatermarkKey returns the secret key used to embed and detect leak-tracing watermarks
+func GetWatermarkKey() string {
+	return os.Getenv(WatermarkKeyEnv)
+}
+
+// IsWatermarkEnabled checks if internal memes should be watermarked at serve time
+func IsWatermarkEnabled() bool {
+	return GetWatermarkKey() != ""
+}
+
+// GetRequesterHeader returns the header a trusted proxy identifies the user making a request with from
+// environment variable or default
+func GetRequesterHeader() string {
+	header := os.Getenv(RequesterHeaderEnv)
+	if header == "" {
+		return "X-Requester-ID"
+	}
+	return header
+}
+
+// GetTrustedProxies returns the comma-separated addresses or CIDR ranges of the proxies allowed to set the
+// requester header; without any, no request carries a requester identity
+func GetTrustedProxies() string {
+	return os.Getenv(TrustedProxiesEnv)
+}
+
 // GetMemesDir returns the memes directory path
 func GetMemesDir() string {
 	return GetDataDir() + "/memes"
 }
 
+// GetBlobsDir returns the directory of the content-addressed blob store
+func GetBlobsDir() string {
+	return GetDataDir() + "/blobs"
+}
+
+// GetSnapshotsDir returns the directory template image snapshots were kept in before the blob store
+func GetSnapshotsDir() string {
+	return GetDataDir() + "/snapshots"
+}
+
+// GetUsageDir returns the directory of the template usage counters
+func GetUsageDir() string {
+	return GetDataDir() + "/usage"
+}
+
 // GetTemplatesDir returns the templates directory path
 func GetTemplatesDir() string {
 	return GetDataDir() + "/templates"
 }
 
+// GetStorageBackend returns the storage backend for memes, templates and images from environment variable or default
+func GetStorageBackend() string {
+	backend := os.Getenv(StorageBackendEnv)
+	if backend == "" {
+		return StorageBackendFile
+	}
+	return backend
+}
+
+// GetTemplateDeletePolicy returns the default handling of memes when their template is deleted from environment variable or default
+func GetTemplateDeletePolicy() string {
+	policy := os.Getenv(TemplateDeletePolicyEnv)
+	if policy == "" {
+		return "block"
+	}
+	return policy
+}
+
+// GetMetadataDBPath returns the path of the embedded metadata store from environment variable or default
+func GetMetadataDBPath() string {
+	path := os.Getenv(MetadataDBEnv)
+	if path == "" {
+		return GetDataDir() + "/metadata.db"
+	}
+	return path
+}
+
+// GetS3Endpoint returns the URL of the S3-compatible object storage
+func GetS3Endpoint() string {
+	return os.Getenv(S3EndpointEnv)
+}
+
+// GetS3Region returns the region used to sign object storage requests from environment variable or default
+func GetS3Region() string {
+	region := os.Getenv(S3RegionEnv)
+	if region == "" {
+		return "ru-central-1"
+	}
+	return region
+}
+
+// GetS3Bucket returns the bucket holding memes, templates and images
+func GetS3Bucket() string {
+	return os.Getenv(S3BucketEnv)
+}
+
+// GetS3Prefix returns the key prefix under which all objects are stored
+func GetS3Prefix() string {
+	return os.Getenv(S3PrefixEnv)
+}
+
+// GetS3AccessKeyID returns the object storage access key ID
+func GetS3AccessKeyID() string {
+	return os.Getenv(S3AccessKeyIDEnv)
+}
+
+// GetS3SecretAccessKey returns the object storage secret access key
+func GetS3SecretAccessKey() string {
+	return os.Getenv(S3SecretAccessKeyEnv)
+}
+
+// GetRenderCacheDir returns the directory of the on-disk render cache from environment variable or default
+func GetRenderCacheDir() string {
+	dir := os.Getenv(RenderCacheDirEnv)
+	if dir == "" {
+		return GetDataDir() + "/cache/renders"
+	}
+	return dir
+}
+
+// GetRenderCacheMaxBytes returns the size limit of the on-disk render cache; 0 disables it
+func GetRenderCacheMaxBytes() int64 {
+	return getInt64(RenderCacheMaxBytesEnv, defaultRenderCacheMaxBytes)
+}
+
+// GetTemplateCacheMaxBytes returns the size limit of the in-memory decoded template cache; 0 disables it
+func GetTemplateCacheMaxBytes() int64 {
+	return getInt64(TemplateCacheMaxBytesEnv, defaultTemplateCacheMaxBytes)
+}
+
+// getInt64 reads an integer environment variable, falling back to def when unset or invalid
+func getInt64(name string, def int64) int64 {
+	value, err := strconv.ParseInt(os.Getenv(name), 10, 64)
+	if err != nil || value < 0 {
+		return def
+	}
+	return value
+}
+
 // GetContainerJobName returns the container job name from environment variable or default
 func GetContainerJobName() string {
 	jobName := os.Getenv(ContainerJobNameEnv)
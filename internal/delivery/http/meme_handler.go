@@ -1,57 +1,241 @@
 package http
 
 import (
+	"errors"
 	"fmt"
 	"io"
-	"io/fs"
+	"log"
+	"math"
 	"net/http"
-	"os"
+	"net/netip"
+	"net/url"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 
 	"memes-generator/internal/domain"
+	"memes-generator/internal/imagehash"
+	"memes-generator/internal/meme"
 	"memes-generator/internal/usecase"
 )
 
+// corruptMemesHeader lists the IDs of memes left out of a listing because their metadata cannot be read
+const corruptMemesHeader = "X-Corrupt-Memes"
+
+// maxInspectUploadSize is the largest request body accepted for reading the provenance of a meme file
+const maxInspectUploadSize = 32 << 20
+
 // MemeHandler represents the HTTP handler for memes
 type MemeHandler struct {
 	memeUsecase     domain.MemeUsecase
 	templateUsecase *usecase.TemplateUsecase
+	backupUsecase   domain.BackupUsecase
+	requester       *TrustedRequester
 	webRoot         string
 }
 
 // NewMemeHandler creates a new meme handler
-func NewMemeHandler(memeUsecase domain.MemeUsecase, templateUsecase *usecase.TemplateUsecase, webRoot string) *MemeHandler {
+func NewMemeHandler(memeUsecase domain.MemeUsecase, templateUsecase *usecase.TemplateUsecase, backupUsecase domain.BackupUsecase, requester *TrustedRequester, webRoot string) *MemeHandler {
 	return &MemeHandler{
 		memeUsecase:     memeUsecase,
 		templateUsecase: templateUsecase,
+		backupUsecase:   backupUsecase,
+		requester:       requester,
 		webRoot:         webRoot,
 	}
 }
 
+// TrustedRequester identifies the user making a request, the recipient of an internal meme image and the
+// author recorded for template versions, by a header an authenticating reverse proxy sets. The header is
+// only believed on requests arriving straight from one of the trusted proxies, so clients cannot claim an
+// identity by sending it themselves.
+type TrustedRequester struct {
+	header  string
+	proxies []netip.Prefix
+}
+
+// NewTrustedRequester creates a requester identity source for a header set by the proxies in a
+// comma-separated list of IP addresses and CIDR ranges. Without proxies no request carries an identity.
+func NewTrustedRequester(header, proxies string) (*TrustedRequester, error) {
+	requester := &TrustedRequester{header: header}
+	for _, proxy := range strings.Split(proxies, ",") {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		}
+		if !strings.Contains(proxy, "/") {
+			addr, err := netip.ParseAddr(proxy)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q: %w", proxy, err)
+			}
+			addr = addr.Unmap()
+			proxy = netip.PrefixFrom(addr, addr.BitLen()).String()
+		}
+		prefix, err := netip.ParsePrefix(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", proxy, err)
+		}
+		requester.proxies = append(requester.proxies, prefix.Masked())
+	}
+	return requester, nil
+}
+
+// ID returns the requester of a request, or an empty string when it did not come through a trusted proxy
+func (r *TrustedRequester) ID(c *gin.Context) string {
+	addr, err := netip.ParseAddr(c.RemoteIP())
+	if err != nil {
+		return ""
+	}
+	addr = addr.Unmap()
+	for _, proxy := range r.proxies {
+		if proxy.Contains(addr) {
+			return c.GetHeader(r.header)
+		}
+	}
+	return ""
+}
+
 // CreateMemeRequest represents the request body for creating a meme
 type CreateMemeRequest struct {
 	Template   string `json:"template" binding:"required"`
+	Variant    string `json:"variant"`
 	TextTop    string `json:"text_top"`
 	TextBottom string `json:"text_bottom"`
+	Internal   bool   `json:"internal"`
 }
 
 // MemeResponse represents the response body for a meme
 type MemeResponse struct {
-	ID         string `json:"id"`
-	Template   string `json:"template"`
-	TextTop    string `json:"text_top"`
-	TextBottom string `json:"text_bottom"`
-	CreatedAt  string `json:"created_at"`
-	UpdatedAt  string `json:"updated_at"`
+	ID              string `json:"id"`
+	Template        string `json:"template"`
+	Variant         string `json:"variant,omitempty"`
+	TemplateImage   string `json:"template_image,omitempty"`
+	TemplateVersion int    `json:"template_version,omitempty"`
+	TextTop         string `json:"text_top"`
+	TextBottom      string `json:"text_bottom"`
+	Internal        bool   `json:"internal"`
+	CreatedAt       string `json:"created_at"`
+	UpdatedAt       string `json:"updated_at"`
+}
+
+// newMemeResponse builds the response body for a meme. TemplateImage is the SHA-256 of the template
+// snapshot the meme renders from and TemplateVersion the template version it belongs to, absent for memes
+// created before snapshots and versions.
+func newMemeResponse(meme *domain.Meme) MemeResponse {
+	response := MemeResponse{
+		ID:         meme.ID,
+		Template:   meme.Template,
+		Variant:    meme.Variant,
+		TextTop:    meme.TextTop,
+		TextBottom: meme.TextBottom,
+		Internal:   meme.Internal,
+		CreatedAt:  meme.CreatedAt.Format("2006-01-02T15:04:05Z"),
+		UpdatedAt:  meme.UpdatedAt.Format("2006-01-02T15:04:05Z"),
+	}
+	if meme.TemplateSnapshot != nil {
+		response.TemplateImage = meme.TemplateSnapshot.Image
+		response.TemplateVersion = meme.TemplateSnapshot.Version
+	}
+	return response
 }
 
 // TemplateResponse represents the response body for a template
 type TemplateResponse struct {
-	Name      string `json:"name"`
-	CreatedAt string `json:"created_at"`
-	UpdatedAt string `json:"updated_at"`
+	Name           string                    `json:"name"`
+	DisplayName    string                    `json:"display_name"`
+	Aliases        []string                  `json:"aliases"`
+	Description    string                    `json:"description,omitempty"`
+	Category       string                    `json:"category,omitempty"`
+	Tags           []string                  `json:"tags"`
+	Layout         *meme.Layout              `json:"layout,omitempty"`
+	DefaultStyle   *meme.TextStyle           `json:"default_style,omitempty"`
+	DefaultVariant string                    `json:"default_variant"`
+	Variants       []TemplateVariantResponse `json:"variants"`
+	Version        int                       `json:"version,omitempty"`
+	UsageCount     int64                     `json:"usage_count"`
+	LastUsedAt     string                    `json:"last_used_at,omitempty"`
+	CreatedAt      string                    `json:"created_at"`
+	UpdatedAt      string                    `json:"updated_at"`
+}
+
+// TemplateVariantResponse represents an image variant of a template. Image is the SHA-256 of the variant
+// image; Layout overrides the caption layout of the template.
+type TemplateVariantResponse struct {
+	Name        string       `json:"name"`
+	Image       string       `json:"image"`
+	ContentType string       `json:"content_type"`
+	Layout      *meme.Layout `json:"layout,omitempty"`
+}
+
+// newTemplateVariantResponses builds the response bodies of the variants of a template or template version
+func newTemplateVariantResponses(variants []domain.TemplateVariant) []TemplateVariantResponse {
+	// Ensure we always return an array, even if empty
+	response := []TemplateVariantResponse{}
+	for _, variant := range variants {
+		item := TemplateVariantResponse{Name: variant.Name, Layout: variant.Layout}
+		if variant.Image != nil {
+			item.Image = variant.Image.Blob
+			item.ContentType = variant.Image.ContentType
+		}
+		response = append(response, item)
+	}
+	return response
+}
+
+// newTemplateResponse builds the response body for a template. Usage is nil for templates never used.
+// DefaultVariant is "original" for templates whose memes use the template image unless they ask otherwise.
+func newTemplateResponse(template *domain.Template, usage *domain.TemplateUsage) TemplateResponse {
+	aliases := template.Aliases
+	if aliases == nil {
+		aliases = []string{}
+	}
+	tags := template.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	response := TemplateResponse{
+		Name:           template.Name,
+		DisplayName:    template.DisplayName,
+		Aliases:        aliases,
+		Description:    template.Description,
+		Category:       template.Category,
+		Tags:           tags,
+		Layout:         template.Layout,
+		DefaultStyle:   template.DefaultStyle,
+		DefaultVariant: domain.OriginalVariant,
+		Variants:       newTemplateVariantResponses(template.Variants),
+		Version:        template.Version,
+		CreatedAt:      template.CreatedAt.Format("2006-01-02T15:04:05Z"),
+		UpdatedAt:      template.UpdatedAt.Format("2006-01-02T15:04:05Z"),
+	}
+	if template.DefaultVariant != "" {
+		response.DefaultVariant = template.DefaultVariant
+	}
+	if usage != nil && usage.Count > 0 {
+		response.UsageCount = usage.Count
+		response.LastUsedAt = usage.LastUsedAt.UTC().Format("2006-01-02T15:04:05Z")
+	}
+	return response
+}
+
+// templateUsage returns the usage of a template for its response body. Usage is secondary to the template
+// itself, so counters that cannot be read are logged and left out.
+func (h *MemeHandler) templateUsage(name string) *domain.TemplateUsage {
+	usage, err := h.templateUsecase.GetTemplateUsage(name)
+	if err != nil {
+		log.Printf("Failed to read usage of template %s: %v", name, err)
+		return nil
+	}
+	return usage
+}
+
+// TemplateMatchResponse represents a template found by image lookup
+type TemplateMatchResponse struct {
+	Template TemplateResponse   `json:"template"`
+	Distance imagehash.Distance `json:"distance"`
 }
 
 // CreateMeme handles the creation of a new meme
@@ -62,20 +246,17 @@ func (h *MemeHandler) CreateMeme(c *gin.Context) {
 		return
 	}
 
-	meme, err := h.memeUsecase.CreateMeme(req.Template, req.TextTop, req.TextBottom)
+	meme, err := h.memeUsecase.CreateMeme(req.Template, req.Variant, req.TextTop, req.TextBottom, req.Internal)
+	if errors.Is(err, domain.ErrInvalidName) || errors.Is(err, domain.ErrVariantNotFound) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	response := MemeResponse{
-		ID:         meme.ID,
-		Template:   meme.Template,
-		TextTop:    meme.TextTop,
-		TextBottom: meme.TextBottom,
-		CreatedAt:  meme.CreatedAt.Format("2006-01-02T15:04:05Z"),
-		UpdatedAt:  meme.UpdatedAt.Format("2006-01-02T15:04:05Z"),
-	}
+	response := newMemeResponse(meme)
 
 	c.JSON(http.StatusCreated, response)
 }
@@ -85,27 +266,32 @@ func (h *MemeHandler) GetMeme(c *gin.Context) {
 	id := c.Param("id")
 
 	meme, err := h.memeUsecase.GetMemeByID(id)
+	if errors.Is(err, domain.ErrInvalidName) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, domain.ErrCorruptMetadata) || errors.Is(err, domain.ErrUnsupportedSchema) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Meme not found"})
 		return
 	}
 
-	response := MemeResponse{
-		ID:         meme.ID,
-		Template:   meme.Template,
-		TextTop:    meme.TextTop,
-		TextBottom: meme.TextBottom,
-		CreatedAt:  meme.CreatedAt.Format("2006-01-02T15:04:05Z"),
-		UpdatedAt:  meme.UpdatedAt.Format("2006-01-02T15:04:05Z"),
-	}
+	response := newMemeResponse(meme)
 
 	c.JSON(http.StatusOK, response)
 }
 
-// ListMemes retrieves all memes
+// ListMemes retrieves all memes. Memes whose metadata cannot be read are named in the X-Corrupt-Memes
+// header instead of failing the listing.
 func (h *MemeHandler) ListMemes(c *gin.Context) {
 	memes, err := h.memeUsecase.ListMemes()
-	if err != nil {
+	if corrupt := domain.CorruptEntries(err); corrupt != nil {
+		// The readable memes are still listed; the others are named for fsck
+		c.Header(corruptMemesHeader, strings.Join(corrupt, ","))
+	} else if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -117,14 +303,7 @@ func (h *MemeHandler) ListMemes(c *gin.Context) {
 
 	var response []MemeResponse
 	for _, meme := range memes {
-		response = append(response, MemeResponse{
-			ID:         meme.ID,
-			Template:   meme.Template,
-			TextTop:    meme.TextTop,
-			TextBottom: meme.TextBottom,
-			CreatedAt:  meme.CreatedAt.Format("2006-01-02T15:04:05Z"),
-			UpdatedAt:  meme.UpdatedAt.Format("2006-01-02T15:04:05Z"),
-		})
+		response = append(response, newMemeResponse(meme))
 	}
 
 	c.JSON(http.StatusOK, response)
@@ -135,6 +314,10 @@ func (h *MemeHandler) DeleteMeme(c *gin.Context) {
 	id := c.Param("id")
 
 	err := h.memeUsecase.DeleteMeme(id)
+	if errors.Is(err, domain.ErrInvalidName) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Meme not found"})
 		return
@@ -143,9 +326,81 @@ func (h *MemeHandler) DeleteMeme(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Meme deleted successfully"})
 }
 
-// CreateTemplateRequest represents the request body for creating a template
+// PreviewMemeRequest represents the request body for previewing a meme
+type PreviewMemeRequest struct {
+	Template   string `json:"template" binding:"required"`
+	Variant    string `json:"variant"`
+	TextTop    string `json:"text_top"`
+	TextBottom string `json:"text_bottom"`
+	Format     string `json:"format"`
+}
+
+// PreviewMeme renders a meme straight into the response without saving it
+func (h *MemeHandler) PreviewMeme(c *gin.Context) {
+	var req PreviewMemeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// The renderer writes nothing on failure, so the headers can be set up front
+	c.Header("Content-Type", meme.ContentType(req.Format))
+	c.Header("Cache-Control", "no-store")
+	if _, err := h.memeUsecase.PreviewMeme(c.Request.Context(), req.Template, req.Variant, req.TextTop, req.TextBottom, req.Format, c.Writer); err != nil {
+		c.Header("Content-Type", "")
+		c.Header("Cache-Control", "")
+		if errors.Is(err, domain.ErrInvalidName) || errors.Is(err, domain.ErrVariantNotFound) || errors.Is(err, meme.ErrUnsupportedFormat) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+}
+
+// InspectMemeImage reads the provenance metadata embedded into an uploaded meme file
+func (h *MemeHandler) InspectMemeImage(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxInspectUploadSize)
+	file, err := c.FormFile("image")
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("Upload is larger than %d bytes", maxInspectUploadSize)})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No image file provided"})
+		return
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open uploaded file"})
+		return
+	}
+	defer src.Close()
+
+	prov, err := h.memeUsecase.InspectMemeImage(src)
+	if errors.Is(err, meme.ErrNoProvenance) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No provenance metadata found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, prov)
+}
+
+// CreateTemplateRequest represents the request body for creating a template.
+// Name is accepted as the display name for older clients; the slug is always generated.
 type CreateTemplateRequest struct {
-	Name string `json:"name" binding:"required"`
+	Name        string   `json:"name"`
+	DisplayName string   `json:"display_name"`
+	Aliases     []string `json:"aliases"`
+	Description string   `json:"description"`
+	Category    string   `json:"category"`
+	Tags        []string `json:"tags"`
 }
 
 // CreateTemplate handles the creation of a new template
@@ -156,46 +411,397 @@ func (h *MemeHandler) CreateTemplate(c *gin.Context) {
 		return
 	}
 
-	template, err := h.templateUsecase.CreateTemplate(req.Name)
+	displayName := req.DisplayName
+	if displayName == "" {
+		displayName = req.Name
+	}
+
+	template, err := h.templateUsecase.CreateTemplate(displayName, req.Aliases, domain.TemplateDetails{
+		Description: req.Description,
+		Category:    req.Category,
+		Tags:        req.Tags,
+	})
+	if errors.Is(err, domain.ErrInvalidName) || errors.Is(err, domain.ErrInvalidTemplate) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, domain.ErrAliasTaken) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	response := TemplateResponse{
-		Name:      template.Name,
-		CreatedAt: template.CreatedAt.Format("2006-01-02T15:04:05Z"),
-		UpdatedAt: template.UpdatedAt.Format("2006-01-02T15:04:05Z"),
+	c.JSON(http.StatusCreated, newTemplateResponse(template, nil))
+}
+
+// GetTemplate retrieves a template by its slug. Lookups by an alias or display name are
+// redirected permanently to the canonical slug.
+func (h *MemeHandler) GetTemplate(c *gin.Context) {
+	name := c.Param("name")
+
+	template, err := h.templateUsecase.ResolveTemplate(name)
+	if errors.Is(err, domain.ErrInvalidName) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, domain.ErrTemplateNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Template not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
 	}
 
-	c.JSON(http.StatusCreated, response)
+	if template.Name != name {
+		c.Redirect(http.StatusMovedPermanently, "/api/templates/"+url.PathEscape(template.Name))
+		return
+	}
+
+	c.JSON(http.StatusOK, newTemplateResponse(template, h.templateUsage(template.Name)))
 }
 
-// ListTemplates retrieves all templates
+// UpdateTemplateRequest represents the request body for changing a template; omitted fields are kept
+type UpdateTemplateRequest struct {
+	DisplayName    *string         `json:"display_name"`
+	Description    *string         `json:"description"`
+	Category       *string         `json:"category"`
+	Tags           *[]string       `json:"tags"`
+	Layout         *meme.Layout    `json:"layout"`
+	DefaultStyle   *meme.TextStyle `json:"default_style"`
+	DefaultVariant *string         `json:"default_variant"`
+}
+
+// UpdateTemplate handles changing the display name, description, category, tags, layout, default style or
+// default variant of a template
+func (h *MemeHandler) UpdateTemplate(c *gin.Context) {
+	var req UpdateTemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	template, err := h.templateUsecase.UpdateTemplate(c.Param("name"), domain.TemplatePatch{
+		DisplayName:    req.DisplayName,
+		Description:    req.Description,
+		Category:       req.Category,
+		Tags:           req.Tags,
+		Layout:         req.Layout,
+		DefaultStyle:   req.DefaultStyle,
+		DefaultVariant: req.DefaultVariant,
+	}, h.requester.ID(c))
+	if errors.Is(err, domain.ErrInvalidName) || errors.Is(err, domain.ErrInvalidTemplate) || errors.Is(err, domain.ErrVariantNotFound) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, domain.ErrTemplateNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Template not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, newTemplateResponse(template, h.templateUsage(template.Name)))
+}
+
+// TemplateVersionResponse represents a recorded version of a template. Image is the SHA-256 of the
+// version's image; Source is the version a rollback restored.
+type TemplateVersionResponse struct {
+	Version        int                       `json:"version"`
+	Change         string                    `json:"change"`
+	Source         int                       `json:"source,omitempty"`
+	Image          string                    `json:"image,omitempty"`
+	ContentType    string                    `json:"content_type,omitempty"`
+	Layout         *meme.Layout              `json:"layout,omitempty"`
+	DefaultStyle   *meme.TextStyle           `json:"default_style,omitempty"`
+	Variants       []TemplateVariantResponse `json:"variants,omitempty"`
+	DefaultVariant string                    `json:"default_variant,omitempty"`
+	Author         string                    `json:"author,omitempty"`
+	CreatedAt      string                    `json:"created_at"`
+	Current        bool                      `json:"current"`
+}
+
+// ListTemplateVersions handles listing the versions of a template, newest first
+func (h *MemeHandler) ListTemplateVersions(c *gin.Context) {
+	versions, current, err := h.templateUsecase.ListTemplateVersions(c.Param("name"))
+	if errors.Is(err, domain.ErrInvalidName) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, domain.ErrTemplateNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Template not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Ensure we always return an array, even if empty
+	response := []TemplateVersionResponse{}
+	for _, version := range versions {
+		item := TemplateVersionResponse{
+			Version:        version.Version,
+			Change:         string(version.Change),
+			Source:         version.Source,
+			Layout:         version.Layout,
+			DefaultStyle:   version.DefaultStyle,
+			DefaultVariant: version.DefaultVariant,
+			Author:         version.Author,
+			CreatedAt:      version.CreatedAt.UTC().Format("2006-01-02T15:04:05Z"),
+			Current:        version.Version == current,
+		}
+		if version.Image != nil {
+			item.Image = version.Image.Blob
+			item.ContentType = version.Image.ContentType
+		}
+		if len(version.Variants) > 0 {
+			item.Variants = newTemplateVariantResponses(version.Variants)
+		}
+		response = append(response, item)
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// RollbackTemplateRequest represents the request body for rolling a template back
+type RollbackTemplateRequest struct {
+	Version int `json:"version" binding:"required"`
+}
+
+// RollbackTemplate handles restoring the image, variants and caption options of an earlier template version
+func (h *MemeHandler) RollbackTemplate(c *gin.Context) {
+	var req RollbackTemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	template, err := h.templateUsecase.RollbackTemplate(c.Param("name"), req.Version, h.requester.ID(c))
+	if errors.Is(err, domain.ErrInvalidName) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, domain.ErrTemplateNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Template not found"})
+		return
+	}
+	if errors.Is(err, domain.ErrVersionNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, newTemplateResponse(template, h.templateUsage(template.Name)))
+}
+
+// DeleteTemplate handles deleting a template. The policy query parameter (block, cascade or orphan)
+// overrides the configured handling of memes that still use the template.
+func (h *MemeHandler) DeleteTemplate(c *gin.Context) {
+	name := c.Param("name")
+	policy := c.Query("policy")
+
+	memes, err := h.templateUsecase.DeleteTemplate(name, policy)
+	if errors.Is(err, domain.ErrInvalidName) || errors.Is(err, domain.ErrInvalidDeletePolicy) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, domain.ErrTemplateNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Template not found"})
+		return
+	}
+	if errors.Is(err, domain.ErrTemplateInUse) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "memes": memes})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Template deleted successfully", "memes": memes})
+}
+
+// maxTemplatePageSize is the largest page of templates a single list request returns
+const maxTemplatePageSize = 100
+
+// ListTemplates searches templates. The q parameter matches names, aliases, tags and descriptions; tag and
+// category filter the results; sort (relevance, name, created or popularity) and order (asc or desc) order
+// them; limit and offset select a page. Without a limit every match is returned. The number of all matches
+// is sent in the X-Total-Count header.
 func (h *MemeHandler) ListTemplates(c *gin.Context) {
-	templates, err := h.templateUsecase.ListTemplates()
+	query := domain.TemplateQuery{
+		Text:     c.Query("q"),
+		Tag:      c.Query("tag"),
+		Category: c.Query("category"),
+		Sort:     domain.TemplateSort(c.Query("sort")),
+		Order:    c.Query("order"),
+	}
+	var err error
+	if query.Limit, err = queryInt(c, "limit", 1, maxTemplatePageSize); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if query.Offset, err = queryInt(c, "offset", 0, math.MaxInt); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, err := h.templateUsecase.SearchTemplates(query)
+	if errors.Is(err, domain.ErrInvalidQuery) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
 	// Ensure we always return an array, even if empty
-	if templates == nil {
-		templates = []*domain.Template{}
+	response := []TemplateResponse{}
+	for _, template := range result.Templates {
+		response = append(response, newTemplateResponse(template, result.Usage[template.Name]))
+	}
+
+	c.Header("X-Total-Count", strconv.Itoa(result.Total))
+	c.JSON(http.StatusOK, response)
+}
+
+// ExportTemplates streams a zip template pack of the templates matching the q, tag and category parameters,
+// every template when none is given
+func (h *MemeHandler) ExportTemplates(c *gin.Context) {
+	query := domain.TemplateQuery{
+		Text:     c.Query("q"),
+		Tag:      c.Query("tag"),
+		Category: c.Query("category"),
+	}
+	filename := fmt.Sprintf("templates-%s.zip", time.Now().UTC().Format("20060102T150405Z"))
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Status(http.StatusOK)
+
+	manifest, err := h.templateUsecase.ExportTemplates(c.Writer, query)
+	if err != nil {
+		log.Printf("Template export failed: %v", err)
+		// Errors before the first byte, such as an invalid query, can still be answered properly
+		if !c.Writer.Written() {
+			c.Writer.Header().Del("Content-Disposition")
+			c.Writer.Header().Del("Content-Type")
+			status := http.StatusInternalServerError
+			if errors.Is(err, domain.ErrInvalidQuery) {
+				status = http.StatusBadRequest
+			}
+			c.JSON(status, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	log.Printf("Template pack streamed: %d templates, %d images", len(manifest.Templates), len(manifest.Files))
+}
+
+// ImportTemplates imports a zip template pack uploaded as the archive form file. on_conflict (skip, rename
+// or overwrite; default skip) decides what happens to templates whose slug is already taken.
+func (h *MemeHandler) ImportTemplates(c *gin.Context) {
+	policy, err := domain.ParsePackConflictPolicy(c.DefaultPostForm("on_conflict", c.Query("on_conflict")))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 
-	var response []TemplateResponse
-	for _, template := range templates {
-		response = append(response, TemplateResponse{
-			Name:      template.Name,
-			CreatedAt: template.CreatedAt.Format("2006-01-02T15:04:05Z"),
-			UpdatedAt: template.UpdatedAt.Format("2006-01-02T15:04:05Z"),
+	file, err := c.FormFile("archive")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No archive file provided"})
+		return
+	}
+	src, err := file.Open()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open uploaded file"})
+		return
+	}
+	defer src.Close()
+
+	report, err := h.templateUsecase.ImportTemplates(src, file.Size, policy, h.requester.ID(c))
+	if errors.Is(err, domain.ErrInvalidPack) || errors.Is(err, domain.ErrInvalidImage) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, domain.ErrTemplateExists) {
+		// Another request created a template under a slug of the pack while it was imported
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Failed to import templates: %v", err), "report": report})
+		return
+	}
+	if err != nil {
+		// Templates imported before the failure stay, so the client learns which ones they are
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to import templates: %v", err), "report": report})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// TrendingTemplateResponse represents a template ranked by its recent use. Uses counts the memes created
+// from it within the window; Score weighs them by age.
+type TrendingTemplateResponse struct {
+	Template TemplateResponse `json:"template"`
+	Score    float64          `json:"score"`
+	Uses     int64            `json:"uses"`
+}
+
+// TrendingTemplates ranks templates by their time-decayed use within a window of days given as window
+// (default 7d), returning at most limit (default 10) templates
+func (h *MemeHandler) TrendingTemplates(c *gin.Context) {
+	window, err := domain.ParseTrendingWindow(c.DefaultQuery("window", "7d"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	limit, err := queryInt(c, "limit", 1, maxTemplatePageSize)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	trending, err := h.templateUsecase.TrendingTemplates(window, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	response := []TrendingTemplateResponse{}
+	for _, entry := range trending {
+		response = append(response, TrendingTemplateResponse{
+			Template: newTemplateResponse(entry.Template, entry.Usage),
+			Score:    math.Round(entry.Score*1000) / 1000,
+			Uses:     entry.Uses,
 		})
 	}
 
 	c.JSON(http.StatusOK, response)
 }
 
+// queryInt parses an optional integer query parameter within [lo, hi]; an absent parameter yields zero
+func queryInt(c *gin.Context, name string, lo, hi int) (int, error) {
+	value, ok := c.GetQuery(name)
+	if !ok {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < lo || n > hi {
+		if hi == math.MaxInt {
+			return 0, fmt.Errorf("%s must be an integer of at least %d", name, lo)
+		}
+		return 0, fmt.Errorf("%s must be an integer between %d and %d", name, lo, hi)
+	}
+	return n, nil
+}
+
 // UploadTemplateImage handles uploading an image for a template
 func (h *MemeHandler) UploadTemplateImage(c *gin.Context) {
 	name := c.Param("name")
@@ -228,8 +834,28 @@ func (h *MemeHandler) UploadTemplateImage(c *gin.Context) {
 		mimeType = file.Header.Get("Content-Type")
 	}
 
+	// Near-duplicates of other templates are only accepted when forced
+	force, _ := strconv.ParseBool(c.DefaultPostForm("force", c.Query("force")))
+
 	// Save the image using the template usecase
-	if err := h.templateUsecase.SaveTemplateImage(name, fileBytes, mimeType); err != nil {
+	if err := h.templateUsecase.SaveTemplateImage(name, fileBytes, mimeType, force, h.requester.ID(c)); err != nil {
+		var duplicate *domain.DuplicateTemplateError
+		if errors.As(err, &duplicate) {
+			c.JSON(http.StatusConflict, gin.H{
+				"error":    err.Error(),
+				"existing": duplicate.Existing,
+				"distance": duplicate.Distance,
+			})
+			return
+		}
+		if errors.Is(err, domain.ErrInvalidImage) || errors.Is(err, domain.ErrInvalidName) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, domain.ErrTemplateNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Template not found"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to save template image: %v", err)})
 		return
 	}
@@ -237,12 +863,246 @@ func (h *MemeHandler) UploadTemplateImage(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Template image uploaded successfully"})
 }
 
-// ServeTemplateImage serves a template image by name
+// UploadVariantImage handles uploading the image of a named variant of a template, adding the variant
+// when the template has none by that name
+func (h *MemeHandler) UploadVariantImage(c *gin.Context) {
+	file, err := c.FormFile("image")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No image file provided"})
+		return
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open uploaded file"})
+		return
+	}
+	defer src.Close()
+
+	fileBytes, err := io.ReadAll(src)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read uploaded file"})
+		return
+	}
+
+	mimeType := "image/jpeg" // default
+	if file.Header.Get("Content-Type") != "" {
+		mimeType = file.Header.Get("Content-Type")
+	}
+
+	template, err := h.templateUsecase.SaveVariantImage(c.Param("name"), c.Param("variant"), fileBytes, mimeType, h.requester.ID(c))
+	if errors.Is(err, domain.ErrInvalidImage) || errors.Is(err, domain.ErrInvalidName) || errors.Is(err, domain.ErrInvalidTemplate) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, domain.ErrTemplateNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Template not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to save variant image: %v", err)})
+		return
+	}
+
+	c.JSON(http.StatusOK, newTemplateResponse(template, h.templateUsage(template.Name)))
+}
+
+// UpdateVariantRequest represents the request body for changing a template variant; a zero layout removes
+// the layout override of the variant
+type UpdateVariantRequest struct {
+	Layout *meme.Layout `json:"layout" binding:"required"`
+}
+
+// UpdateVariant handles changing the caption layout override of a template variant
+func (h *MemeHandler) UpdateVariant(c *gin.Context) {
+	var req UpdateVariantRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	template, err := h.templateUsecase.UpdateVariant(c.Param("name"), c.Param("variant"), *req.Layout, h.requester.ID(c))
+	h.respondVariantChange(c, template, err)
+}
+
+// DeleteVariant handles removing a variant from a template
+func (h *MemeHandler) DeleteVariant(c *gin.Context) {
+	template, err := h.templateUsecase.DeleteVariant(c.Param("name"), c.Param("variant"), h.requester.ID(c))
+	h.respondVariantChange(c, template, err)
+}
+
+// respondVariantChange answers a request that changed a variant of a template with the template
+func (h *MemeHandler) respondVariantChange(c *gin.Context, template *domain.Template, err error) {
+	if errors.Is(err, domain.ErrInvalidName) || errors.Is(err, domain.ErrInvalidTemplate) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, domain.ErrTemplateNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Template not found"})
+		return
+	}
+	if errors.Is(err, domain.ErrVariantNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, newTemplateResponse(template, h.templateUsage(template.Name)))
+}
+
+// IdentifyTemplate finds the templates an uploaded meme image was most likely made from
+func (h *MemeHandler) IdentifyTemplate(c *gin.Context) {
+	file, err := c.FormFile("image")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No image file provided"})
+		return
+	}
+
+	limit, _ := strconv.Atoi(c.Query("limit"))
+
+	src, err := file.Open()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open uploaded file"})
+		return
+	}
+	defer src.Close()
+
+	matches, err := h.templateUsecase.IdentifyTemplate(src, limit)
+	if err != nil {
+		if errors.Is(err, domain.ErrInvalidImage) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	usages, err := h.templateUsecase.TemplateUsages()
+	if err != nil {
+		log.Printf("Failed to read template usage: %v", err)
+	}
+
+	response := []TemplateMatchResponse{}
+	for _, match := range matches {
+		response = append(response, TemplateMatchResponse{
+			Template: newTemplateResponse(match.Template, usages[match.Template.Name]),
+			Distance: match.Distance,
+		})
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// TemplateClusterResponse represents a group of near-duplicate templates
+type TemplateClusterResponse struct {
+	Templates   []string `json:"templates"`
+	MaxDistance float64  `json:"max_distance"`
+}
+
+// MergeTemplatesRequest represents the request body for merging duplicate templates
+type MergeTemplatesRequest struct {
+	Survivor   string   `json:"survivor" binding:"required"`
+	Duplicates []string `json:"duplicates" binding:"required,min=1"`
+}
+
+// ListDuplicateTemplates reports clusters of near-duplicate templates
+func (h *MemeHandler) ListDuplicateTemplates(c *gin.Context) {
+	clusters, err := h.templateUsecase.FindDuplicateTemplates()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	response := []TemplateClusterResponse{}
+	for _, cluster := range clusters {
+		response = append(response, TemplateClusterResponse{
+			Templates:   cluster.Templates,
+			MaxDistance: cluster.MaxDistance,
+		})
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// MergeTemplates repoints memes of duplicate templates to a surviving template and removes the duplicates
+func (h *MemeHandler) MergeTemplates(c *gin.Context) {
+	var req MergeTemplatesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	repointed, err := h.templateUsecase.MergeTemplates(req.Survivor, req.Duplicates)
+	if errors.Is(err, domain.ErrInvalidName) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, domain.ErrTemplateNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error(), "repointed_memes": repointed})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "repointed_memes": repointed})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"survivor":          req.Survivor,
+		"removed_templates": req.Duplicates,
+		"repointed_memes":   repointed,
+	})
+}
+
+// GetCacheStats reports the hit/miss counters and sizes of the render caches
+func (h *MemeHandler) GetCacheStats(c *gin.Context) {
+	c.JSON(http.StatusOK, h.memeUsecase.GetRenderCacheStats())
+}
+
+// PurgeCache empties the render caches
+func (h *MemeHandler) PurgeCache(c *gin.Context) {
+	if err := h.memeUsecase.PurgeRenderCache(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Cache purged successfully"})
+}
+
+// DownloadBackup streams a tar.gz backup of all memes and templates.
+// Errors after streaming has started cannot change the response status; the archive then lacks its
+// manifest and is rejected on restore.
+func (h *MemeHandler) DownloadBackup(c *gin.Context) {
+	filename := fmt.Sprintf("memes-backup-%s.tar.gz", time.Now().UTC().Format("20060102T150405Z"))
+	c.Header("Content-Type", "application/gzip")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Status(http.StatusOK)
+
+	manifest, err := h.backupUsecase.WriteBackup(c.Writer)
+	if err != nil {
+		log.Printf("Backup failed: %v", err)
+		return
+	}
+	log.Printf("Backup streamed: %d memes, %d templates, %d snapshots", manifest.Memes, manifest.Templates, manifest.Snapshots)
+}
+
+// ServeTemplateImage serves a template image by slug, redirecting aliases and display names to the slug
 func (h *MemeHandler) ServeTemplateImage(c *gin.Context) {
 	name := c.Param("name")
 
+	if template, err := h.templateUsecase.ResolveTemplate(name); err == nil && template.Name != name {
+		c.Redirect(http.StatusMovedPermanently, "/templates/"+url.PathEscape(template.Name)+"/image")
+		return
+	}
+
 	// Get the image using the template usecase
 	imageData, mimeType, err := h.templateUsecase.GetTemplateImage(name)
+	if errors.Is(err, domain.ErrInvalidName) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		// If no image found, serve a placeholder
 		h.servePlaceholderImage(c)
@@ -256,6 +1116,38 @@ func (h *MemeHandler) ServeTemplateImage(c *gin.Context) {
 	c.Data(http.StatusOK, mimeType, imageData)
 }
 
+// ServeVariantImage serves the image of a template variant by slug, redirecting aliases and display names
+// to the slug. The original variant is the template image itself.
+func (h *MemeHandler) ServeVariantImage(c *gin.Context) {
+	name, variant := c.Param("name"), c.Param("variant")
+
+	if template, err := h.templateUsecase.ResolveTemplate(name); err == nil && template.Name != name {
+		c.Redirect(http.StatusMovedPermanently, "/templates/"+url.PathEscape(template.Name)+"/variants/"+url.PathEscape(variant)+"/image")
+		return
+	}
+
+	imageData, mimeType, err := h.templateUsecase.GetVariantImage(name, variant)
+	if errors.Is(err, domain.ErrInvalidName) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, domain.ErrTemplateNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Template not found"})
+		return
+	}
+	if errors.Is(err, domain.ErrVariantNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		// The template image of the original variant may not be uploaded yet, like on the image route
+		h.servePlaceholderImage(c)
+		return
+	}
+
+	c.Data(http.StatusOK, mimeType, imageData)
+}
+
 // ServeWebApp serves the React frontend application
 func (h *MemeHandler) ServeWebApp(c *gin.Context) {
 	// For SPA, serve index.html for all non-API routes
@@ -267,58 +1159,50 @@ func (h *MemeHandler) ServeWebApp(c *gin.Context) {
 func (h *MemeHandler) ServeMemeImage(c *gin.Context) {
 	id := c.Param("id")
 
-	// Find the first image in the meme's images directory
-	imagesDir := filepath.Join("./data/memes", id, "images")
-
-	// Check if directory exists
-	if _, err := os.Stat(imagesDir); os.IsNotExist(err) {
-		// If images directory doesn't exist, serve a placeholder
-		h.servePlaceholderImage(c)
+	image, info, err := h.memeUsecase.OpenMemeImage(id)
+	if errors.Is(err, domain.ErrInvalidName) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-
-	// Walk the directory to find the first image file
-	var imagePath string
-	err := filepath.WalkDir(imagesDir, func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return err
-		}
-
-		// Skip directories
-		if d.IsDir() {
-			return nil
-		}
-
-		// Check if file is an image
-		if isImageFile(path) {
-			imagePath = path
-			return filepath.SkipDir // Stop walking after finding the first image
-		}
-
-		return nil
-	})
-
-	if err != nil || imagePath == "" {
+	if err != nil {
 		// If no image found, serve a placeholder
 		h.servePlaceholderImage(c)
 		return
 	}
+	defer image.Close()
 
-	// Determine content type based on file extension
-	contentType := "image/jpeg"
-	ext := filepath.Ext(imagePath)
-	switch ext {
-	case ".png":
-		contentType = "image/png"
-	case ".gif":
-		contentType = "image/gif"
+	// Internal memes are watermarked for the requester at serve time, so the image is only served as is
+	// once the metadata shows that the meme is not internal
+	m, err := h.memeUsecase.GetMemeByID(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to read meme metadata: %v", err)})
+		return
+	}
+	if m.Internal && h.serveWatermarkedImage(c, m, image) {
+		return
 	}
 
-	// Set content type header
-	c.Header("Content-Type", contentType)
+	// Serve the image
+	c.DataFromReader(http.StatusOK, info.Size, info.ContentType, image, nil)
+}
 
-	// Serve the image file
-	c.File(imagePath)
+// serveWatermarkedImage serves a meme image carrying an invisible watermark that identifies the requester.
+// It returns false when watermarking is disabled and the image should be served as is.
+func (h *MemeHandler) serveWatermarkedImage(c *gin.Context, m *domain.Meme, image io.Reader) bool {
+	imageData, err := h.memeUsecase.WatermarkMemeImage(m, h.requester.ID(c), image)
+	switch {
+	case errors.Is(err, domain.ErrWatermarkDisabled):
+		return false
+	case errors.Is(err, domain.ErrRequesterRequired):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to watermark meme image: %v", err)})
+	default:
+		// Every copy is unique to its requester, so shared caches must not reuse it
+		c.Header("Cache-Control", "private, no-store")
+		c.Data(http.StatusOK, "image/png", imageData)
+	}
+	return true
 }
 
 // servePlaceholderImage serves a placeholder image when no meme image is available
@@ -331,14 +1215,3 @@ func (h *MemeHandler) servePlaceholderImage(c *gin.Context) {
 
 	c.Data(http.StatusOK, "image/svg+xml", []byte(placeholder))
 }
-
-// isImageFile checks if a file is an image based on its extension
-func isImageFile(path string) bool {
-	ext := filepath.Ext(path)
-	switch ext {
-	case ".jpg", ".jpeg", ".png", ".gif":
-		return true
-	default:
-		return false
-	}
-}
@@ -0,0 +1,68 @@
+package domain
+
+import (
+	"io"
+	"time"
+)
+
+// BackupFormatVersion is the version of the backup archive layout written by this build
+const BackupFormatVersion = 1
+
+// Restore modes
+const (
+	// RestoreMerge adds the memes and templates of a backup, keeping existing ones that differ
+	RestoreMerge = "merge"
+
+	// RestoreReplace makes the stored memes and templates exactly those of a backup
+	RestoreReplace = "replace"
+)
+
+// BackupManifest describes the contents of a backup archive
+type BackupManifest struct {
+	FormatVersion         int          `json:"format_version"`
+	CreatedAt             time.Time    `json:"created_at"`
+	MemeSchemaVersion     int          `json:"meme_schema_version"`
+	TemplateSchemaVersion int          `json:"template_schema_version"`
+	Memes                 int          `json:"memes"`
+	Templates             int          `json:"templates"`
+	Snapshots             int          `json:"snapshots"`
+	Files                 []BackupFile `json:"files"`
+}
+
+// BackupFile is a file of a backup archive together with its checksum
+type BackupFile struct {
+	Path        string `json:"path"`
+	Size        int64  `json:"size"`
+	SHA256      string `json:"sha256"`
+	ContentType string `json:"content_type,omitempty"`
+}
+
+// RestoreConflict is a meme or template of a backup that was not restored
+type RestoreConflict struct {
+	Entity string `json:"entity"`
+	Name   string `json:"name"`
+	Detail string `json:"detail"`
+}
+
+// RestoreReport is the result of restoring a backup
+type RestoreReport struct {
+	Mode      string            `json:"mode"`
+	Memes     int               `json:"memes"`
+	Templates int               `json:"templates"`
+	Snapshots int               `json:"snapshots"`
+	Unchanged int               `json:"unchanged"`
+	Deleted   int               `json:"deleted"`
+	Conflicts []RestoreConflict `json:"conflicts"`
+}
+
+// BackupRepository defines the interface for archiving and restoring all stored memes and templates
+type BackupRepository interface {
+	WriteBackup(w io.Writer) (*BackupManifest, error)
+	Restore(r io.Reader, mode string) (*RestoreReport, error)
+}
+
+// BackupUsecase defines the interface for backup business logic
+type BackupUsecase interface {
+	WriteBackup(w io.Writer) (*BackupManifest, error)
+	Restore(r io.Reader, mode string) (*RestoreReport, error)
+}
@@ -0,0 +1,35 @@
+package domain
+
+import (
+	"io"
+	"time"
+)
+
+// ImageRef points a meme or template at the blob holding its image. Name is the file name the image is
+// served and archived under, e.g. "template.jpg" or "generated_meme.png".
+type ImageRef struct {
+	Blob        string `json:"blob"`
+	Name        string `json:"name"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+}
+
+// BlobInfo describes a stored blob
+type BlobInfo struct {
+	Hash        string
+	Size        int64
+	ContentType string
+	ModifiedAt  time.Time
+}
+
+// BlobStore defines the interface for content-addressed storage of immutable data.
+// Blobs are addressed by the hex SHA-256 of their content, so storing the same bytes again keeps a single
+// copy; it only refreshes the modification time that garbage collection uses to spare recent blobs.
+// Missing blobs are reported with an error wrapping ErrImageNotFound, as every blob holds an image.
+type BlobStore interface {
+	Put(data []byte) (string, error)
+	Open(hash string) (io.ReadCloser, BlobInfo, error)
+	Stat(hash string) (BlobInfo, error)
+	List() ([]BlobInfo, error)
+	Delete(hash string) error
+}
@@ -0,0 +1,107 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"memes-generator/internal/imagehash"
+	"memes-generator/internal/schema"
+)
+
+var (
+	// ErrMemeNotFound is returned when a meme with the given ID does not exist
+	ErrMemeNotFound = errors.New("meme not found")
+
+	// ErrTemplateNotFound is returned when a template with the given name does not exist
+	ErrTemplateNotFound = errors.New("template not found")
+
+	// ErrTemplateExists is returned when a template is created under a slug that another template already uses
+	ErrTemplateExists = errors.New("template already exists")
+
+	// ErrVersionNotFound is returned when a template has no version with the given number
+	ErrVersionNotFound = errors.New("template version not found")
+
+	// ErrVariantNotFound is returned when a template has no image variant with the given name
+	ErrVariantNotFound = errors.New("template variant not found")
+
+	// ErrTemplateInUse is returned when a template that memes still use is deleted with the block policy
+	ErrTemplateInUse = errors.New("template is in use")
+
+	// ErrInvalidDeletePolicy is returned for an unknown template delete policy
+	ErrInvalidDeletePolicy = errors.New("invalid template delete policy")
+
+	// ErrInvalidTemplate is returned when a template change has invalid fields, e.g. a caption position outside the image
+	ErrInvalidTemplate = errors.New("invalid template")
+
+	// ErrInvalidQuery is returned for a template search with an unknown sort order or out-of-range pagination
+	ErrInvalidQuery = errors.New("invalid template query")
+
+	// ErrAliasTaken is returned when a template alias is already the slug or an alias of another template
+	ErrAliasTaken = errors.New("alias already in use")
+
+	// ErrInvalidName is returned when a template name or meme ID is not allowed, e.g. because it would escape the data directory
+	ErrInvalidName = errors.New("invalid name")
+
+	// ErrCorruptMetadata is returned when stored metadata exists but cannot be read, e.g. after a torn write
+	ErrCorruptMetadata = errors.New("corrupt metadata")
+
+	// ErrUnsupportedSchema is returned when stored metadata was written by a newer schema version than this build reads
+	ErrUnsupportedSchema = schema.ErrUnsupportedVersion
+
+	// ErrInvalidBackup is returned when a backup archive is malformed or fails checksum verification
+	ErrInvalidBackup = errors.New("invalid backup archive")
+
+	// ErrInvalidPack is returned when a template pack is malformed, fails checksum verification or holds invalid templates
+	ErrInvalidPack = errors.New("invalid template pack")
+
+	// ErrImageNotFound is returned when a template or meme has no stored image
+	ErrImageNotFound = errors.New("image not found")
+
+	// ErrInvalidImage is returned when uploaded data cannot be decoded as an image
+	ErrInvalidImage = errors.New("invalid image")
+
+	// ErrWatermarkDisabled is returned when watermarking is requested but no watermark key is configured
+	ErrWatermarkDisabled = errors.New("watermarking is disabled")
+
+	// ErrRequesterRequired is returned when an internal meme is requested without a requester identity
+	ErrRequesterRequired = errors.New("requester identity is required for internal memes")
+)
+
+// DuplicateTemplateError is returned when an uploaded template image is a near-duplicate of an existing template
+type DuplicateTemplateError struct {
+	Existing string
+	Distance imagehash.Distance
+}
+
+// Error implements the error interface
+func (e *DuplicateTemplateError) Error() string {
+	return fmt.Sprintf("image duplicates existing template %s (distance %.1f)", e.Existing, e.Distance.Score)
+}
+
+// CorruptEntriesError is returned by listings that left out entries whose metadata could not be read or
+// decoded. The listing still returns the readable entries, so callers that only display them may go on;
+// callers that act on the whole set, such as garbage collection or backups, must not.
+type CorruptEntriesError struct {
+	Entity string
+	Names  []string
+}
+
+// Error implements the error interface
+func (e *CorruptEntriesError) Error() string {
+	return fmt.Sprintf("%v: unreadable %s metadata: %s", ErrCorruptMetadata, e.Entity, strings.Join(e.Names, ", "))
+}
+
+// Unwrap makes the error match ErrCorruptMetadata
+func (e *CorruptEntriesError) Unwrap() error {
+	return ErrCorruptMetadata
+}
+
+// CorruptEntries returns the names a listing left out when err reports them, and nil otherwise
+func CorruptEntries(err error) []string {
+	var corrupt *CorruptEntriesError
+	if errors.As(err, &corrupt) {
+		return corrupt.Names
+	}
+	return nil
+}
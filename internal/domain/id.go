@@ -0,0 +1,7 @@
+package domain
+
+// IDGenerator creates unique IDs for new entities.
+// IDs must sort lexicographically in creation order, including after IDs made by earlier generators.
+type IDGenerator interface {
+	NewID() string
+}
@@ -0,0 +1,24 @@
+package domain
+
+import (
+	"io"
+
+	"memes-generator/internal/meme"
+)
+
+// ImageStore defines the interface for reading and writing template and meme images.
+// It satisfies meme.TemplateSource, so the renderer reads template images through it as well.
+// Missing images are reported with an error wrapping ErrImageNotFound.
+// Images are kept as blobs referenced from the meme and template metadata; snapshots are template image
+// blobs referenced from memes by their hash. SaveTemplateImage points the template at the saved image in one
+// locked update of its metadata; update, if given, is applied in the same update and learns the image the
+// template pointed at before.
+type ImageStore interface {
+	StatTemplateImage(name string) (meme.ImageInfo, error)
+	OpenTemplateImage(name string) (io.ReadCloser, meme.ImageInfo, error)
+	SaveTemplateImage(name string, data []byte, contentType string, update func(template *Template, previous *ImageRef) error) error
+	OpenMemeImage(id string) (io.ReadCloser, meme.ImageInfo, error)
+	SaveMemeImage(id, filename string, data []byte) error
+	SaveSnapshot(data []byte) (string, error)
+	OpenSnapshot(hash string) (io.ReadCloser, meme.ImageInfo, error)
+}
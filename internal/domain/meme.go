@@ -1,43 +1,114 @@
 package domain
 
 import (
+	"context"
+	"io"
 	"time"
 
 	"memes-generator/internal/meme"
 )
 
-// Meme represents a meme entity
+// MemeSchemaVersion is the version of the stored meme metadata written by this build
+const MemeSchemaVersion = 1
+
+// Meme represents a meme entity.
+// SchemaVersion is the metadata schema the meme was stored with; repositories upgrade older documents
+// on read and stamp MemeSchemaVersion on write. TemplateSnapshot freezes the template the meme was made
+// from; memes created before snapshots, or from a template without an image, have none. Variant names the
+// template variant the meme was made from, empty for the template image itself. Image points at the
+// blob holding the generated image; it is nil until the meme is rendered and for images still kept in the
+// images/ directory of the meme.
 type Meme struct {
-	ID         string    `json:"id"`
-	Template   string    `json:"template"`
-	TextTop    string    `json:"text_top"`
-	TextBottom string    `json:"text_bottom"`
-	CreatedAt  time.Time `json:"created_at"`
-	UpdatedAt  time.Time `json:"updated_at"`
+	SchemaVersion    int               `json:"schema_version"`
+	ID               string            `json:"id"`
+	Template         string            `json:"template"`
+	Variant          string            `json:"variant,omitempty"`
+	TemplateSnapshot *TemplateSnapshot `json:"template_snapshot,omitempty"`
+	Image            *ImageRef         `json:"image,omitempty"`
+	TextTop          string            `json:"text_top"`
+	TextBottom       string            `json:"text_bottom"`
+	Internal         bool              `json:"internal,omitempty"`
+	CreatedAt        time.Time         `json:"created_at"`
+	UpdatedAt        time.Time         `json:"updated_at"`
+}
+
+// TemplateSnapshot is the state of a template at the time a meme was created: the SHA-256 of the template
+// image, a blob shared with the template and every other meme made from the same image, the caption options
+// and the number of the template version they belong to, zero for templates without versions
+type TemplateSnapshot struct {
+	Image       string          `json:"image"`
+	Version     int             `json:"version,omitempty"`
+	ContentType string          `json:"content_type,omitempty"`
+	Layout      *meme.Layout    `json:"layout,omitempty"`
+	Style       *meme.TextStyle `json:"style,omitempty"`
 }
 
-// CreateMemeImage generates a meme image for this meme entity
-func (m *Meme) CreateMemeImage(textTop, textBottom, outputPath string) error {
-	// Try to create meme from template
-	if err := meme.CreateMemeFromTemplate(m.Template, textTop, textBottom, outputPath); err != nil {
-		// If template not found, create a simple default template
-		return meme.CreateMemeImage(textTop, textBottom, outputPath)
+// Provenance returns the provenance metadata embedded into this meme's generated images
+func (m *Meme) Provenance(serverURL string) *meme.Provenance {
+	return &meme.Provenance{
+		MemeID:          m.ID,
+		Template:        m.Template,
+		TextTop:         m.TextTop,
+		TextBottom:      m.TextBottom,
+		RendererVersion: meme.RendererVersion,
+		ServerURL:       serverURL,
 	}
-	return nil
 }
 
-// MemeRepository defines the interface for meme data operations
+// RenderSpec returns the render spec of this meme entity. Memes with a template snapshot are always drawn
+// on the snapshot image with the snapshot caption options; older memes use the current image and caption
+// options of their template, which may be nil when the template no longer exists.
+func (m *Meme) RenderSpec(renderer *meme.Renderer, template *Template, serverURL string) meme.RenderSpec {
+	var spec meme.RenderSpec
+	if snapshot := m.TemplateSnapshot; snapshot != nil {
+		spec = renderer.SnapshotSpec(snapshot.Image)
+		if snapshot.Layout != nil {
+			spec.Layout = *snapshot.Layout
+		}
+		if snapshot.Style != nil {
+			spec.Style = *snapshot.Style
+		}
+	} else {
+		var err error
+		if spec, err = renderer.TemplateSpec(m.Template); err != nil {
+			// If template not found, create a simple default template
+			spec = meme.RenderSpec{}
+		}
+		spec.Layout, spec.Style = template.CaptionOptions()
+	}
+
+	spec.TextTop = m.TextTop
+	spec.TextBottom = m.TextBottom
+	spec.Provenance = m.Provenance(serverURL)
+	return spec
+}
+
+// RenderImage renders the image of this meme entity and writes it to w
+func (m *Meme) RenderImage(ctx context.Context, renderer *meme.Renderer, template *Template, serverURL string, w io.Writer) (meme.RenderResult, error) {
+	return meme.Render(ctx, m.RenderSpec(renderer, template, serverURL), w)
+}
+
+// MemeRepository defines the interface for meme data operations. Listings report memes whose metadata
+// cannot be read with a *CorruptEntriesError, returned together with the readable memes.
 type MemeRepository interface {
 	Create(meme *Meme) error
 	GetByID(id string) (*Meme, error)
 	List() ([]*Meme, error)
+	ListByTemplate(template string) ([]*Meme, error)
+	Update(meme *Meme) error
 	Delete(id string) error
 }
 
 // MemeUsecase defines the interface for meme business logic
 type MemeUsecase interface {
-	CreateMeme(template, textTop, textBottom string) (*Meme, error)
+	CreateMeme(template, variant, textTop, textBottom string, internal bool) (*Meme, error)
 	GetMemeByID(id string) (*Meme, error)
 	ListMemes() ([]*Meme, error)
 	DeleteMeme(id string) error
+	PreviewMeme(ctx context.Context, template, variant, textTop, textBottom, format string, w io.Writer) (meme.RenderResult, error)
+	OpenMemeImage(id string) (io.ReadCloser, meme.ImageInfo, error)
+	InspectMemeImage(image io.Reader) (*meme.Provenance, error)
+	WatermarkMemeImage(m *Meme, requesterID string, image io.Reader) ([]byte, error)
+	GetRenderCacheStats() meme.CacheStats
+	PurgeRenderCache() error
 }
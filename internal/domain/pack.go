@@ -0,0 +1,89 @@
+package domain
+
+import (
+	"fmt"
+	"time"
+
+	"memes-generator/internal/meme"
+)
+
+// TemplatePackFormatVersion is the version of the template pack layout written by this build. Version 2
+// added template variants; packs of version 1 are still read.
+const TemplatePackFormatVersion = 2
+
+// PackConflictPolicy decides what an import does with a template whose slug is already taken
+type PackConflictPolicy string
+
+const (
+	// PackConflictSkip keeps the existing template and leaves the one of the pack out
+	PackConflictSkip PackConflictPolicy = "skip"
+
+	// PackConflictRename imports the template of the pack under the next free slug
+	PackConflictRename PackConflictPolicy = "rename"
+
+	// PackConflictOverwrite replaces the metadata and image of the existing template with those of the pack
+	PackConflictOverwrite PackConflictPolicy = "overwrite"
+)
+
+// ParsePackConflictPolicy parses a pack conflict policy name; an empty name skips conflicting templates
+func ParsePackConflictPolicy(s string) (PackConflictPolicy, error) {
+	switch policy := PackConflictPolicy(s); policy {
+	case "":
+		return PackConflictSkip, nil
+	case PackConflictSkip, PackConflictRename, PackConflictOverwrite:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("%w: unknown conflict policy %q: use skip, rename or overwrite", ErrInvalidPack, s)
+	}
+}
+
+// PackTemplate is the portable part of a template carried by a template pack: everything but the
+// deployment-specific image references, hashes, versions and timestamps. Image is the archive path of the
+// template image, empty for templates without one.
+type PackTemplate struct {
+	Name           string          `json:"name"`
+	DisplayName    string          `json:"display_name"`
+	Aliases        []string        `json:"aliases,omitempty"`
+	Description    string          `json:"description,omitempty"`
+	Category       string          `json:"category,omitempty"`
+	Tags           []string        `json:"tags,omitempty"`
+	Layout         *meme.Layout    `json:"layout,omitempty"`
+	DefaultStyle   *meme.TextStyle `json:"default_style,omitempty"`
+	Image          string          `json:"image,omitempty"`
+	Variants       []PackVariant   `json:"variants,omitempty"`
+	DefaultVariant string          `json:"default_variant,omitempty"`
+}
+
+// PackVariant is a template variant carried by a template pack; Image is the archive path of its image
+type PackVariant struct {
+	Name   string       `json:"name"`
+	Layout *meme.Layout `json:"layout,omitempty"`
+	Image  string       `json:"image"`
+}
+
+// TemplatePackManifest describes the contents of a template pack
+type TemplatePackManifest struct {
+	FormatVersion int            `json:"format_version"`
+	CreatedAt     time.Time      `json:"created_at"`
+	Templates     []PackTemplate `json:"templates"`
+	Files         []BackupFile   `json:"files"`
+}
+
+// PackImport is the outcome for one template of an imported pack. Action is "created", "renamed",
+// "overwritten" or "skipped"; Name is the slug the template ended up under.
+type PackImport struct {
+	Template string   `json:"template"`
+	Name     string   `json:"name"`
+	Action   string   `json:"action"`
+	Dropped  []string `json:"dropped_aliases,omitempty"`
+}
+
+// TemplatePackReport is the result of importing a template pack
+type TemplatePackReport struct {
+	Policy      PackConflictPolicy `json:"policy"`
+	Created     int                `json:"created"`
+	Renamed     int                `json:"renamed"`
+	Overwritten int                `json:"overwritten"`
+	Skipped     int                `json:"skipped"`
+	Templates   []PackImport       `json:"templates"`
+}
@@ -0,0 +1,117 @@
+package domain
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	// maxSlugLength is the longest generated template slug in bytes, leaving room for a de-duplication suffix
+	maxSlugLength = 60
+
+	// fallbackSlug is used for display names without any transliterable letter or digit
+	fallbackSlug = "template"
+)
+
+// cyrillicTransliteration maps lowercase Cyrillic letters to Latin, following the Russian passport
+// transliteration with Ukrainian and Belarusian letters added
+var cyrillicTransliteration = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "e", 'ж': "zh",
+	'з': "z", 'и': "i", 'й': "i", 'к': "k", 'л': "l", 'м': "m", 'н': "n", 'о': "o",
+	'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u", 'ф': "f", 'х': "kh", 'ц': "ts",
+	'ч': "ch", 'ш': "sh", 'щ': "shch", 'ъ': "ie", 'ы': "y", 'ь': "", 'э': "e", 'ю': "iu",
+	'я': "ia", 'і': "i", 'ї': "i", 'є': "ie", 'ґ': "g", 'ў': "u",
+}
+
+// Slugify turns a display name into a template slug: lowercase ASCII letters and digits separated by
+// single hyphens. Cyrillic is transliterated and accents are stripped from Latin letters, so
+// "Кот в шоке" becomes "kot-v-shoke" and "Café" becomes "cafe".
+func Slugify(displayName string) string {
+	slug := strings.ReplaceAll(Transliterate(displayName), " ", "-")
+	if len(slug) > maxSlugLength {
+		slug = strings.TrimRight(slug[:maxSlugLength], "-")
+	}
+	if slug == "" {
+		return fallbackSlug
+	}
+	return slug
+}
+
+// Transliterate reduces text to lowercase ASCII words separated by single spaces, the way Slugify does
+// before joining them with hyphens. Search uses it to match Cyrillic queries against Latin slugs and back.
+// Text without any transliterable letter or digit yields an empty string.
+func Transliterate(text string) string {
+	var b strings.Builder
+	pendingSpace := false
+	for _, r := range norm.NFKD.String(strings.ToLower(text)) {
+		var part string
+		switch {
+		case 'a' <= r && r <= 'z', '0' <= r && r <= '9':
+			part = string(r)
+		case unicode.Is(unicode.Mn, r):
+			// Combining accents left over from decomposition
+			continue
+		default:
+			var ok bool
+			if part, ok = cyrillicTransliteration[r]; !ok {
+				pendingSpace = true
+				continue
+			}
+		}
+		if part == "" {
+			continue
+		}
+		if pendingSpace && b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		pendingSpace = false
+		b.WriteString(part)
+	}
+	return b.String()
+}
+
+// reservedSlugs are names of API routes next to /api/templates/:name that generated slugs must not take
+var reservedSlugs = map[string]bool{
+	"export":   true,
+	"trending": true,
+}
+
+// IsReservedSlug reports whether a slug is taken by an API route and must not be given to a template
+func IsReservedSlug(slug string) bool {
+	return reservedSlugs[slug]
+}
+
+// SlugCandidate returns the n-th candidate slug for a base slug: the base itself for n <= 1 and
+// base-n otherwise, used to de-duplicate templates whose display names transliterate alike
+func SlugCandidate(base string, n int) string {
+	if n <= 1 {
+		return base
+	}
+	return base + "-" + strconv.Itoa(n)
+}
+
+// IsSlug reports whether a template name already has the slug form produced by Slugify.
+// Templates created before slugs were introduced may be named otherwise.
+func IsSlug(name string) bool {
+	if name == "" || len(name) > maxTemplateNameLength || name[0] == '-' || name[len(name)-1] == '-' {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		ch := name[i]
+		switch {
+		case 'a' <= ch && ch <= 'z', '0' <= ch && ch <= '9':
+		case ch == '-' && name[i-1] != '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// AliasKey normalizes a display name or alias for lookups: case and repeated whitespace are ignored
+func AliasKey(name string) string {
+	return strings.Join(strings.Fields(strings.ToLower(norm.NFC.String(name))), " ")
+}
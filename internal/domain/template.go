@@ -1,20 +1,279 @@
 package domain
 
 import (
+	"fmt"
 	"time"
+
+	"memes-generator/internal/imagehash"
+	"memes-generator/internal/meme"
 )
 
-// Template represents a meme template entity
+// TemplateSchemaVersion is the version of the stored template metadata written by this build
+const TemplateSchemaVersion = 3
+
+// Template represents a meme template entity.
+// Name is the template's slug: an immutable ASCII identifier generated from the display name and used as its
+// directory name, object key and URL segment. DisplayName is free-form and may change; Aliases are further
+// names, such as former names, under which the template can be looked up. Tags and Category are normalized
+// labels for browsing and searching the library. Layout and DefaultStyle control
+// how captions are drawn on memes made from the template. Image points at the blob holding the template image;
+// it is nil for templates without an image and for images still kept in the images/ directory of the template.
+// Variants are further named images of the template, such as other crops or a dark version, and
+// DefaultVariant names the one memes use when they ask for none; empty means the template image itself.
+// Versions records every state of the images and caption options, oldest first, and Version is the number
+// of the current one; templates whose images and captions never changed have no versions.
+// SchemaVersion is the metadata schema the template was stored with; repositories upgrade older documents
+// on read and stamp TemplateSchemaVersion on write.
 type Template struct {
-	Name      string    `json:"name"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	SchemaVersion  int                    `json:"schema_version"`
+	Name           string                 `json:"name"`
+	DisplayName    string                 `json:"display_name"`
+	Aliases        []string               `json:"aliases,omitempty"`
+	Description    string                 `json:"description,omitempty"`
+	Layout         *meme.Layout           `json:"layout,omitempty"`
+	DefaultStyle   *meme.TextStyle        `json:"default_style,omitempty"`
+	Tags           []string               `json:"tags,omitempty"`
+	Category       string                 `json:"category,omitempty"`
+	Image          *ImageRef              `json:"image,omitempty"`
+	Hashes         *imagehash.Fingerprint `json:"hashes,omitempty"`
+	Variants       []TemplateVariant      `json:"variants,omitempty"`
+	DefaultVariant string                 `json:"default_variant,omitempty"`
+	Version        int                    `json:"version,omitempty"`
+	Versions       []TemplateVersion      `json:"versions,omitempty"`
+	CreatedAt      time.Time              `json:"created_at"`
+	UpdatedAt      time.Time              `json:"updated_at"`
+}
+
+// CaptionOptions returns the caption layout and style of memes made from the template.
+// A nil template, e.g. one that was deleted, yields the defaults.
+func (t *Template) CaptionOptions() (meme.Layout, meme.TextStyle) {
+	var layout meme.Layout
+	var style meme.TextStyle
+	if t == nil {
+		return layout, style
+	}
+	if t.Layout != nil {
+		layout = *t.Layout
+	}
+	if t.DefaultStyle != nil {
+		style = *t.DefaultStyle
+	}
+	return layout, style
+}
+
+// OriginalVariant names the template image itself among the variants of a template
+const OriginalVariant = "original"
+
+// TemplateVariant is a named alternative image of a template. A variant Layout replaces the caption layout
+// of the template for memes made from the variant; the default caption style applies to every variant.
+type TemplateVariant struct {
+	Name   string       `json:"name"`
+	Image  *ImageRef    `json:"image"`
+	Layout *meme.Layout `json:"layout,omitempty"`
+}
+
+// FindVariant returns the image variant of the template with the given name
+func (t *Template) FindVariant(name string) (*TemplateVariant, bool) {
+	for i := range t.Variants {
+		if t.Variants[i].Name == name {
+			return &t.Variants[i], true
+		}
+	}
+	return nil, false
+}
+
+// ResolveVariant returns the variant a meme asking for the named one is made from: nil for the template
+// image itself, which OriginalVariant names. An empty name picks the default variant of the template.
+func (t *Template) ResolveVariant(name string) (*TemplateVariant, error) {
+	if name == "" {
+		name = t.DefaultVariant
+	}
+	if name == "" || name == OriginalVariant {
+		return nil, nil
+	}
+	if variant, ok := t.FindVariant(name); ok {
+		return variant, nil
+	}
+	return nil, fmt.Errorf("template %s variant %s: %w", t.Name, name, ErrVariantNotFound)
+}
+
+// VariantCaptionOptions returns the caption layout and style of memes made from a variant of the template,
+// or from the template image itself for a nil variant
+func (t *Template) VariantCaptionOptions(variant *TemplateVariant) (meme.Layout, meme.TextStyle) {
+	layout, style := t.CaptionOptions()
+	if variant != nil && variant.Layout != nil {
+		layout = *variant.Layout
+	}
+	return layout, style
+}
+
+// FindVersion returns the recorded version of the template with the given number
+func (t *Template) FindVersion(number int) (*TemplateVersion, bool) {
+	for i := range t.Versions {
+		if t.Versions[i].Version == number {
+			return &t.Versions[i], true
+		}
+	}
+	return nil, false
+}
+
+// TemplateChange names what created a template version
+type TemplateChange string
+
+const (
+	// TemplateChangeInitial is the state of a template when its history started
+	TemplateChangeInitial TemplateChange = "initial"
+
+	// TemplateChangeImage is an uploaded image
+	TemplateChangeImage TemplateChange = "image"
+
+	// TemplateChangeLayout is a changed caption layout or default caption style
+	TemplateChangeLayout TemplateChange = "layout"
+
+	// TemplateChangeVariant is an added, changed or deleted image variant, or another default variant
+	TemplateChangeVariant TemplateChange = "variant"
+
+	// TemplateChangeImport is an image or caption options imported from a template pack
+	TemplateChangeImport TemplateChange = "import"
+
+	// TemplateChangeRollback restores the image and caption options of an earlier version
+	TemplateChangeRollback TemplateChange = "rollback"
+)
+
+// TemplateVersion is a recorded state of the images and caption options of a template. Versions are
+// numbered from 1 and never change once recorded; a rollback records a new version with Source set to
+// the number of the version it restored. Author identifies who made the change, when known.
+type TemplateVersion struct {
+	Version        int               `json:"version"`
+	Change         TemplateChange    `json:"change"`
+	Source         int               `json:"source,omitempty"`
+	Image          *ImageRef         `json:"image,omitempty"`
+	Layout         *meme.Layout      `json:"layout,omitempty"`
+	DefaultStyle   *meme.TextStyle   `json:"default_style,omitempty"`
+	Variants       []TemplateVariant `json:"variants,omitempty"`
+	DefaultVariant string            `json:"default_variant,omitempty"`
+	Author         string            `json:"author,omitempty"`
+	CreatedAt      time.Time         `json:"created_at"`
+}
+
+// TemplateDetails holds the descriptive fields of a new template
+type TemplateDetails struct {
+	Description string
+	Category    string
+	Tags        []string
+}
+
+// TemplatePatch holds the fields of a template to change; nil fields are left as they are.
+// An empty description, category, tag list, layout, style or default variant resets the field.
+type TemplatePatch struct {
+	DisplayName    *string
+	Description    *string
+	Category       *string
+	Tags           *[]string
+	Layout         *meme.Layout
+	DefaultStyle   *meme.TextStyle
+	DefaultVariant *string
+}
+
+// TemplateDeletePolicy decides what happens to memes that still use a template being deleted
+type TemplateDeletePolicy string
+
+const (
+	// TemplateDeleteBlock refuses to delete a template that memes still use
+	TemplateDeleteBlock TemplateDeletePolicy = "block"
+
+	// TemplateDeleteCascade deletes the memes together with the template
+	TemplateDeleteCascade TemplateDeletePolicy = "cascade"
+
+	// TemplateDeleteOrphan keeps the memes; they render on the default background from then on
+	TemplateDeleteOrphan TemplateDeletePolicy = "orphan"
+)
+
+// ParseTemplateDeletePolicy parses a template delete policy name
+func ParseTemplateDeletePolicy(s string) (TemplateDeletePolicy, error) {
+	switch policy := TemplateDeletePolicy(s); policy {
+	case TemplateDeleteBlock, TemplateDeleteCascade, TemplateDeleteOrphan:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("%w %q: use block, cascade or orphan", ErrInvalidDeletePolicy, s)
+	}
+}
+
+// TemplateMatch is a template found by perceptual hash lookup together with its distance to the query image
+type TemplateMatch struct {
+	Template *Template
+	Distance imagehash.Distance
+}
+
+// TemplateSort orders template search results
+type TemplateSort string
+
+const (
+	// TemplateSortRelevance puts the best name matches first; it is the default when searching by text
+	TemplateSortRelevance TemplateSort = "relevance"
+
+	// TemplateSortName orders templates alphabetically by display name; it is the default otherwise
+	TemplateSortName TemplateSort = "name"
+
+	// TemplateSortCreated orders templates by creation date, newest first unless ascending order is asked for
+	TemplateSortCreated TemplateSort = "created"
+
+	// TemplateSortPopularity orders templates by their usage count, most used first unless ascending order
+	// is asked for
+	TemplateSortPopularity TemplateSort = "popularity"
+)
+
+// ParseTemplateSort parses a template sort order name; an empty name yields the default for the query
+func ParseTemplateSort(s string) (TemplateSort, error) {
+	switch sort := TemplateSort(s); sort {
+	case "", TemplateSortRelevance, TemplateSortName, TemplateSortCreated, TemplateSortPopularity:
+		return sort, nil
+	default:
+		return "", fmt.Errorf("%w: unknown sort %q: use relevance, name, created or popularity", ErrInvalidQuery, s)
+	}
 }
 
-// TemplateRepository defines the interface for template data operations
+// TemplateQuery describes a template search. Text matches the slug, display name and aliases by exact,
+// prefix and fuzzy comparison, and tags and descriptions by substring; Tag and Category restrict the results
+// to templates carrying them. Order is "asc", "desc" or empty for the default of the sort. A Limit of zero
+// returns every result from Offset on.
+type TemplateQuery struct {
+	Text     string
+	Tag      string
+	Category string
+	Sort     TemplateSort
+	Order    string
+	Limit    int
+	Offset   int
+}
+
+// TemplateSearchResult is a page of templates found by a search together with the number of all matches
+// and the usage of the templates, keyed by slug; templates never used have no entry
+type TemplateSearchResult struct {
+	Templates []*Template
+	Total     int
+	Usage     map[string]*TemplateUsage
+}
+
+// TemplateRepository defines the interface for template data operations. Listings report templates whose
+// metadata cannot be read with a *CorruptEntriesError, returned together with the readable templates.
+// Mutate reads a template, applies mutate to it and writes it back, unless mutate left it unchanged or
+// failed, without letting other writers interleave; mutate must not call the repository for the same
+// template and may be retried by backends without locks. DeleteIf runs check with the template locked in
+// the same way and deletes the template unless check fails.
 type TemplateRepository interface {
 	Create(template *Template) error
 	GetByName(name string) (*Template, error)
+	Update(template *Template) error
+	Mutate(name string, mutate func(*Template) error) (*Template, error)
 	List() ([]*Template, error)
+	ListByTag(tag string) ([]*Template, error)
 	Delete(name string) error
+	DeleteIf(name string, check func() error) error
+}
+
+// TemplateCluster is a group of templates whose images are near-duplicates of each other
+type TemplateCluster struct {
+	Templates   []string
+	MaxDistance float64
 }
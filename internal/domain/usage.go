@@ -0,0 +1,81 @@
+package domain
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// UsageDayFormat is the layout of the UTC day keys of TemplateUsage.Days
+const UsageDayFormat = "2006-01-02"
+
+// maxTrendingWindowDays is the longest trending window in days
+const maxTrendingWindowDays = 365
+
+// TemplateUsage counts the memes created from a template. Days holds the count of every UTC day with at
+// least one use, keyed by UsageDayFormat; Count is their sum. Deleting memes does not lower the counts.
+type TemplateUsage struct {
+	Template   string           `json:"template"`
+	Count      int64            `json:"count"`
+	LastUsedAt time.Time        `json:"last_used_at"`
+	Days       map[string]int64 `json:"days"`
+}
+
+// Add records one use at a time
+func (u *TemplateUsage) Add(at time.Time) {
+	if u.Days == nil {
+		u.Days = make(map[string]int64)
+	}
+	u.Days[at.UTC().Format(UsageDayFormat)]++
+	u.Count++
+	if at.After(u.LastUsedAt) {
+		u.LastUsedAt = at
+	}
+}
+
+// TrendingScore weighs the uses of the window days up to and including the day of now, halving the weight
+// of a day's uses every halfLife days, so recent uses dominate without older ones dropping out abruptly.
+// It also returns the number of uses within the window.
+func (u *TemplateUsage) TrendingScore(now time.Time, windowDays int, halfLife float64) (float64, int64) {
+	var score float64
+	var uses int64
+	today := now.UTC()
+	for age := 0; age < windowDays; age++ {
+		count := u.Days[today.AddDate(0, 0, -age).Format(UsageDayFormat)]
+		if count == 0 {
+			continue
+		}
+		uses += count
+		score += float64(count) * math.Exp2(-float64(age)/halfLife)
+	}
+	return score, uses
+}
+
+// ParseTrendingWindow parses a trending window given in days, such as "7d"
+func ParseTrendingWindow(s string) (int, error) {
+	days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+	if err != nil || !strings.HasSuffix(s, "d") || days < 1 || days > maxTrendingWindowDays {
+		return 0, fmt.Errorf("%w: window %q must be a number of days from 1d to %dd", ErrInvalidQuery, s, maxTrendingWindowDays)
+	}
+	return days, nil
+}
+
+// TrendingTemplate is a template ranked by its recent use
+type TrendingTemplate struct {
+	Template *Template
+	Usage    *TemplateUsage
+	Score    float64
+	Uses     int64
+}
+
+// UsageRepository defines the interface for template usage counters. Record must count every call exactly
+// once, even when memes are created concurrently by several processes sharing the storage. Get returns an
+// empty usage for templates that were never used.
+type UsageRepository interface {
+	Record(meme *Meme) error
+	Get(template string) (*TemplateUsage, error)
+	List() ([]*TemplateUsage, error)
+	Delete(template string) error
+}
@@ -0,0 +1,173 @@
+package domain
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// maxTemplateNameLength is the longest template name in characters
+	maxTemplateNameLength = 100
+
+	// maxMemeIDLength is the longest meme ID in bytes
+	maxMemeIDLength = 128
+
+	// maxLabelLength is the longest template tag or category in characters
+	maxLabelLength = 50
+
+	// maxTags is the most tags a template can carry
+	maxTags = 20
+
+	// maxVariantNameLength is the longest template variant name in characters
+	maxVariantNameLength = 50
+
+	// MaxVariants is the most image variants a template can carry
+	MaxVariants = 20
+)
+
+// ValidateTemplateName checks that a template name is safe to use as a directory name and object key.
+// Names consist of letters, digits, spaces, '-', '_' and '.', start with a letter or digit and do not end
+// with a space or dot, so they can never contain path separators or name a parent or hidden directory.
+func ValidateTemplateName(name string) error {
+	if name == "" {
+		return fmt.Errorf("%w: template name is empty", ErrInvalidName)
+	}
+	if !utf8.ValidString(name) || utf8.RuneCountInString(name) > maxTemplateNameLength {
+		return fmt.Errorf("%w: template name %q must be valid UTF-8 of at most %d characters", ErrInvalidName, name, maxTemplateNameLength)
+	}
+
+	for i, r := range name {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+		case i > 0 && (r == ' ' || r == '-' || r == '_' || r == '.'):
+		default:
+			return fmt.Errorf("%w: template name %q contains %q; use letters, digits, spaces, '-', '_' and '.' after a letter or digit", ErrInvalidName, name, r)
+		}
+	}
+
+	if last, _ := utf8.DecodeLastRuneInString(name); last == ' ' || last == '.' {
+		return fmt.Errorf("%w: template name %q must not end with a space or dot", ErrInvalidName, name)
+	}
+
+	return nil
+}
+
+// ValidateVariantName checks the name of a template variant. Names are used in URLs, so they consist of
+// lowercase ASCII letters, digits and single '-' between them, such as "dark" or "square-512".
+// OriginalVariant is valid and names the template image itself.
+func ValidateVariantName(name string) error {
+	if name == "" {
+		return fmt.Errorf("%w: variant name is empty", ErrInvalidName)
+	}
+	if len(name) > maxVariantNameLength {
+		return fmt.Errorf("%w: variant name is longer than %d characters", ErrInvalidName, maxVariantNameLength)
+	}
+	for i := 0; i < len(name); i++ {
+		ch := name[i]
+		switch {
+		case 'a' <= ch && ch <= 'z', '0' <= ch && ch <= '9':
+		case ch == '-' && i > 0 && i < len(name)-1 && name[i-1] != '-':
+		default:
+			return fmt.Errorf("%w: variant name %q contains %q; use lowercase letters and digits separated by single '-'", ErrInvalidName, name, ch)
+		}
+	}
+	return nil
+}
+
+// ValidateMemeID checks that a meme ID is safe to use as a directory name and object key.
+// IDs consist of ASCII letters, digits, '-' and '_' and start with a letter or digit, which covers the
+// legacy meme_<unix nanoseconds> IDs as well as the IDs of any IDGenerator.
+func ValidateMemeID(id string) error {
+	if id == "" {
+		return fmt.Errorf("%w: meme ID is empty", ErrInvalidName)
+	}
+	if len(id) > maxMemeIDLength {
+		return fmt.Errorf("%w: meme ID is longer than %d characters", ErrInvalidName, maxMemeIDLength)
+	}
+
+	for i := 0; i < len(id); i++ {
+		ch := id[i]
+		switch {
+		case 'a' <= ch && ch <= 'z', 'A' <= ch && ch <= 'Z', '0' <= ch && ch <= '9':
+		case i > 0 && (ch == '-' || ch == '_'):
+		default:
+			return fmt.Errorf("%w: meme ID %q contains %q", ErrInvalidName, id, ch)
+		}
+	}
+
+	return nil
+}
+
+// ValidateDisplayName checks a template display name or alias. Display names are free-form text of at most
+// maxTemplateNameLength characters; they are never used as paths, but must not be blank or contain control
+// characters.
+func ValidateDisplayName(name string) error {
+	if strings.TrimSpace(name) == "" {
+		return fmt.Errorf("%w: display name is empty", ErrInvalidName)
+	}
+	if !utf8.ValidString(name) || utf8.RuneCountInString(name) > maxTemplateNameLength {
+		return fmt.Errorf("%w: display name %q must be valid UTF-8 of at most %d characters", ErrInvalidName, name, maxTemplateNameLength)
+	}
+	for _, r := range name {
+		if unicode.IsControl(r) {
+			return fmt.Errorf("%w: display name %q contains control characters", ErrInvalidName, name)
+		}
+	}
+	return nil
+}
+
+// NormalizeLabel checks a template tag or category and returns its canonical form: lowercase with surrounding
+// and repeated whitespace removed, so "Reaction  Faces" and "reaction faces" are the same label. Labels
+// consist of letters, digits, spaces, '-' and '_'.
+func NormalizeLabel(label string) (string, error) {
+	normalized := AliasKey(label)
+	if normalized == "" {
+		return "", fmt.Errorf("%w: tag or category is empty", ErrInvalidTemplate)
+	}
+	if !utf8.ValidString(label) || utf8.RuneCountInString(normalized) > maxLabelLength {
+		return "", fmt.Errorf("%w: tag or category %q must be valid UTF-8 of at most %d characters", ErrInvalidTemplate, label, maxLabelLength)
+	}
+	for _, r := range normalized {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !unicode.Is(unicode.Mn, r) && r != ' ' && r != '-' && r != '_' {
+			return "", fmt.Errorf("%w: tag or category %q contains %q; use letters, digits, spaces, '-' and '_'", ErrInvalidTemplate, label, r)
+		}
+	}
+	return normalized, nil
+}
+
+// NormalizeTags normalizes the tags of a template with NormalizeLabel, dropping duplicates while keeping
+// the order they were given in
+func NormalizeTags(tags []string) ([]string, error) {
+	var normalized []string
+	seen := make(map[string]bool)
+	for _, tag := range tags {
+		label, err := NormalizeLabel(tag)
+		if err != nil {
+			return nil, err
+		}
+		if seen[label] {
+			continue
+		}
+		seen[label] = true
+		normalized = append(normalized, label)
+	}
+	if len(normalized) > maxTags {
+		return nil, fmt.Errorf("%w: a template carries at most %d tags", ErrInvalidTemplate, maxTags)
+	}
+	return normalized, nil
+}
+
+// ValidateContentHash checks that a content address is a lowercase hex SHA-256 digest
+func ValidateContentHash(hash string) error {
+	if len(hash) != 64 {
+		return fmt.Errorf("%w: content hash %q is not a SHA-256 digest", ErrInvalidName, hash)
+	}
+	for i := 0; i < len(hash); i++ {
+		if ch := hash[i]; !('0' <= ch && ch <= '9' || 'a' <= ch && ch <= 'f') {
+			return fmt.Errorf("%w: content hash %q is not a SHA-256 digest", ErrInvalidName, hash)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package idgen
+
+import (
+	"crypto/rand"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// crockford is the Crockford base32 alphabet used by ULIDs; it sorts in the same order as the values it encodes
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// randomBytes is the size of the random part of an ID, 80 bits as in a ULID
+const randomBytes = 10
+
+// ULIDGenerator creates ULID-style IDs of the form <prefix><13-digit unix milliseconds>_<16 base32 characters>,
+// e.g. meme_1760000000000_0J8ZC4XW4T7QF2AH.
+//
+// The decimal millisecond timestamp keeps new IDs in order with the legacy <prefix><unix nanoseconds> IDs:
+// both start with the same 13 digits for the same millisecond, and the underscore sorts after every digit.
+// The 80 random bits make IDs from different processes collision-free without checking the storage, and
+// IDs created by one generator within the same millisecond increment the random part so they stay sorted.
+type ULIDGenerator struct {
+	prefix string
+
+	mu     sync.Mutex
+	lastMs int64
+	last   [randomBytes]byte
+}
+
+// NewULIDGenerator creates an ID generator whose IDs start with prefix
+func NewULIDGenerator(prefix string) *ULIDGenerator {
+	return &ULIDGenerator{
+		prefix: prefix,
+	}
+}
+
+// NewID returns a new unique ID
+func (g *ULIDGenerator) NewID() string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	ms := time.Now().UnixMilli()
+	if ms <= g.lastMs {
+		// Same millisecond, or the clock went back: keep IDs monotonic within this generator
+		ms = g.lastMs
+		if !increment(&g.last) {
+			// The random part overflowed; move on to the next millisecond
+			ms++
+			g.fill()
+		}
+	} else {
+		g.fill()
+	}
+	g.lastMs = ms
+
+	return fmt.Sprintf("%s%013d_%s", g.prefix, ms, encode(g.last))
+}
+
+// fill replaces the random part with fresh random bytes
+func (g *ULIDGenerator) fill() {
+	if _, err := rand.Read(g.last[:]); err != nil {
+		panic(fmt.Sprintf("idgen: failed to read random bytes: %v", err))
+	}
+}
+
+// increment adds one to a big-endian number, reporting false when it wrapped around
+func increment(b *[randomBytes]byte) bool {
+	for i := len(b) - 1; i >= 0; i-- {
+		b[i]++
+		if b[i] != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// encode writes 80 bits as 16 Crockford base32 characters, most significant first
+func encode(b [randomBytes]byte) string {
+	var out [16]byte
+	var acc uint64
+	bits := 0
+	n := 0
+	for _, v := range b {
+		acc = acc<<8 | uint64(v)
+		bits += 8
+		for bits >= 5 {
+			bits -= 5
+			out[n] = crockford[(acc>>bits)&31]
+			n++
+		}
+	}
+	return string(out[:])
+}
+
+// CompactSize is the size of an ID packed by Compact: the millisecond timestamp in 6 bytes and the random part
+const CompactSize = 6 + randomBytes
+
+// Compact packs an ID created by a generator with prefix into CompactSize bytes. It reports false for IDs of
+// another form, such as legacy nanosecond IDs.
+func Compact(prefix, id string) ([]byte, bool) {
+	rest, ok := strings.CutPrefix(id, prefix)
+	if !ok || len(rest) != 13+1+16 || rest[13] != '_' {
+		return nil, false
+	}
+	ms, err := strconv.ParseInt(rest[:13], 10, 64)
+	if err != nil || ms < 0 {
+		return nil, false
+	}
+	random, ok := decode(rest[14:])
+	if !ok {
+		return nil, false
+	}
+
+	data := make([]byte, CompactSize)
+	for i := 0; i < 6; i++ {
+		data[i] = byte(ms >> (8 * (5 - i)))
+	}
+	copy(data[6:], random[:])
+	return data, true
+}
+
+// Expand restores an ID packed by Compact
+func Expand(prefix string, data []byte) (string, bool) {
+	if len(data) != CompactSize {
+		return "", false
+	}
+	var ms int64
+	for _, b := range data[:6] {
+		ms = ms<<8 | int64(b)
+	}
+	var random [randomBytes]byte
+	copy(random[:], data[6:])
+	return fmt.Sprintf("%s%013d_%s", prefix, ms, encode(random)), true
+}
+
+// decode reads 16 Crockford base32 characters written by encode back into 80 bits
+func decode(s string) ([randomBytes]byte, bool) {
+	var b [randomBytes]byte
+	var acc uint64
+	bits := 0
+	n := 0
+	for i := 0; i < len(s); i++ {
+		v := strings.IndexByte(crockford, s[i])
+		if v < 0 {
+			return b, false
+		}
+		acc = acc<<5 | uint64(v)
+		bits += 5
+		if bits >= 8 {
+			bits -= 8
+			b[n] = byte(acc >> bits)
+			n++
+		}
+	}
+	return b, n == randomBytes
+}
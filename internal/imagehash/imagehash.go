@@ -0,0 +1,194 @@
+package imagehash
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"math/bits"
+	"sort"
+	"strconv"
+
+	xdraw "golang.org/x/image/draw"
+)
+
+// Hash is a 64-bit perceptual hash
+type Hash uint64
+
+// MarshalText encodes the hash as 16 hex digits
+func (h Hash) MarshalText() ([]byte, error) {
+	return []byte(fmt.Sprintf("%016x", uint64(h))), nil
+}
+
+// UnmarshalText decodes a hash from hex digits
+func (h *Hash) UnmarshalText(text []byte) error {
+	v, err := strconv.ParseUint(string(text), 16, 64)
+	if err != nil {
+		return fmt.Errorf("invalid image hash %q: %w", text, err)
+	}
+	*h = Hash(v)
+	return nil
+}
+
+// Hamming returns the number of differing bits between two hashes
+func (h Hash) Hamming(other Hash) int {
+	return bits.OnesCount64(uint64(h ^ other))
+}
+
+// Set holds the average, difference and DCT hashes of one image region
+type Set struct {
+	AHash Hash `json:"ahash"`
+	DHash Hash `json:"dhash"`
+	PHash Hash `json:"phash"`
+}
+
+// Fingerprint holds hashes of the whole image and of its central band.
+// The central band leaves out the top and bottom areas where captions are usually placed.
+type Fingerprint struct {
+	Full   Set `json:"full"`
+	Center Set `json:"center"`
+}
+
+// Distance describes how far two fingerprints are from each other, in differing bits out of 64
+type Distance struct {
+	AHash int     `json:"ahash"`
+	DHash int     `json:"dhash"`
+	PHash int     `json:"phash"`
+	Score float64 `json:"score"`
+}
+
+// centerBand is the fraction of the image height cut from the top and from the bottom for the center hashes
+const centerBand = 0.2
+
+// Compute calculates the fingerprint of an image
+func Compute(img image.Image) Fingerprint {
+	bounds := img.Bounds()
+	cut := int(float64(bounds.Dy()) * centerBand)
+	center := image.Rect(bounds.Min.X, bounds.Min.Y+cut, bounds.Max.X, bounds.Max.Y-cut)
+	if center.Empty() {
+		center = bounds
+	}
+
+	return Fingerprint{
+		Full:   computeSet(img, bounds),
+		Center: computeSet(img, center),
+	}
+}
+
+// Compare returns the distance between two fingerprints.
+// Both the full and center hashes are compared and the closer pair wins, so captions
+// covering part of a meme do not hide the template it was made from.
+func Compare(a, b Fingerprint) Distance {
+	full := compareSets(a.Full, b.Full)
+	center := compareSets(a.Center, b.Center)
+	if center.Score < full.Score {
+		return center
+	}
+	return full
+}
+
+// compareSets returns the distance between two hash sets
+func compareSets(a, b Set) Distance {
+	d := Distance{
+		AHash: a.AHash.Hamming(b.AHash),
+		DHash: a.DHash.Hamming(b.DHash),
+		PHash: a.PHash.Hamming(b.PHash),
+	}
+	d.Score = float64(d.AHash+d.DHash+d.PHash) / 3
+	return d
+}
+
+// computeSet hashes one rectangular region of an image
+func computeSet(img image.Image, rect image.Rectangle) Set {
+	return Set{
+		AHash: averageHash(grayscale(img, rect, 8, 8)),
+		DHash: differenceHash(grayscale(img, rect, 9, 8)),
+		PHash: dctHash(grayscale(img, rect, 32, 32)),
+	}
+}
+
+// grayscale resamples a region of an image to width x height luminance values
+func grayscale(img image.Image, rect image.Rectangle, width, height int) [][]float64 {
+	small := image.NewRGBA(image.Rect(0, 0, width, height))
+	xdraw.BiLinear.Scale(small, small.Bounds(), img, rect, xdraw.Src, nil)
+
+	gray := make([][]float64, height)
+	for y := 0; y < height; y++ {
+		gray[y] = make([]float64, width)
+		for x := 0; x < width; x++ {
+			px := small.RGBAAt(x, y)
+			gray[y][x] = 0.299*float64(px.R) + 0.587*float64(px.G) + 0.114*float64(px.B)
+		}
+	}
+	return gray
+}
+
+// averageHash sets a bit for every pixel brighter than the mean
+func averageHash(gray [][]float64) Hash {
+	sum := 0.0
+	for _, row := range gray {
+		for _, v := range row {
+			sum += v
+		}
+	}
+	mean := sum / 64
+
+	var h Hash
+	for _, row := range gray {
+		for _, v := range row {
+			h <<= 1
+			if v > mean {
+				h |= 1
+			}
+		}
+	}
+	return h
+}
+
+// differenceHash sets a bit for every pixel brighter than its right neighbour
+func differenceHash(gray [][]float64) Hash {
+	var h Hash
+	for _, row := range gray {
+		for x := 0; x < 8; x++ {
+			h <<= 1
+			if row[x] > row[x+1] {
+				h |= 1
+			}
+		}
+	}
+	return h
+}
+
+// dctHash sets a bit for every low-frequency DCT coefficient above the median
+func dctHash(gray [][]float64) Hash {
+	const size = 32
+	const keep = 8
+
+	var coeffs [keep * keep]float64
+	for v := 0; v < keep; v++ {
+		for u := 0; u < keep; u++ {
+			sum := 0.0
+			for y := 0; y < size; y++ {
+				cy := math.Cos(math.Pi * float64(2*y+1) * float64(v) / (2 * size))
+				for x := 0; x < size; x++ {
+					sum += gray[y][x] * cy * math.Cos(math.Pi*float64(2*x+1)*float64(u)/(2*size))
+				}
+			}
+			coeffs[v*keep+u] = sum
+		}
+	}
+
+	// The DC coefficient only carries overall brightness, so it is left out of the median
+	sorted := make([]float64, 0, len(coeffs)-1)
+	sorted = append(sorted, coeffs[1:]...)
+	sort.Float64s(sorted)
+	median := (sorted[len(sorted)/2-1] + sorted[len(sorted)/2]) / 2
+
+	var h Hash
+	for _, c := range coeffs {
+		h <<= 1
+		if c > median {
+			h |= 1
+		}
+	}
+	return h
+}
@@ -0,0 +1,400 @@
+package meme
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CacheLevelStats holds the counters of one cache level
+type CacheLevelStats struct {
+	Entries   int   `json:"entries"`
+	Bytes     int64 `json:"bytes"`
+	MaxBytes  int64 `json:"max_bytes"`
+	Hits      int64 `json:"hits"`
+	Misses    int64 `json:"misses"`
+	Evictions int64 `json:"evictions"`
+}
+
+// CacheStats holds the counters of both render cache levels
+type CacheStats struct {
+	Templates CacheLevelStats `json:"templates"`
+	Renders   CacheLevelStats `json:"renders"`
+}
+
+// Both cache levels stay disabled until ConfigureCache is called
+var (
+	templateCache = newImageCache(0)
+	renderCache   = newDiskCache("", 0)
+)
+
+// ConfigureCache sets the limits of the decoded template cache and the location and limit of
+// the on-disk render cache, as read from the config package. An empty renderDir or a zero limit
+// disables that level.
+func ConfigureCache(renderDir string, templateMaxBytes, renderMaxBytes int64) {
+	templateCache.resize(templateMaxBytes)
+	renderCache = newDiskCache(renderDir, renderMaxBytes)
+}
+
+// GetCacheStats returns the current counters of both cache levels
+func GetCacheStats() CacheStats {
+	return CacheStats{
+		Templates: templateCache.stats(),
+		Renders:   renderCache.stats(),
+	}
+}
+
+// PurgeCache empties both cache levels
+func PurgeCache() error {
+	templateCache.purge()
+	return renderCache.purge()
+}
+
+// InvalidateTemplate drops the cached decoded image of a template
+func InvalidateTemplate(templateName string) {
+	templateCache.remove(templateName)
+}
+
+// imageCacheEntry is one decoded template image in the LRU
+type imageCacheEntry struct {
+	name    string
+	version string
+	img     image.Image
+	bytes   int64
+}
+
+// imageCache is an in-memory LRU of decoded template images bounded by their pixel size
+type imageCache struct {
+	mu        sync.Mutex
+	maxBytes  int64
+	bytes     int64
+	order     *list.List
+	items     map[string]*list.Element
+	hits      int64
+	misses    int64
+	evictions int64
+}
+
+// newImageCache creates an empty decoded image cache
+func newImageCache(maxBytes int64) *imageCache {
+	return &imageCache{
+		maxBytes: maxBytes,
+		order:    list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+// get returns the cached image of a template if it was decoded from the same image version
+func (c *imageCache) get(name, version string) (image.Image, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[name]
+	if !ok || elem.Value.(*imageCacheEntry).version != version {
+		c.misses++
+		return nil, false
+	}
+
+	c.hits++
+	c.order.MoveToFront(elem)
+	return elem.Value.(*imageCacheEntry).img, true
+}
+
+// put stores a decoded template image, evicting the least recently used ones over the limit
+func (c *imageCache) put(name, version string, img image.Image) {
+	bounds := img.Bounds()
+	size := int64(bounds.Dx()) * int64(bounds.Dy()) * 4
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.items[name]; ok {
+		c.removeElement(elem)
+	}
+	if size > c.maxBytes {
+		return
+	}
+
+	c.items[name] = c.order.PushFront(&imageCacheEntry{name: name, version: version, img: img, bytes: size})
+	c.bytes += size
+	c.evict()
+}
+
+// remove drops a template from the cache
+func (c *imageCache) remove(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.items[name]; ok {
+		c.removeElement(elem)
+	}
+}
+
+// resize changes the byte limit and evicts entries that no longer fit
+func (c *imageCache) resize(maxBytes int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.maxBytes = maxBytes
+	c.evict()
+}
+
+// purge drops every cached image
+func (c *imageCache) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.order.Init()
+	c.items = make(map[string]*list.Element)
+	c.bytes = 0
+}
+
+// stats returns the counters of the cache
+func (c *imageCache) stats() CacheLevelStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return CacheLevelStats{
+		Entries:   len(c.items),
+		Bytes:     c.bytes,
+		MaxBytes:  c.maxBytes,
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Evictions: c.evictions,
+	}
+}
+
+// evict drops least recently used entries until the cache fits its limit; the caller holds the lock
+func (c *imageCache) evict() {
+	for c.bytes > c.maxBytes && c.order.Len() > 0 {
+		c.removeElement(c.order.Back())
+		c.evictions++
+	}
+}
+
+// removeElement unlinks an entry; the caller holds the lock
+func (c *imageCache) removeElement(elem *list.Element) {
+	entry := c.order.Remove(elem).(*imageCacheEntry)
+	delete(c.items, entry.name)
+	c.bytes -= entry.bytes
+}
+
+// tempSuffix marks render cache files that are still being written
+const tempSuffix = ".tmp-"
+
+// diskCache stores encoded renders on disk keyed by a hash of every render input
+type diskCache struct {
+	mu        sync.Mutex
+	dir       string
+	maxBytes  int64
+	bytes     int64
+	entries   int
+	scanned   bool
+	hits      int64
+	misses    int64
+	evictions int64
+}
+
+// newDiskCache creates an on-disk render cache rooted at dir
+func newDiskCache(dir string, maxBytes int64) *diskCache {
+	return &diskCache{dir: dir, maxBytes: maxBytes}
+}
+
+// renderKey hashes all inputs that affect the encoded output of a render
+func renderKey(parts ...string) string {
+	h := sha256.New()
+	h.Write([]byte(RendererVersion))
+	for _, part := range parts {
+		// Length prefixes keep ("ab", "c") and ("a", "bc") apart
+		fmt.Fprintf(h, "\x00%d:%s", len(part), part)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// enabled reports whether the render cache is configured
+func (c *diskCache) enabled() bool {
+	return c.dir != "" && c.maxBytes > 0
+}
+
+// path returns the sharded file path of a cache entry
+func (c *diskCache) path(key string) string {
+	return filepath.Join(c.dir, key[:2], key)
+}
+
+// get returns a cached render and refreshes its access time
+func (c *diskCache) get(key string) ([]byte, bool) {
+	if !c.enabled() || key == "" {
+		return nil, false
+	}
+
+	data, err := os.ReadFile(c.path(key))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err != nil {
+		c.misses++
+		return nil, false
+	}
+	c.hits++
+
+	// The modification time doubles as the last access time for eviction
+	now := time.Now()
+	_ = os.Chtimes(c.path(key), now, now)
+	return data, true
+}
+
+// put stores an encoded render, evicting the oldest entries over the limit
+func (c *diskCache) put(key string, data []byte) error {
+	if !c.enabled() || int64(len(data)) > c.maxBytes {
+		return nil
+	}
+
+	path := c.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create render cache directory: %w", err)
+	}
+
+	// Write to a temporary file first so concurrent readers never see a partial render
+	tmp, err := os.CreateTemp(filepath.Dir(path), key+tempSuffix+"*")
+	if err != nil {
+		return fmt.Errorf("failed to create render cache file: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write render cache file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write render cache file: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.scan()
+	if info, err := os.Stat(path); err == nil {
+		c.bytes -= info.Size()
+		c.entries--
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to store render cache file: %w", err)
+	}
+	c.bytes += int64(len(data))
+	c.entries++
+
+	if c.bytes > c.maxBytes {
+		c.evict()
+	}
+	return nil
+}
+
+// purge removes every cached render
+func (c *diskCache) purge() error {
+	if c.dir == "" {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := os.RemoveAll(c.dir); err != nil {
+		return fmt.Errorf("failed to purge render cache: %w", err)
+	}
+	c.bytes = 0
+	c.entries = 0
+	c.scanned = true
+	return nil
+}
+
+// stats returns the counters of the cache
+func (c *diskCache) stats() CacheLevelStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.enabled() {
+		c.scan()
+	}
+	return CacheLevelStats{
+		Entries:   c.entries,
+		Bytes:     c.bytes,
+		MaxBytes:  c.maxBytes,
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Evictions: c.evictions,
+	}
+}
+
+// cacheFile is a render cache entry considered for eviction
+type cacheFile struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// listFiles returns every entry of the cache directory; the caller holds the lock
+func (c *diskCache) listFiles() []cacheFile {
+	var files []cacheFile
+	filepath.WalkDir(c.dir, func(path string, d fs.DirEntry, err error) error {
+		// Skip directories and temporary files of renders still being written
+		if err != nil || d.IsDir() || strings.Contains(d.Name(), tempSuffix) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		files = append(files, cacheFile{path: path, size: info.Size(), modTime: info.ModTime()})
+		return nil
+	})
+	return files
+}
+
+// scan computes the size of renders already on disk the first time the cache is used; the caller holds the lock
+func (c *diskCache) scan() {
+	if c.scanned {
+		return
+	}
+	c.scanned = true
+
+	for _, file := range c.listFiles() {
+		c.bytes += file.size
+		c.entries++
+	}
+}
+
+// evict removes the least recently used renders until the cache fits its limit; the caller holds the lock
+func (c *diskCache) evict() {
+	files := c.listFiles()
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.Before(files[j].modTime)
+	})
+
+	c.bytes, c.entries = 0, len(files)
+	for _, file := range files {
+		c.bytes += file.size
+	}
+
+	for _, file := range files {
+		if c.bytes <= c.maxBytes {
+			break
+		}
+		if err := os.Remove(file.path); err != nil {
+			continue
+		}
+		c.bytes -= file.size
+		c.entries--
+		c.evictions++
+	}
+}
@@ -0,0 +1,328 @@
+package meme
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strings"
+)
+
+// RendererVersion is the renderer version recorded in provenance metadata
+const RendererVersion = "1.1.0"
+
+// ErrNoProvenance is returned when a file carries no provenance metadata
+var ErrNoProvenance = errors.New("no provenance metadata found")
+
+// Provenance describes where a generated meme file came from
+type Provenance struct {
+	MemeID          string `json:"meme_id"`
+	Template        string `json:"template"`
+	TextTop         string `json:"text_top"`
+	TextBottom      string `json:"text_bottom"`
+	RendererVersion string `json:"renderer_version"`
+	ServerURL       string `json:"server_url,omitempty"`
+}
+
+// PNG iTXt keywords used for each provenance field
+const (
+	keyMemeID          = "meme:id"
+	keyTemplate        = "meme:template"
+	keyTextTop         = "meme:text_top"
+	keyTextBottom      = "meme:text_bottom"
+	keyRendererVersion = "meme:renderer_version"
+	keyServerURL       = "meme:server_url"
+)
+
+// jpegCommentPrefix marks the JPEG COM segment that holds provenance JSON
+const jpegCommentPrefix = "memes-generator provenance\n"
+
+// maxPNGTextChunk is the largest PNG text chunk read for provenance. Chunk lengths come from the file, so
+// larger ones are rejected before allocating rather than trusted.
+const maxPNGTextChunk = 1 << 20
+
+var (
+	pngSignature = []byte("\x89PNG\r\n\x1a\n")
+	jpegSOI      = []byte{0xFF, 0xD8}
+)
+
+// fields returns the provenance as ordered PNG keyword/value pairs
+func (p *Provenance) fields() [][2]string {
+	fields := [][2]string{
+		{keyMemeID, p.MemeID},
+		{keyTemplate, p.Template},
+		{keyTextTop, p.TextTop},
+		{keyTextBottom, p.TextBottom},
+		{keyRendererVersion, p.RendererVersion},
+	}
+	if p.ServerURL != "" {
+		fields = append(fields, [2]string{keyServerURL, p.ServerURL})
+	}
+	return fields
+}
+
+// set assigns a provenance field by its PNG keyword
+func (p *Provenance) set(key, value string) bool {
+	switch key {
+	case keyMemeID:
+		p.MemeID = value
+	case keyTemplate:
+		p.Template = value
+	case keyTextTop:
+		p.TextTop = value
+	case keyTextBottom:
+		p.TextBottom = value
+	case keyRendererVersion:
+		p.RendererVersion = value
+	case keyServerURL:
+		p.ServerURL = value
+	default:
+		return false
+	}
+	return true
+}
+
+// EmbedProvenance returns a copy of an encoded PNG or JPEG image with provenance metadata added
+func EmbedProvenance(data []byte, p *Provenance) ([]byte, error) {
+	if p == nil {
+		return data, nil
+	}
+	if p.RendererVersion == "" {
+		withVersion := *p
+		withVersion.RendererVersion = RendererVersion
+		p = &withVersion
+	}
+
+	switch {
+	case bytes.HasPrefix(data, pngSignature):
+		return embedPNG(data, p)
+	case bytes.HasPrefix(data, jpegSOI):
+		return embedJPEG(data, p)
+	default:
+		return nil, fmt.Errorf("unsupported image format for provenance metadata")
+	}
+}
+
+// ReadProvenance extracts provenance metadata from an encoded PNG or JPEG image
+func ReadProvenance(r io.Reader) (*Provenance, error) {
+	br := bufio.NewReader(r)
+	header, err := br.Peek(len(pngSignature))
+	if err != nil && len(header) < len(jpegSOI) {
+		return nil, fmt.Errorf("failed to read image header: %w", err)
+	}
+
+	switch {
+	case bytes.HasPrefix(header, pngSignature):
+		return readPNGProvenance(br)
+	case bytes.HasPrefix(header, jpegSOI):
+		return readJPEGProvenance(br)
+	default:
+		return nil, fmt.Errorf("unsupported image format: only PNG and JPEG are supported")
+	}
+}
+
+// embedPNG inserts tEXt/iTXt chunks right after the IHDR chunk
+func embedPNG(data []byte, p *Provenance) ([]byte, error) {
+	// Signature (8) + IHDR length (4) + type (4) + data (13) + CRC (4)
+	const ihdrEnd = 8 + 4 + 4 + 13 + 4
+	if len(data) < ihdrEnd || string(data[12:16]) != "IHDR" {
+		return nil, fmt.Errorf("malformed PNG: IHDR chunk not found")
+	}
+
+	var chunks bytes.Buffer
+	writePNGChunk(&chunks, "tEXt", []byte("Software\x00memes-generator "+p.RendererVersion))
+	for _, field := range p.fields() {
+		writePNGChunk(&chunks, "iTXt", iTXtData(field[0], field[1]))
+	}
+
+	out := make([]byte, 0, len(data)+chunks.Len())
+	out = append(out, data[:ihdrEnd]...)
+	out = append(out, chunks.Bytes()...)
+	out = append(out, data[ihdrEnd:]...)
+	return out, nil
+}
+
+// iTXtData builds an uncompressed iTXt payload holding UTF-8 text
+func iTXtData(keyword, text string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(keyword)
+	buf.WriteByte(0) // keyword terminator
+	buf.WriteByte(0) // compression flag: uncompressed
+	buf.WriteByte(0) // compression method
+	buf.WriteByte(0) // empty language tag
+	buf.WriteByte(0) // empty translated keyword
+	buf.WriteString(text)
+	return buf.Bytes()
+}
+
+// writePNGChunk writes a single PNG chunk with its length and CRC
+func writePNGChunk(w *bytes.Buffer, chunkType string, data []byte) {
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(data)))
+	w.Write(length[:])
+
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(chunkType))
+	crc.Write(data)
+
+	w.WriteString(chunkType)
+	w.Write(data)
+
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], crc.Sum32())
+	w.Write(sum[:])
+}
+
+// readPNGProvenance walks PNG chunks and collects provenance text entries
+func readPNGProvenance(r io.Reader) (*Provenance, error) {
+	if _, err := io.CopyN(io.Discard, r, int64(len(pngSignature))); err != nil {
+		return nil, fmt.Errorf("failed to read PNG signature: %w", err)
+	}
+
+	var p Provenance
+	found := false
+	for {
+		var header [8]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			break
+		}
+		length := binary.BigEndian.Uint32(header[:4])
+		chunkType := string(header[4:8])
+
+		if chunkType == "IDAT" || chunkType == "IEND" {
+			break
+		}
+
+		if chunkType != "tEXt" && chunkType != "iTXt" {
+			if _, err := io.CopyN(io.Discard, r, int64(length)+4); err != nil {
+				return nil, fmt.Errorf("failed to skip PNG chunk: %w", err)
+			}
+			continue
+		}
+
+		if length > maxPNGTextChunk {
+			return nil, fmt.Errorf("PNG %s chunk of %d bytes is larger than %d bytes", chunkType, length, maxPNGTextChunk)
+		}
+		data := make([]byte, length)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, fmt.Errorf("failed to read PNG text chunk: %w", err)
+		}
+		if _, err := io.CopyN(io.Discard, r, 4); err != nil {
+			return nil, fmt.Errorf("failed to read PNG chunk CRC: %w", err)
+		}
+
+		key, value, ok := parsePNGText(chunkType, data)
+		if ok && p.set(key, value) {
+			found = true
+		}
+	}
+
+	if !found {
+		return nil, ErrNoProvenance
+	}
+	return &p, nil
+}
+
+// parsePNGText decodes the keyword and text of an uncompressed tEXt or iTXt chunk
+func parsePNGText(chunkType string, data []byte) (string, string, bool) {
+	keyword, rest, ok := bytes.Cut(data, []byte{0})
+	if !ok {
+		return "", "", false
+	}
+	if chunkType == "tEXt" {
+		return string(keyword), string(rest), true
+	}
+
+	// iTXt: compression flag, compression method, language tag, translated keyword, text
+	if len(rest) < 2 || rest[0] != 0 {
+		return "", "", false
+	}
+	rest = rest[2:]
+	if _, rest, ok = bytes.Cut(rest, []byte{0}); !ok {
+		return "", "", false
+	}
+	if _, rest, ok = bytes.Cut(rest, []byte{0}); !ok {
+		return "", "", false
+	}
+	return string(keyword), string(rest), true
+}
+
+// embedJPEG inserts a COM segment with provenance JSON right after SOI
+func embedJPEG(data []byte, p *Provenance) ([]byte, error) {
+	payload, err := json.Marshal(p)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode provenance: %w", err)
+	}
+	comment := append([]byte(jpegCommentPrefix), payload...)
+	if len(comment)+2 > 0xFFFF {
+		return nil, fmt.Errorf("provenance metadata too large for a JPEG comment")
+	}
+
+	segment := []byte{0xFF, 0xFE, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(comment)+2))
+	segment = append(segment, comment...)
+
+	out := make([]byte, 0, len(data)+len(segment))
+	out = append(out, data[:len(jpegSOI)]...)
+	out = append(out, segment...)
+	out = append(out, data[len(jpegSOI):]...)
+	return out, nil
+}
+
+// readJPEGProvenance walks JPEG marker segments up to the scan data looking for the provenance comment
+func readJPEGProvenance(r io.Reader) (*Provenance, error) {
+	if _, err := io.CopyN(io.Discard, r, int64(len(jpegSOI))); err != nil {
+		return nil, fmt.Errorf("failed to read JPEG header: %w", err)
+	}
+
+	for {
+		var marker [2]byte
+		if _, err := io.ReadFull(r, marker[:]); err != nil {
+			break
+		}
+		if marker[0] != 0xFF {
+			return nil, fmt.Errorf("malformed JPEG: expected marker")
+		}
+		// Start of scan or end of image: no more metadata segments
+		if marker[1] == 0xDA || marker[1] == 0xD9 {
+			break
+		}
+
+		var length [2]byte
+		if _, err := io.ReadFull(r, length[:]); err != nil {
+			return nil, fmt.Errorf("failed to read JPEG segment length: %w", err)
+		}
+		size := int(binary.BigEndian.Uint16(length[:])) - 2
+		if size < 0 {
+			return nil, fmt.Errorf("malformed JPEG: invalid segment length")
+		}
+
+		if marker[1] != 0xFE {
+			if _, err := io.CopyN(io.Discard, r, int64(size)); err != nil {
+				return nil, fmt.Errorf("failed to skip JPEG segment: %w", err)
+			}
+			continue
+		}
+
+		comment := make([]byte, size)
+		if _, err := io.ReadFull(r, comment); err != nil {
+			return nil, fmt.Errorf("failed to read JPEG comment: %w", err)
+		}
+		payload, ok := strings.CutPrefix(string(comment), jpegCommentPrefix)
+		if !ok {
+			continue
+		}
+
+		var p Provenance
+		if err := json.Unmarshal([]byte(payload), &p); err != nil {
+			return nil, fmt.Errorf("failed to decode provenance: %w", err)
+		}
+		return &p, nil
+	}
+
+	return nil, ErrNoProvenance
+}
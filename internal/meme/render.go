@@ -0,0 +1,255 @@
+package meme
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Output formats supported by Render
+const (
+	FormatPNG  = "png"
+	FormatJPEG = "jpeg"
+)
+
+// ErrUnsupportedFormat is returned when a render asks for an output format Render cannot encode
+var ErrUnsupportedFormat = errors.New("unsupported output format")
+
+// RenderSpec describes everything that goes into a rendered meme
+type RenderSpec struct {
+	// Background is the image the captions are drawn on; nil renders the default gray template
+	Background image.Image
+
+	// CacheKey identifies the background version; renders are only cached when it is set
+	CacheKey string
+
+	TextTop    string
+	TextBottom string
+
+	// Layout and Style control where and how the captions are drawn
+	Layout Layout
+	Style  TextStyle
+
+	// Format is FormatPNG or FormatJPEG; empty means PNG
+	Format string
+
+	// Provenance is embedded into the encoded output when set
+	Provenance *Provenance
+
+	// loadBackground lazily loads Background so cached renders skip decoding the template
+	loadBackground func() (image.Image, error)
+}
+
+// RenderResult describes a rendered meme written by Render
+type RenderResult struct {
+	Format      string
+	ContentType string
+	Width       int
+	Height      int
+	Bytes       int64
+	CacheHit    bool
+}
+
+// ContentType returns the MIME type of an output format
+func ContentType(format string) string {
+	if normalizeFormat(format) == FormatJPEG {
+		return "image/jpeg"
+	}
+	return "image/png"
+}
+
+// Render draws the captions of spec onto its background and writes the encoded image to w.
+// The output is fully encoded before anything is written, so w receives nothing when an error is returned.
+func Render(ctx context.Context, spec RenderSpec, w io.Writer) (RenderResult, error) {
+	format := normalizeFormat(spec.Format)
+	if format == "" {
+		return RenderResult{}, fmt.Errorf("%w: %s", ErrUnsupportedFormat, spec.Format)
+	}
+	if err := ctx.Err(); err != nil {
+		return RenderResult{}, err
+	}
+
+	result := RenderResult{Format: format, ContentType: ContentType(format)}
+
+	var key string
+	if spec.CacheKey != "" {
+		key = renderKey(spec.CacheKey, spec.TextTop, spec.TextBottom, format, cacheKey(spec.Layout, spec.Style))
+	}
+
+	data, ok := renderCache.get(key)
+	if ok {
+		result.CacheHit = true
+		if cfg, _, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+			result.Width, result.Height = cfg.Width, cfg.Height
+		}
+	} else {
+		img, err := drawMeme(spec)
+		if err != nil {
+			return RenderResult{}, err
+		}
+		if err := ctx.Err(); err != nil {
+			return RenderResult{}, err
+		}
+
+		if data, err = encodeRaw(img, format); err != nil {
+			return RenderResult{}, err
+		}
+		result.Width, result.Height = img.Bounds().Dx(), img.Bounds().Dy()
+
+		if key != "" {
+			// A failing cache must not fail the render itself
+			_ = renderCache.put(key, data)
+		}
+	}
+
+	// Provenance differs per meme, so it is embedded after the shared render is taken from the cache
+	data, err := EmbedProvenance(data, spec.Provenance)
+	if err != nil {
+		return RenderResult{}, fmt.Errorf("failed to embed provenance: %w", err)
+	}
+
+	n, err := w.Write(data)
+	result.Bytes = int64(n)
+	if err != nil {
+		return result, fmt.Errorf("failed to write image: %w", err)
+	}
+
+	return result, nil
+}
+
+// drawMeme draws the captions of spec onto a copy of its background
+func drawMeme(spec RenderSpec) (*image.RGBA, error) {
+	background := spec.Background
+	if background == nil && spec.loadBackground != nil {
+		var err error
+		if background, err = spec.loadBackground(); err != nil {
+			return nil, fmt.Errorf("failed to load template image: %w", err)
+		}
+	}
+
+	var img *image.RGBA
+	if background != nil {
+		// Create a new RGBA image from the background
+		bounds := background.Bounds()
+		img = image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+		draw.Draw(img, img.Bounds(), background, bounds.Min, draw.Src)
+	} else {
+		img = defaultBackground()
+	}
+
+	// Add text to the image
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+	generator := &Generator{}
+	textTop, textBottom := spec.TextTop, spec.TextBottom
+	if spec.Style.Uppercase {
+		textTop, textBottom = strings.ToUpper(textTop), strings.ToUpper(textBottom)
+	}
+	if textTop != "" {
+		generator.addText(img, textTop, width/2, captionY(spec.Layout.Top, defaultCaptionTop, height), width, height, spec.Style)
+	}
+
+	if textBottom != "" {
+		generator.addText(img, textBottom, width/2, captionY(spec.Layout.Bottom, defaultCaptionBottom, height), width, height, spec.Style)
+	}
+
+	return img, nil
+}
+
+// defaultBackground creates the light gray template used when no template image is available
+func defaultBackground() *image.RGBA {
+	width, height := 800, 600
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	// Fill with a light gray background
+	bgColor := color.RGBA{200, 200, 200, 255}
+	draw.Draw(img, img.Bounds(), &image.Uniform{bgColor}, image.Point{}, draw.Src)
+
+	// Add border
+	borderColor := color.RGBA{0, 0, 0, 255}
+	for i := 0; i < width; i++ {
+		img.Set(i, 0, borderColor)
+		img.Set(i, height-1, borderColor)
+	}
+	for i := 0; i < height; i++ {
+		img.Set(0, i, borderColor)
+		img.Set(width-1, i, borderColor)
+	}
+
+	return img
+}
+
+// RenderFile renders spec into outputPath, creating its directory when needed
+func RenderFile(ctx context.Context, spec RenderSpec, outputPath string) error {
+	// Create output directory if it doesn't exist
+	if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	// Render in memory first so a failed render leaves no empty file behind
+	var buf bytes.Buffer
+	if _, err := Render(ctx, spec, &buf); err != nil {
+		return err
+	}
+
+	// Replace the output through a synced temporary file so a crash never leaves a truncated image
+	tmp, err := os.CreateTemp(filepath.Dir(outputPath), ".tmp-"+filepath.Base(outputPath)+"-*")
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %w", err)
+	}
+	_, err = tmp.Write(buf.Bytes())
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), outputPath)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write output file: %w", err)
+	}
+	return nil
+}
+
+// encodeRaw encodes an image in the given output format
+func encodeRaw(img image.Image, format string) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case FormatJPEG:
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90})
+	default:
+		err = png.Encode(&buf, img)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode image: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// normalizeFormat maps format names and file extensions to an output format, or "" when unsupported
+func normalizeFormat(format string) string {
+	switch strings.TrimPrefix(strings.ToLower(format), ".") {
+	case "", "png":
+		return FormatPNG
+	case "jpg", "jpeg":
+		return FormatJPEG
+	default:
+		return ""
+	}
+}
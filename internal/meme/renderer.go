@@ -0,0 +1,106 @@
+package meme
+
+import (
+	"fmt"
+	"image"
+	_ "image/gif"
+	"io"
+)
+
+// ImageInfo describes a stored image
+type ImageInfo struct {
+	Name        string
+	ContentType string
+	Size        int64
+
+	// Version changes whenever the stored image changes
+	Version string
+}
+
+// TemplateSource provides template images and immutable template image snapshots to the renderer.
+// It is implemented by the image stores behind domain.ImageStore.
+type TemplateSource interface {
+	StatTemplateImage(name string) (ImageInfo, error)
+	OpenTemplateImage(name string) (io.ReadCloser, ImageInfo, error)
+	OpenSnapshot(hash string) (io.ReadCloser, ImageInfo, error)
+}
+
+// Renderer renders memes on top of template images read from a TemplateSource
+type Renderer struct {
+	templates TemplateSource
+}
+
+// NewRenderer creates a renderer reading template images from templates
+func NewRenderer(templates TemplateSource) *Renderer {
+	return &Renderer{
+		templates: templates,
+	}
+}
+
+// TemplateSpec returns a render spec whose background is the image of a template.
+// The image is only decoded when the render is not cached yet.
+func (r *Renderer) TemplateSpec(templateName string) (RenderSpec, error) {
+	info, err := r.templates.StatTemplateImage(templateName)
+	if err != nil {
+		return RenderSpec{}, err
+	}
+
+	return RenderSpec{
+		CacheKey: templateName + "\x00" + info.Version,
+		loadBackground: func() (image.Image, error) {
+			return r.loadTemplateImage(templateName, info.Version)
+		},
+	}, nil
+}
+
+// SnapshotSpec returns a render spec whose background is a template image snapshot.
+// Snapshots never change, so their renders are cached under the content hash alone.
+func (r *Renderer) SnapshotSpec(hash string) RenderSpec {
+	return RenderSpec{
+		CacheKey: "snapshot\x00" + hash,
+		loadBackground: func() (image.Image, error) {
+			return r.loadImage("snapshot/"+hash, hash, func() (io.ReadCloser, ImageInfo, error) {
+				return r.templates.OpenSnapshot(hash)
+			})
+		},
+	}
+}
+
+// LoadTemplateImage loads a template image by name
+func (r *Renderer) LoadTemplateImage(templateName string) (image.Image, error) {
+	info, err := r.templates.StatTemplateImage(templateName)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.loadTemplateImage(templateName, info.Version)
+}
+
+// loadTemplateImage returns the decoded template image from the cache, decoding it on a miss
+func (r *Renderer) loadTemplateImage(templateName, version string) (image.Image, error) {
+	return r.loadImage(templateName, version, func() (io.ReadCloser, ImageInfo, error) {
+		return r.templates.OpenTemplateImage(templateName)
+	})
+}
+
+// loadImage returns a decoded image from the template cache, decoding the image opened by open on a miss
+func (r *Renderer) loadImage(name, version string, open func() (io.ReadCloser, ImageInfo, error)) (image.Image, error) {
+	if img, ok := templateCache.get(name, version); ok {
+		return img, nil
+	}
+
+	file, info, err := open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode template image: %w", err)
+	}
+
+	// Cache under the version actually read, which may be newer than the one asked for
+	templateCache.put(name, info.Version, img)
+	return img, nil
+}
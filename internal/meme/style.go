@@ -0,0 +1,105 @@
+package meme
+
+import (
+	"fmt"
+	"image/color"
+	"strconv"
+)
+
+const (
+	// defaultCaptionTop and defaultCaptionBottom are the caption baselines as fractions of the image height
+	defaultCaptionTop    = 0.07
+	defaultCaptionBottom = 0.93
+
+	// defaultBoxOpacity is the opacity of the white box drawn behind captions
+	defaultBoxOpacity = 128
+)
+
+// Layout places the captions on the image. Positions are the caption baselines as fractions of the
+// image height measured from the top; zero keeps the default position.
+type Layout struct {
+	Top    float64 `json:"top,omitempty"`
+	Bottom float64 `json:"bottom,omitempty"`
+}
+
+// TextStyle is the look of the captions. Colors are "#rrggbb"; empty fields keep the classic white text
+// with a black outline on a half-transparent white box.
+type TextStyle struct {
+	Color        string `json:"color,omitempty"`
+	OutlineColor string `json:"outline_color,omitempty"`
+	BoxOpacity   *int   `json:"box_opacity,omitempty"`
+	Uppercase    bool   `json:"uppercase,omitempty"`
+}
+
+// IsZero reports whether the layout keeps the default caption positions
+func (l Layout) IsZero() bool {
+	return l == Layout{}
+}
+
+// IsZero reports whether the style keeps the default look
+func (s TextStyle) IsZero() bool {
+	return s.Color == "" && s.OutlineColor == "" && s.BoxOpacity == nil && !s.Uppercase
+}
+
+// Validate checks that the caption positions lie within the image
+func (l Layout) Validate() error {
+	for _, position := range []float64{l.Top, l.Bottom} {
+		if position < 0 || position >= 1 {
+			return fmt.Errorf("caption position %v must be between 0 and 1", position)
+		}
+	}
+	return nil
+}
+
+// Validate checks the colors and box opacity of a style
+func (s TextStyle) Validate() error {
+	for _, c := range []string{s.Color, s.OutlineColor} {
+		if _, err := parseColor(c, color.RGBA{}); err != nil {
+			return err
+		}
+	}
+	if s.BoxOpacity != nil && (*s.BoxOpacity < 0 || *s.BoxOpacity > 255) {
+		return fmt.Errorf("box opacity %d must be between 0 and 255", *s.BoxOpacity)
+	}
+	return nil
+}
+
+// captionY returns the baseline of a caption at position, or at fallback when position is unset
+func captionY(position, fallback float64, height int) int {
+	if position == 0 {
+		position = fallback
+	}
+	return int(position * float64(height))
+}
+
+// colors returns the text and outline colors and the box opacity of a style with defaults applied
+func (s TextStyle) colors() (text, outline color.RGBA, boxOpacity int) {
+	text, _ = parseColor(s.Color, color.RGBA{255, 255, 255, 255})
+	outline, _ = parseColor(s.OutlineColor, color.RGBA{0, 0, 0, 255})
+	boxOpacity = defaultBoxOpacity
+	if s.BoxOpacity != nil {
+		boxOpacity = *s.BoxOpacity
+	}
+	return text, outline, boxOpacity
+}
+
+// cacheKey identifies the rendered look of a layout and style in render cache keys
+func cacheKey(l Layout, s TextStyle) string {
+	text, outline, boxOpacity := s.colors()
+	return fmt.Sprintf("%g/%g/%x/%x/%d/%t", l.Top, l.Bottom, text, outline, boxOpacity, s.Uppercase)
+}
+
+// parseColor parses a "#rrggbb" color, returning fallback for an empty string
+func parseColor(s string, fallback color.RGBA) (color.RGBA, error) {
+	if s == "" {
+		return fallback, nil
+	}
+	if len(s) != 7 || s[0] != '#' {
+		return fallback, fmt.Errorf("color %q must have the form #rrggbb", s)
+	}
+	v, err := strconv.ParseUint(s[1:], 16, 32)
+	if err != nil {
+		return fallback, fmt.Errorf("color %q must have the form #rrggbb", s)
+	}
+	return color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 255}, nil
+}
@@ -0,0 +1,719 @@
+package repository
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"memes-generator/internal/domain"
+	"memes-generator/internal/meme"
+)
+
+// manifestName is the archive path of the backup manifest, written after every other file
+const manifestName = "manifest.json"
+
+// snapshotAttempts bounds how often an entity is re-read when it keeps changing during a backup
+const snapshotAttempts = 5
+
+// BackupRepository implements domain.BackupRepository on top of the repositories of a storage backend,
+// so archives can be moved between backends. Archives hold memes/<id>/ and templates/<name>/ directories
+// with metadata.json and the current image, the template snapshots of the memes as snapshots/<sha256>,
+// and a manifest.json with the checksum of every file.
+type BackupRepository struct {
+	storage *Storage
+}
+
+// NewBackupRepository creates a new backup repository for a storage backend
+func NewBackupRepository(storage *Storage) *BackupRepository {
+	return &BackupRepository{
+		storage: storage,
+	}
+}
+
+// entitySnapshot is the metadata and image of one meme or template read consistently
+type entitySnapshot struct {
+	metadata []byte
+	image    []byte
+	info     meme.ImageInfo
+}
+
+// WriteBackup streams a tar.gz archive of all memes and templates to w.
+// The backend keeps accepting writes meanwhile: both listings are taken before anything is archived and
+// entities created after the backup started are left out, while every listed entity is captured
+// consistently as it is when read, by re-reading its metadata after its image until both agree.
+// The manifest is written last, so a truncated archive is rejected by Restore.
+func (r *BackupRepository) WriteBackup(w io.Writer) (*domain.BackupManifest, error) {
+	manifest := &domain.BackupManifest{
+		FormatVersion:         domain.BackupFormatVersion,
+		CreatedAt:             time.Now().UTC(),
+		MemeSchemaVersion:     domain.MemeSchemaVersion,
+		TemplateSchemaVersion: domain.TemplateSchemaVersion,
+		Files:                 []domain.BackupFile{},
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	templates, err := r.storage.Templates.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list templates: %w", err)
+	}
+	memes, err := r.storage.Memes.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list memes: %w", err)
+	}
+
+	// Templates go first so that a restore recreates them before the memes using them. The images of
+	// earlier template versions are archived as snapshots like those of memes.
+	snapshots := make(map[string]bool)
+	for _, template := range templates {
+		if template.CreatedAt.After(manifest.CreatedAt) {
+			continue
+		}
+		name := template.Name
+		snapshot, err := snapshotEntity(
+			func() (any, error) { return currentTemplate(r.storage.Templates.GetByName(name)) },
+			func() (io.ReadCloser, meme.ImageInfo, error) { return r.storage.Images.OpenTemplateImage(name) },
+		)
+		if errors.Is(err, domain.ErrTemplateNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to back up template %s: %w", name, err)
+		}
+		var captured domain.Template
+		if err := json.Unmarshal(snapshot.metadata, &captured); err != nil {
+			return nil, fmt.Errorf("failed to back up template %s: %w", name, err)
+		}
+		// A template deleted and created again under its name since the listing is left out as well
+		if captured.CreatedAt.After(manifest.CreatedAt) {
+			continue
+		}
+		if err := writeSnapshot(tw, manifest, "templates/"+name, snapshot); err != nil {
+			return nil, err
+		}
+		manifest.Templates++
+
+		for _, blob := range templateBlobs(&captured) {
+			if captured.Image == nil || blob != captured.Image.Blob {
+				snapshots[blob] = true
+			}
+		}
+	}
+
+	for _, m := range memes {
+		if m.CreatedAt.After(manifest.CreatedAt) {
+			continue
+		}
+		id := m.ID
+		snapshot, err := snapshotEntity(
+			func() (any, error) { return currentMeme(r.storage.Memes.GetByID(id)) },
+			func() (io.ReadCloser, meme.ImageInfo, error) { return r.storage.Images.OpenMemeImage(id) },
+		)
+		if errors.Is(err, domain.ErrMemeNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to back up meme %s: %w", id, err)
+		}
+		if err := writeSnapshot(tw, manifest, "memes/"+id, snapshot); err != nil {
+			return nil, err
+		}
+		manifest.Memes++
+
+		var captured domain.Meme
+		if err := json.Unmarshal(snapshot.metadata, &captured); err == nil && captured.TemplateSnapshot != nil {
+			snapshots[captured.TemplateSnapshot.Image] = true
+		}
+	}
+
+	// Snapshots are immutable, so they need no consistency checks; missing ones are left to fsck
+	for _, hash := range sortedKeys(snapshots) {
+		written, err := r.writeTemplateSnapshot(tw, manifest, hash)
+		if err != nil {
+			return nil, err
+		}
+		if written {
+			manifest.Snapshots++
+		}
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode backup manifest: %w", err)
+	}
+	if err := writeTarFile(tw, manifestName, data); err != nil {
+		return nil, err
+	}
+	if err := tw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to finish backup archive: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return nil, fmt.Errorf("failed to finish backup archive: %w", err)
+	}
+
+	return manifest, nil
+}
+
+// writeTemplateSnapshot adds a template image snapshot to the archive and the manifest, reporting
+// whether it was found
+func (r *BackupRepository) writeTemplateSnapshot(tw *tar.Writer, manifest *domain.BackupManifest, hash string) (bool, error) {
+	reader, info, err := r.storage.Images.OpenSnapshot(hash)
+	if errors.Is(err, domain.ErrImageNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to back up snapshot %s: %w", hash, err)
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return false, fmt.Errorf("failed to back up snapshot %s: %w", hash, err)
+	}
+	if err := addBackupFile(tw, manifest, "snapshots/"+hash, data, info.ContentType); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// snapshotEntity reads the metadata and image of an entity, retrying until the metadata read before and
+// after the image is the same so that the image belongs to the captured metadata
+func snapshotEntity(get func() (any, error), open func() (io.ReadCloser, meme.ImageInfo, error)) (*entitySnapshot, error) {
+	for attempt := 0; attempt < snapshotAttempts; attempt++ {
+		entity, err := get()
+		if err != nil {
+			return nil, err
+		}
+		metadata, err := json.MarshalIndent(entity, "", "  ")
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode metadata: %w", err)
+		}
+
+		snapshot := &entitySnapshot{metadata: append(metadata, '\n')}
+		src, info, err := open()
+		if err != nil && !errors.Is(err, domain.ErrImageNotFound) {
+			return nil, err
+		}
+		if err == nil {
+			snapshot.image, err = io.ReadAll(src)
+			src.Close()
+			if err != nil {
+				return nil, fmt.Errorf("failed to read image: %w", err)
+			}
+			snapshot.info = info
+		}
+
+		entity, err = get()
+		if err != nil {
+			return nil, err
+		}
+		after, err := json.MarshalIndent(entity, "", "  ")
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode metadata: %w", err)
+		}
+		if bytes.Equal(metadata, after) {
+			return snapshot, nil
+		}
+	}
+
+	return nil, fmt.Errorf("metadata kept changing during %d attempts", snapshotAttempts)
+}
+
+// currentMeme stamps a meme read for a backup with the current schema version; reads already upgraded it
+func currentMeme(m *domain.Meme, err error) (any, error) {
+	if err != nil {
+		return nil, err
+	}
+	m.SchemaVersion = domain.MemeSchemaVersion
+	return m, nil
+}
+
+// currentTemplate stamps a template read for a backup with the current schema version; reads already upgraded it
+func currentTemplate(template *domain.Template, err error) (any, error) {
+	if err != nil {
+		return nil, err
+	}
+	template.SchemaVersion = domain.TemplateSchemaVersion
+	return template, nil
+}
+
+// writeSnapshot adds the files of an entity snapshot to the archive and the manifest
+func writeSnapshot(tw *tar.Writer, manifest *domain.BackupManifest, dir string, snapshot *entitySnapshot) error {
+	if err := addBackupFile(tw, manifest, dir+"/metadata.json", snapshot.metadata, "application/json"); err != nil {
+		return err
+	}
+	if snapshot.image == nil {
+		return nil
+	}
+	return addBackupFile(tw, manifest, dir+"/images/"+snapshot.info.Name, snapshot.image, snapshot.info.ContentType)
+}
+
+// addBackupFile writes a file to the archive and records its checksum in the manifest
+func addBackupFile(tw *tar.Writer, manifest *domain.BackupManifest, name string, data []byte, contentType string) error {
+	if err := writeTarFile(tw, name, data); err != nil {
+		return err
+	}
+	manifest.Files = append(manifest.Files, domain.BackupFile{
+		Path:        name,
+		Size:        int64(len(data)),
+		SHA256:      sha256Hex(data),
+		ContentType: contentType,
+	})
+	return nil
+}
+
+// writeTarFile adds a regular file to an archive
+func writeTarFile(tw *tar.Writer, name string, data []byte) error {
+	header := &tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to write %s to backup archive: %w", name, err)
+	}
+	if _, err := tw.Write(data); err != nil {
+		return fmt.Errorf("failed to write %s to backup archive: %w", name, err)
+	}
+	return nil
+}
+
+// backupEntity is a meme or template extracted from a backup archive
+type backupEntity struct {
+	metadataPath string
+	imagePath    string
+	imageName    string
+	contentType  string
+}
+
+// Restore verifies a backup archive against its manifest and writes its memes and templates.
+// Nothing is written unless every file matches its checksum. In merge mode existing entities whose
+// metadata differs from the backup are kept and reported as conflicts. In replace mode the backup
+// overwrites them, and only once all of it is written are the memes and templates it does not hold
+// deleted, so a restore that fails part way never leaves less than was stored before.
+func (r *BackupRepository) Restore(src io.Reader, mode string) (*domain.RestoreReport, error) {
+	staging, err := os.MkdirTemp("", "memes-restore-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create staging directory: %w", err)
+	}
+	defer os.RemoveAll(staging)
+
+	manifest, checksums, err := extractBackup(src, staging)
+	if err != nil {
+		return nil, err
+	}
+
+	templates, memes, snapshots, err := verifyBackup(manifest, checksums)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &domain.RestoreReport{
+		Mode:      mode,
+		Conflicts: []domain.RestoreConflict{},
+	}
+
+	// Snapshots are content-addressed and never conflict, so they are restored in every mode
+	for _, hash := range sortedKeys(snapshots) {
+		if err := r.restoreSnapshot(staging, hash); err != nil {
+			return nil, err
+		}
+		report.Snapshots++
+	}
+	for _, name := range sortedKeys(templates) {
+		if err := r.restoreTemplate(staging, name, templates[name], mode, report); err != nil {
+			return nil, err
+		}
+	}
+	for _, id := range sortedKeys(memes) {
+		if err := r.restoreMeme(staging, id, memes[id], mode, report); err != nil {
+			return nil, err
+		}
+	}
+
+	if mode == domain.RestoreReplace {
+		if err := r.deleteMissing(templates, memes, report); err != nil {
+			return nil, err
+		}
+	}
+
+	return report, nil
+}
+
+// extractBackup unpacks an archive into a staging directory, returning its manifest and the checksum of
+// every other file
+func extractBackup(src io.Reader, staging string) (*domain.BackupManifest, map[string]domain.BackupFile, error) {
+	gz, err := gzip.NewReader(src)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", domain.ErrInvalidBackup, err)
+	}
+	defer gz.Close()
+
+	var manifest *domain.BackupManifest
+	checksums := make(map[string]domain.BackupFile)
+
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %v", domain.ErrInvalidBackup, err)
+		}
+		if header.Typeflag == tar.TypeDir {
+			continue
+		}
+		if header.Typeflag != tar.TypeReg {
+			return nil, nil, fmt.Errorf("%w: %s is not a regular file", domain.ErrInvalidBackup, header.Name)
+		}
+
+		if header.Name == manifestName {
+			manifest = &domain.BackupManifest{}
+			if err := json.NewDecoder(tr).Decode(manifest); err != nil {
+				return nil, nil, fmt.Errorf("%w: failed to decode manifest: %v", domain.ErrInvalidBackup, err)
+			}
+			continue
+		}
+
+		if !validBackupPath(header.Name) {
+			return nil, nil, fmt.Errorf("%w: unexpected file %s", domain.ErrInvalidBackup, header.Name)
+		}
+		if _, ok := checksums[header.Name]; ok {
+			return nil, nil, fmt.Errorf("%w: duplicate file %s", domain.ErrInvalidBackup, header.Name)
+		}
+
+		target := filepath.Join(staging, filepath.FromSlash(header.Name))
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return nil, nil, fmt.Errorf("failed to create staging directory: %w", err)
+		}
+		file, err := os.Create(target)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create staging file: %w", err)
+		}
+		hash := sha256.New()
+		size, err := io.Copy(io.MultiWriter(file, hash), tr)
+		file.Close()
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: failed to read %s: %v", domain.ErrInvalidBackup, header.Name, err)
+		}
+		checksums[header.Name] = domain.BackupFile{Path: header.Name, Size: size, SHA256: hex.EncodeToString(hash.Sum(nil))}
+	}
+
+	if manifest == nil {
+		return nil, nil, fmt.Errorf("%w: %s is missing, the archive may be truncated", domain.ErrInvalidBackup, manifestName)
+	}
+	if manifest.FormatVersion > domain.BackupFormatVersion {
+		return nil, nil, fmt.Errorf("%w: format version %d is newer than %d", domain.ErrInvalidBackup, manifest.FormatVersion, domain.BackupFormatVersion)
+	}
+
+	return manifest, checksums, nil
+}
+
+// verifyBackup checks the extracted files against the manifest and groups them by entity. Snapshots are
+// returned as a set of hashes, each checked against the checksum of its file.
+func verifyBackup(manifest *domain.BackupManifest, checksums map[string]domain.BackupFile) (map[string]*backupEntity, map[string]*backupEntity, map[string]bool, error) {
+	templates := make(map[string]*backupEntity)
+	memes := make(map[string]*backupEntity)
+	snapshots := make(map[string]bool)
+
+	listed := make(map[string]bool)
+	for _, file := range manifest.Files {
+		actual, ok := checksums[file.Path]
+		if !ok {
+			return nil, nil, nil, fmt.Errorf("%w: %s is listed in the manifest but missing", domain.ErrInvalidBackup, file.Path)
+		}
+		if actual.Size != file.Size || actual.SHA256 != file.SHA256 {
+			return nil, nil, nil, fmt.Errorf("%w: checksum mismatch for %s", domain.ErrInvalidBackup, file.Path)
+		}
+		listed[file.Path] = true
+
+		parts := strings.Split(file.Path, "/")
+		if parts[0] == "snapshots" {
+			if file.SHA256 != parts[1] {
+				return nil, nil, nil, fmt.Errorf("%w: snapshot %s does not match its content", domain.ErrInvalidBackup, parts[1])
+			}
+			snapshots[parts[1]] = true
+			continue
+		}
+		entities := memes
+		if parts[0] == "templates" {
+			entities = templates
+		}
+		entity, ok := entities[parts[1]]
+		if !ok {
+			entity = &backupEntity{}
+			entities[parts[1]] = entity
+		}
+		if len(parts) == 3 {
+			entity.metadataPath = file.Path
+		} else {
+			if entity.imagePath != "" {
+				return nil, nil, nil, fmt.Errorf("%w: %s/%s has more than one image", domain.ErrInvalidBackup, parts[0], parts[1])
+			}
+			entity.imagePath = file.Path
+			entity.imageName = parts[3]
+			entity.contentType = file.ContentType
+		}
+	}
+
+	for name := range checksums {
+		if !listed[name] {
+			return nil, nil, nil, fmt.Errorf("%w: %s is not listed in the manifest", domain.ErrInvalidBackup, name)
+		}
+	}
+	for kind, entities := range map[string]map[string]*backupEntity{"templates": templates, "memes": memes} {
+		for name, entity := range entities {
+			if entity.metadataPath == "" {
+				return nil, nil, nil, fmt.Errorf("%w: %s/%s has no metadata", domain.ErrInvalidBackup, kind, name)
+			}
+		}
+	}
+
+	return templates, memes, snapshots, nil
+}
+
+// validBackupPath reports whether an archive path is a metadata or image file of a meme or template, or a
+// template snapshot. Paths are checked before extraction so that entries cannot escape the staging directory.
+func validBackupPath(name string) bool {
+	if path.Clean(name) != name {
+		return false
+	}
+	parts := strings.Split(name, "/")
+	if parts[0] == "snapshots" {
+		return len(parts) == 2 && domain.ValidateContentHash(parts[1]) == nil
+	}
+	switch {
+	case len(parts) < 3:
+		return false
+	case parts[0] == "memes" && domain.ValidateMemeID(parts[1]) == nil:
+	case parts[0] == "templates" && domain.ValidateTemplateName(parts[1]) == nil:
+	default:
+		return false
+	}
+	for _, part := range parts[1:] {
+		if part == "" || part == ".." || isHiddenEntry(part) || strings.Contains(part, `\`) {
+			return false
+		}
+	}
+	switch len(parts) {
+	case 3:
+		return parts[2] == "metadata.json"
+	case 4:
+		return parts[2] == "images" && isImageFile(parts[3])
+	default:
+		return false
+	}
+}
+
+// deleteMissing removes the stored memes and templates a replacing restore did not write, once the whole
+// backup is restored. Entries with unreadable metadata are removed too, as replacing discards them.
+func (r *BackupRepository) deleteMissing(templates, memes map[string]*backupEntity, report *domain.RestoreReport) error {
+	stored, err := r.storage.Memes.List()
+	if err != nil && domain.CorruptEntries(err) == nil {
+		return fmt.Errorf("failed to list memes: %w", err)
+	}
+	ids := domain.CorruptEntries(err)
+	for _, m := range stored {
+		ids = append(ids, m.ID)
+	}
+	for _, id := range ids {
+		if memes[id] != nil {
+			continue
+		}
+		if err := r.storage.Memes.Delete(id); err != nil && !errors.Is(err, domain.ErrMemeNotFound) {
+			return fmt.Errorf("failed to delete meme %s: %w", id, err)
+		}
+		report.Deleted++
+	}
+
+	storedTemplates, err := r.storage.Templates.List()
+	if err != nil && domain.CorruptEntries(err) == nil {
+		return fmt.Errorf("failed to list templates: %w", err)
+	}
+	names := domain.CorruptEntries(err)
+	for _, template := range storedTemplates {
+		names = append(names, template.Name)
+	}
+	for _, name := range names {
+		if templates[name] != nil {
+			continue
+		}
+		if err := r.storage.Templates.Delete(name); err != nil && !errors.Is(err, domain.ErrTemplateNotFound) {
+			return fmt.Errorf("failed to delete template %s: %w", name, err)
+		}
+		report.Deleted++
+	}
+
+	return nil
+}
+
+// restoreTemplate writes a template of the backup. In merge mode an existing template that differs is
+// kept as a conflict; in replace mode it is overwritten.
+func (r *BackupRepository) restoreTemplate(staging, name string, entity *backupEntity, mode string, report *domain.RestoreReport) error {
+	data, err := os.ReadFile(filepath.Join(staging, filepath.FromSlash(entity.metadataPath)))
+	if err != nil {
+		return fmt.Errorf("failed to read staged metadata: %w", err)
+	}
+	template, err := DecodeTemplate(data)
+	if err != nil {
+		return fmt.Errorf("%w: template %s: %v", domain.ErrInvalidBackup, name, err)
+	}
+	if template.Name != name {
+		return fmt.Errorf("%w: template %s has metadata of %s", domain.ErrInvalidBackup, name, template.Name)
+	}
+
+	existing, err := r.storage.Templates.GetByName(name)
+	replace := mode == domain.RestoreReplace && (err == nil || errors.Is(err, domain.ErrCorruptMetadata))
+	switch {
+	case err == nil && sameMetadata(existing, template):
+		report.Unchanged++
+		return nil
+	case replace:
+	case err == nil:
+		report.Conflicts = append(report.Conflicts, domain.RestoreConflict{Entity: "template", Name: name, Detail: "differs from the existing template, which was kept"})
+		return nil
+	case !errors.Is(err, domain.ErrTemplateNotFound):
+		report.Conflicts = append(report.Conflicts, domain.RestoreConflict{Entity: "template", Name: name, Detail: err.Error()})
+		return nil
+	}
+
+	write := r.storage.Templates.Create
+	if replace {
+		write = r.storage.Templates.Update
+	}
+	err = write(template)
+	if errors.Is(err, domain.ErrTemplateExists) {
+		report.Conflicts = append(report.Conflicts, domain.RestoreConflict{Entity: "template", Name: name, Detail: "was created while restoring and kept"})
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to restore template %s: %w", name, err)
+	}
+	if entity.imagePath != "" {
+		image, err := os.ReadFile(filepath.Join(staging, filepath.FromSlash(entity.imagePath)))
+		if err != nil {
+			return fmt.Errorf("failed to read staged image: %w", err)
+		}
+		contentType := entity.contentType
+		if contentType == "" {
+			contentType = imageContentType(entity.imageName)
+		}
+		if err := r.storage.Images.SaveTemplateImage(name, image, contentType, nil); err != nil {
+			return fmt.Errorf("failed to restore image of template %s: %w", name, err)
+		}
+	}
+	report.Templates++
+
+	return nil
+}
+
+// restoreSnapshot writes a template snapshot of the backup; existing snapshots are left as they are
+func (r *BackupRepository) restoreSnapshot(staging, hash string) error {
+	data, err := os.ReadFile(filepath.Join(staging, "snapshots", hash))
+	if err != nil {
+		return fmt.Errorf("failed to read staged snapshot: %w", err)
+	}
+	if _, err := r.storage.Images.SaveSnapshot(data); err != nil {
+		return fmt.Errorf("failed to restore snapshot %s: %w", hash, err)
+	}
+	return nil
+}
+
+// restoreMeme writes a meme of the backup. In merge mode an existing meme that differs is kept as a
+// conflict; in replace mode it is overwritten.
+func (r *BackupRepository) restoreMeme(staging, id string, entity *backupEntity, mode string, report *domain.RestoreReport) error {
+	data, err := os.ReadFile(filepath.Join(staging, filepath.FromSlash(entity.metadataPath)))
+	if err != nil {
+		return fmt.Errorf("failed to read staged metadata: %w", err)
+	}
+	m, err := DecodeMeme(data)
+	if err != nil {
+		return fmt.Errorf("%w: meme %s: %v", domain.ErrInvalidBackup, id, err)
+	}
+	if m.ID != id {
+		return fmt.Errorf("%w: meme %s has metadata of %s", domain.ErrInvalidBackup, id, m.ID)
+	}
+
+	existing, err := r.storage.Memes.GetByID(id)
+	replace := mode == domain.RestoreReplace && (err == nil || errors.Is(err, domain.ErrCorruptMetadata))
+	switch {
+	case err == nil && sameMetadata(existing, m):
+		report.Unchanged++
+		return nil
+	case replace:
+	case err == nil:
+		report.Conflicts = append(report.Conflicts, domain.RestoreConflict{Entity: "meme", Name: id, Detail: "differs from the existing meme, which was kept"})
+		return nil
+	case !errors.Is(err, domain.ErrMemeNotFound):
+		report.Conflicts = append(report.Conflicts, domain.RestoreConflict{Entity: "meme", Name: id, Detail: err.Error()})
+		return nil
+	}
+
+	write := r.storage.Memes.Create
+	if replace {
+		write = r.storage.Memes.Update
+	}
+	if err := write(m); err != nil {
+		return fmt.Errorf("failed to restore meme %s: %w", id, err)
+	}
+	if entity.imagePath != "" {
+		image, err := os.ReadFile(filepath.Join(staging, filepath.FromSlash(entity.imagePath)))
+		if err != nil {
+			return fmt.Errorf("failed to read staged image: %w", err)
+		}
+		if err := r.storage.Images.SaveMemeImage(id, entity.imageName, image); err != nil {
+			return fmt.Errorf("failed to restore image of meme %s: %w", id, err)
+		}
+	}
+	report.Memes++
+
+	return nil
+}
+
+// sameMetadata reports whether two memes or templates hold the same metadata. Both have been decoded
+// with the current schema, so the stored schema version is not compared.
+func sameMetadata(a, b any) bool {
+	switch a := a.(type) {
+	case *domain.Meme:
+		x, y := *a, *b.(*domain.Meme)
+		x.SchemaVersion, y.SchemaVersion = 0, 0
+		return jsonEqual(x, y)
+	case *domain.Template:
+		x, y := *a, *b.(*domain.Template)
+		x.SchemaVersion, y.SchemaVersion = 0, 0
+		return jsonEqual(x, y)
+	}
+	return false
+}
+
+// jsonEqual reports whether two values encode to the same JSON
+func jsonEqual(a, b any) bool {
+	x, errX := json.Marshal(a)
+	y, errY := json.Marshal(b)
+	return errX == nil && errY == nil && bytes.Equal(x, y)
+}
+
+// sortedKeys returns the keys of an entity map in order, so backups and restores are deterministic
+func sortedKeys[V any](entities map[string]V) []string {
+	keys := make([]string, 0, len(entities))
+	for key := range entities {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,237 @@
+package repository
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"memes-generator/internal/config"
+	"memes-generator/internal/domain"
+)
+
+// FileBlobStore implements domain.BlobStore using the file system. Blobs are kept in
+// $DATA_DIR/blobs/<first two hex digits>/<sha256>; template snapshots written before the blob store
+// existed are still read from $DATA_DIR/snapshots until migrate moves them.
+type FileBlobStore struct {
+	dir       string
+	snapshots string
+}
+
+// NewFileBlobStore creates a new file-based blob store
+func NewFileBlobStore() *FileBlobStore {
+	return &FileBlobStore{
+		dir:       config.GetBlobsDir(),
+		snapshots: config.GetSnapshotsDir(),
+	}
+}
+
+// Put stores data under its content hash and returns the hash
+func (s *FileBlobStore) Put(data []byte) (string, error) {
+	hash := contentHash(data)
+	path := filepath.Join(s.dir, filepath.FromSlash(blobKey(hash)))
+
+	// Stored bytes are not written again, but their modification time is refreshed so that a concurrent
+	// garbage collection treats the blob as new
+	now := time.Now()
+	if err := os.Chtimes(path, now, now); err == nil {
+		return hash, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", fmt.Errorf("failed to create blob directory: %w", err)
+	}
+	if err := writeFileAtomic(path, data); err != nil {
+		return "", fmt.Errorf("failed to save blob: %w", err)
+	}
+	return hash, nil
+}
+
+// Open opens a blob by its content hash
+func (s *FileBlobStore) Open(hash string) (io.ReadCloser, domain.BlobInfo, error) {
+	if err := domain.ValidateContentHash(hash); err != nil {
+		return nil, domain.BlobInfo{}, err
+	}
+
+	file, err := openBlobFile(s.dir, hash)
+	if errors.Is(err, domain.ErrImageNotFound) {
+		file, err = openBlobFile(s.snapshots, hash)
+	}
+	if err != nil {
+		return nil, domain.BlobInfo{}, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, domain.BlobInfo{}, fmt.Errorf("failed to stat blob: %w", err)
+	}
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(file, head)
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		file.Close()
+		return nil, domain.BlobInfo{}, fmt.Errorf("failed to read blob: %w", err)
+	}
+
+	return file, domain.BlobInfo{
+		Hash:        hash,
+		Size:        info.Size(),
+		ContentType: sniffContentType(head[:n]),
+		ModifiedAt:  info.ModTime(),
+	}, nil
+}
+
+// Stat describes a blob of the store without opening it. Snapshots still kept in the legacy directory are
+// not found, like they are not listed.
+func (s *FileBlobStore) Stat(hash string) (domain.BlobInfo, error) {
+	if err := domain.ValidateContentHash(hash); err != nil {
+		return domain.BlobInfo{}, err
+	}
+	info, err := os.Stat(filepath.Join(s.dir, filepath.FromSlash(blobKey(hash))))
+	if os.IsNotExist(err) {
+		return domain.BlobInfo{}, fmt.Errorf("blob %s: %w", hash, domain.ErrImageNotFound)
+	}
+	if err != nil {
+		return domain.BlobInfo{}, fmt.Errorf("failed to stat blob: %w", err)
+	}
+	return domain.BlobInfo{Hash: hash, Size: info.Size(), ModifiedAt: info.ModTime()}, nil
+}
+
+// List returns every blob of the store. Snapshots still kept in the legacy directory are not listed, so
+// garbage collection never removes them.
+func (s *FileBlobStore) List() ([]domain.BlobInfo, error) {
+	shards, err := os.ReadDir(s.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read blob directory: %w", err)
+	}
+
+	var blobs []domain.BlobInfo
+	for _, shard := range shards {
+		if !shard.IsDir() || len(shard.Name()) != 2 {
+			continue
+		}
+		entries, err := os.ReadDir(filepath.Join(s.dir, shard.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read blob directory: %w", err)
+		}
+		for _, entry := range entries {
+			hash := entry.Name()
+			if entry.IsDir() || domain.ValidateContentHash(hash) != nil || hash[:2] != shard.Name() {
+				continue
+			}
+			info, err := entry.Info()
+			if err != nil {
+				continue
+			}
+			blobs = append(blobs, domain.BlobInfo{Hash: hash, Size: info.Size(), ModifiedAt: info.ModTime()})
+		}
+	}
+	return blobs, nil
+}
+
+// Delete removes a blob; deleting a missing blob is not an error
+func (s *FileBlobStore) Delete(hash string) error {
+	if err := domain.ValidateContentHash(hash); err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(s.dir, filepath.FromSlash(blobKey(hash)))); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete blob %s: %w", hash, err)
+	}
+	return nil
+}
+
+// migrateSnapshots moves the template snapshots of the legacy directory into the blob store and returns
+// their hashes. With dryRun set the snapshots are only listed.
+func (s *FileBlobStore) migrateSnapshots(dryRun bool) ([]string, error) {
+	shards, err := os.ReadDir(s.snapshots)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot directory: %w", err)
+	}
+
+	var moved []string
+	for _, shard := range shards {
+		if !shard.IsDir() {
+			continue
+		}
+		entries, err := os.ReadDir(filepath.Join(s.snapshots, shard.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read snapshot directory: %w", err)
+		}
+		for _, entry := range entries {
+			hash := entry.Name()
+			if entry.IsDir() || domain.ValidateContentHash(hash) != nil || hash[:2] != shard.Name() {
+				continue
+			}
+			moved = append(moved, hash)
+			if dryRun {
+				continue
+			}
+
+			source := filepath.Join(s.snapshots, shard.Name(), hash)
+			target := filepath.Join(s.dir, filepath.FromSlash(blobKey(hash)))
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return nil, fmt.Errorf("failed to create blob directory: %w", err)
+			}
+			if err := os.Rename(source, target); err != nil {
+				return nil, fmt.Errorf("failed to move snapshot %s: %w", hash, err)
+			}
+		}
+		if !dryRun {
+			os.Remove(filepath.Join(s.snapshots, shard.Name()))
+		}
+	}
+	if !dryRun {
+		os.Remove(s.snapshots)
+	}
+
+	return moved, nil
+}
+
+// openBlobFile opens a blob in a sharded directory through an os.Root, so hashes can never resolve outside it
+func openBlobFile(dir, hash string) (*os.File, error) {
+	root, err := os.OpenRoot(dir)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("blob %s: %w", hash, domain.ErrImageNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open blob directory: %w", err)
+	}
+	defer root.Close()
+
+	file, err := root.Open(blobKey(hash))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("blob %s: %w", hash, domain.ErrImageNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open blob: %w", err)
+	}
+	return file, nil
+}
+
+// contentHash returns the content address of data: its hex SHA-256 digest
+func contentHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// blobKey returns the path of a blob relative to the blob directory or prefix.
+// Blobs are sharded by the first two hex digits of their hash to keep directories small.
+func blobKey(hash string) string {
+	return hash[:2] + "/" + hash
+}
+
+// sniffContentType returns the MIME type of image data from its leading bytes
+func sniffContentType(head []byte) string {
+	return http.DetectContentType(head)
+}
@@ -0,0 +1,106 @@
+package repository
+
+import (
+	"fmt"
+	"os"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// ImportFailure describes a metadata directory that could not be imported
+type ImportFailure struct {
+	Kind  string `json:"kind"`
+	Name  string `json:"name"`
+	Error string `json:"error"`
+}
+
+// ImportReport summarizes an import of metadata.json directories into the metadata store
+type ImportReport struct {
+	Memes     int             `json:"memes"`
+	Templates int             `json:"templates"`
+	Usage     int             `json:"usage"`
+	Failures  []ImportFailure `json:"failures"`
+}
+
+// ImportFileMetadata copies the metadata.json of every meme and template directory in the data
+// directory, and the template usage counters, into the metadata store. Entries already in the store are
+// overwritten, so the import can be repeated safely. Images are left in place.
+func ImportFileMetadata(store *BoltStore) (*ImportReport, error) {
+	report := &ImportReport{Failures: []ImportFailure{}}
+
+	// The import only reads memes, so the repository never generates IDs
+	memeRepo := NewMemeFileRepository(nil)
+	memeIDs, err := listDirs(memeRepo.root.dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range memeIDs {
+		meme, err := memeRepo.GetByID(id)
+		if err == nil {
+			err = store.db.Update(func(tx *bolt.Tx) error {
+				return putMeme(tx, meme)
+			})
+		}
+		if err != nil {
+			report.Failures = append(report.Failures, ImportFailure{Kind: "meme", Name: id, Error: err.Error()})
+			continue
+		}
+		report.Memes++
+	}
+
+	templateRepo := NewTemplateFileRepository()
+	templateNames, err := listDirs(templateRepo.root.dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range templateNames {
+		template, err := templateRepo.GetByName(name)
+		if err == nil {
+			err = store.db.Update(func(tx *bolt.Tx) error {
+				return putTemplate(tx, template)
+			})
+		}
+		if err != nil {
+			report.Failures = append(report.Failures, ImportFailure{Kind: "template", Name: name, Error: err.Error()})
+			continue
+		}
+		report.Templates++
+	}
+
+	usages, err := NewUsageFileRepository().List()
+	if err != nil {
+		return nil, err
+	}
+	for _, usage := range usages {
+		err := store.db.Update(func(tx *bolt.Tx) error {
+			return putDocument(tx.Bucket(templateUsageBucket), usage.Template, usage)
+		})
+		if err != nil {
+			report.Failures = append(report.Failures, ImportFailure{Kind: "usage", Name: usage.Template, Error: err.Error()})
+			continue
+		}
+		report.Usage++
+	}
+
+	return report, nil
+}
+
+// listDirs returns the names of the subdirectories of a directory, or none when it does not exist.
+// Hidden directories such as .locks hold no memes or templates and are left out.
+func listDirs(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read data directory: %w", err)
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() && !isHiddenEntry(entry.Name()) {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
@@ -0,0 +1,161 @@
+package repository
+
+import (
+	"fmt"
+
+	bolt "go.etcd.io/bbolt"
+
+	"memes-generator/internal/config"
+	"memes-generator/internal/domain"
+)
+
+// BoltMemeRepository implements domain.MemeRepository using the embedded metadata store.
+// Meme images stay in the memes directory managed by the image store.
+type BoltMemeRepository struct {
+	store *BoltStore
+	root  entityRoot
+	ids   domain.IDGenerator
+}
+
+// NewBoltMemeRepository creates a new meme repository backed by the metadata store
+func NewBoltMemeRepository(store *BoltStore, ids domain.IDGenerator) *BoltMemeRepository {
+	return &BoltMemeRepository{
+		store: store,
+		root:  memeRoot(config.GetMemesDir()),
+		ids:   ids,
+	}
+}
+
+// Create saves a new meme to the store
+func (r *BoltMemeRepository) Create(meme *domain.Meme) error {
+	// Generate unique ID if not set
+	if meme.ID == "" {
+		meme.ID = r.ids.NewID()
+	}
+	if err := domain.ValidateMemeID(meme.ID); err != nil {
+		return err
+	}
+
+	return r.store.db.Update(func(tx *bolt.Tx) error {
+		return putMeme(tx, meme)
+	})
+}
+
+// Update overwrites the metadata of an existing meme
+func (r *BoltMemeRepository) Update(meme *domain.Meme) error {
+	return r.store.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(memesBucket).Get([]byte(meme.ID)) == nil {
+			return fmt.Errorf("meme with ID %s: %w", meme.ID, domain.ErrMemeNotFound)
+		}
+		return putMeme(tx, meme)
+	})
+}
+
+// putMeme stores a meme and moves its index entries from the previous version of the document
+func putMeme(tx *bolt.Tx, meme *domain.Meme) error {
+	memes := tx.Bucket(memesBucket)
+	byCreated := tx.Bucket(memesByCreatedBucket)
+	byTemplate := tx.Bucket(memesByTemplateBucket)
+
+	var previous domain.Meme
+	if found, _ := getDocument(memes, meme.ID, &previous); found {
+		if err := deleteMemeIndexes(tx, &previous); err != nil {
+			return err
+		}
+	}
+
+	if err := putDocument(memes, meme.ID, meme); err != nil {
+		return err
+	}
+	if err := byCreated.Put(indexKey(timeIndexValue(meme.CreatedAt), meme.ID), nil); err != nil {
+		return err
+	}
+	return byTemplate.Put(indexKey(meme.Template, meme.ID), nil)
+}
+
+// deleteMemeIndexes removes the index entries of a meme
+func deleteMemeIndexes(tx *bolt.Tx, meme *domain.Meme) error {
+	if err := tx.Bucket(memesByCreatedBucket).Delete(indexKey(timeIndexValue(meme.CreatedAt), meme.ID)); err != nil {
+		return err
+	}
+	return tx.Bucket(memesByTemplateBucket).Delete(indexKey(meme.Template, meme.ID))
+}
+
+// GetByID retrieves a meme by its ID
+func (r *BoltMemeRepository) GetByID(id string) (*domain.Meme, error) {
+	var meme domain.Meme
+	err := r.store.db.View(func(tx *bolt.Tx) error {
+		found, err := getDocument(tx.Bucket(memesBucket), id, &meme)
+		if !found {
+			return fmt.Errorf("meme with ID %s: %w", id, domain.ErrMemeNotFound)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &meme, nil
+}
+
+// List returns all memes ordered by creation time
+func (r *BoltMemeRepository) List() ([]*domain.Meme, error) {
+	return r.listIndexed(memesByCreatedBucket, "")
+}
+
+// ListByTemplate returns all memes made from a template
+func (r *BoltMemeRepository) ListByTemplate(template string) ([]*domain.Meme, error) {
+	return r.listIndexed(memesByTemplateBucket, template)
+}
+
+// listIndexed loads the memes referenced by an index in index order
+func (r *BoltMemeRepository) listIndexed(index []byte, value string) ([]*domain.Meme, error) {
+	memes := []*domain.Meme{}
+	err := r.store.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(memesBucket)
+		for _, id := range scanIndex(tx.Bucket(index), value) {
+			var meme domain.Meme
+			found, err := getDocument(bucket, id, &meme)
+			if err != nil {
+				return err
+			}
+			if found {
+				memes = append(memes, &meme)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return memes, nil
+}
+
+// Delete removes a meme and its images by its ID
+func (r *BoltMemeRepository) Delete(id string) error {
+	err := r.store.db.Update(func(tx *bolt.Tx) error {
+		var meme domain.Meme
+		found, err := getDocument(tx.Bucket(memesBucket), id, &meme)
+		if !found {
+			return fmt.Errorf("meme with ID %s: %w", id, domain.ErrMemeNotFound)
+		}
+		// Corrupt metadata can still be deleted; its index entries no longer resolve to a document
+		if err == nil {
+			if err := deleteMemeIndexes(tx, &meme); err != nil {
+				return err
+			}
+		}
+		return tx.Bucket(memesBucket).Delete([]byte(id))
+	})
+	if err != nil {
+		return err
+	}
+
+	// Remove the images left in the meme directory
+	if err := r.root.removeAll(id); err != nil {
+		return fmt.Errorf("failed to delete meme directory: %w", err)
+	}
+
+	return nil
+}
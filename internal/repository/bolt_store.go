@@ -0,0 +1,240 @@
+package repository
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"memes-generator/internal/config"
+	"memes-generator/internal/domain"
+)
+
+// Buckets of the metadata store. Documents are stored as JSON keyed by meme ID or template name;
+// index buckets hold composite keys "<indexed value>\x00<document key>" with empty values.
+var (
+	memesBucket              = []byte("memes")
+	memesByCreatedBucket     = []byte("memes_by_created")
+	memesByTemplateBucket    = []byte("memes_by_template")
+	templatesBucket          = []byte("templates")
+	templatesByCreatedBucket = []byte("templates_by_created")
+	templatesByTagBucket     = []byte("templates_by_tag")
+	templateUsageBucket      = []byte("template_usage")
+)
+
+// indexSeparator separates the indexed value from the document key in index keys
+const indexSeparator = "\x00"
+
+// BoltStore is an embedded single-file metadata store for memes and templates.
+// Only one process can open the store at a time; images stay in the data directory.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// NewBoltStore opens the metadata store at the configured path, creating it when needed
+func NewBoltStore() (*BoltStore, error) {
+	path := config.GetMetadataDBPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create metadata store directory: %w", err)
+	}
+
+	// The store is locked by the process holding it open, so fail instead of waiting forever
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open metadata store %s: %w", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{
+			memesBucket, memesByCreatedBucket, memesByTemplateBucket,
+			templatesBucket, templatesByCreatedBucket, templatesByTagBucket,
+			templateUsageBucket,
+		} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize metadata store: %w", err)
+	}
+
+	return &BoltStore{db: db}, nil
+}
+
+// Close releases the metadata store
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
+
+// indexBuckets lists every index bucket
+var indexBuckets = [][]byte{
+	memesByCreatedBucket, memesByTemplateBucket, templatesByCreatedBucket, templatesByTagBucket,
+}
+
+// CheckIndexes counts index entries that are missing for a document or point at no document
+func (s *BoltStore) CheckIndexes() (int, error) {
+	mismatches := 0
+	err := s.db.View(func(tx *bolt.Tx) error {
+		expected, err := expectedIndexKeys(tx)
+		if err != nil {
+			return err
+		}
+		for name, keys := range expected {
+			actual := make(map[string]bool)
+			tx.Bucket([]byte(name)).ForEach(func(k, _ []byte) error {
+				actual[string(k)] = true
+				return nil
+			})
+			for key := range keys {
+				if !actual[key] {
+					mismatches++
+				}
+			}
+			for key := range actual {
+				if !keys[key] {
+					mismatches++
+				}
+			}
+		}
+		return nil
+	})
+	return mismatches, err
+}
+
+// RebuildIndexes recreates every index bucket from the stored documents
+func (s *BoltStore) RebuildIndexes() error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		expected, err := expectedIndexKeys(tx)
+		if err != nil {
+			return err
+		}
+		for name, keys := range expected {
+			if err := tx.DeleteBucket([]byte(name)); err != nil {
+				return err
+			}
+			bucket, err := tx.CreateBucket([]byte(name))
+			if err != nil {
+				return err
+			}
+			for key := range keys {
+				if err := bucket.Put([]byte(key), nil); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// expectedIndexKeys computes the index entries of every decodable document, keyed by index bucket name
+func expectedIndexKeys(tx *bolt.Tx) (map[string]map[string]bool, error) {
+	expected := make(map[string]map[string]bool)
+	for _, name := range indexBuckets {
+		expected[string(name)] = make(map[string]bool)
+	}
+
+	err := tx.Bucket(memesBucket).ForEach(func(k, v []byte) error {
+		var meme domain.Meme
+		if decodeDocument(string(k), v, &meme) != nil {
+			return nil
+		}
+		expected[string(memesByCreatedBucket)][string(indexKey(timeIndexValue(meme.CreatedAt), meme.ID))] = true
+		expected[string(memesByTemplateBucket)][string(indexKey(meme.Template, meme.ID))] = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Bucket(templatesBucket).ForEach(func(k, v []byte) error {
+		var template domain.Template
+		if decodeDocument(string(k), v, &template) != nil {
+			return nil
+		}
+		expected[string(templatesByCreatedBucket)][string(indexKey(timeIndexValue(template.CreatedAt), template.Name))] = true
+		for _, tag := range template.Tags {
+			expected[string(templatesByTagBucket)][string(indexKey(tag, template.Name))] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return expected, nil
+}
+
+// indexKey builds an index key from an indexed value and a document key
+func indexKey(value, key string) []byte {
+	return []byte(value + indexSeparator + key)
+}
+
+// timeIndexValue formats a time so that index keys sort chronologically
+func timeIndexValue(t time.Time) string {
+	return t.UTC().Format("20060102T150405.000000000")
+}
+
+// scanIndex returns the document keys of an index, optionally limited to one indexed value
+func scanIndex(bucket *bolt.Bucket, value string) []string {
+	var prefix []byte
+	if value != "" {
+		prefix = []byte(value + indexSeparator)
+	}
+
+	var keys []string
+	cursor := bucket.Cursor()
+	for k, _ := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = cursor.Next() {
+		if i := bytes.LastIndex(k, []byte(indexSeparator)); i >= 0 {
+			keys = append(keys, string(k[i+1:]))
+		}
+	}
+	return keys
+}
+
+// getDocument decodes a stored document, reporting whether it exists
+func getDocument(bucket *bolt.Bucket, key string, v any) (bool, error) {
+	data := bucket.Get([]byte(key))
+	if data == nil {
+		return false, nil
+	}
+	return true, decodeDocument(key, data, v)
+}
+
+// decodeDocument decodes the JSON of a stored meme or template, upgrading it to the current schema
+func decodeDocument(key string, data []byte, v any) error {
+	var err error
+	switch v.(type) {
+	case *domain.Meme:
+		err = decodeVersioned(memeSchema, data, v)
+	case *domain.Template:
+		err = decodeVersioned(templateSchema, data, v)
+	default:
+		err = json.Unmarshal(data, v)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to decode metadata of %s: %w", key, err)
+	}
+	return nil
+}
+
+// putDocument encodes and stores a meme or template stamped with the current schema version
+func putDocument(bucket *bolt.Bucket, key string, v any) error {
+	switch doc := v.(type) {
+	case *domain.Meme:
+		doc.SchemaVersion = domain.MemeSchemaVersion
+	case *domain.Template:
+		doc.SchemaVersion = domain.TemplateSchemaVersion
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to encode metadata of %s: %w", key, err)
+	}
+	return bucket.Put([]byte(key), data)
+}
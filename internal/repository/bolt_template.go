@@ -0,0 +1,251 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+
+	bolt "go.etcd.io/bbolt"
+
+	"memes-generator/internal/config"
+	"memes-generator/internal/domain"
+)
+
+// BoltTemplateRepository implements domain.TemplateRepository using the embedded metadata store.
+// Template images stay in the templates directory managed by the image store.
+type BoltTemplateRepository struct {
+	store *BoltStore
+	root  entityRoot
+}
+
+// NewBoltTemplateRepository creates a new template repository backed by the metadata store
+func NewBoltTemplateRepository(store *BoltStore) *BoltTemplateRepository {
+	return &BoltTemplateRepository{
+		store: store,
+		root:  templateRoot(config.GetTemplatesDir()),
+	}
+}
+
+// Create saves a new template to the store; a slug that is taken is refused with domain.ErrTemplateExists
+func (r *BoltTemplateRepository) Create(template *domain.Template) error {
+	unlock, err := r.lock(template.Name)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return r.store.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(templatesBucket).Get([]byte(template.Name)) != nil {
+			return fmt.Errorf("template with name %s: %w", template.Name, domain.ErrTemplateExists)
+		}
+		return putTemplate(tx, template)
+	})
+}
+
+// Update overwrites the metadata of an existing template
+func (r *BoltTemplateRepository) Update(template *domain.Template) error {
+	unlock, err := r.lock(template.Name)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return r.update(template)
+}
+
+// Mutate applies mutate to a template while holding the template lock across the read and the write.
+// The lock is taken on the templates directory rather than a write transaction, so that mutate may read
+// from the store.
+func (r *BoltTemplateRepository) Mutate(name string, mutate func(*domain.Template) error) (*domain.Template, error) {
+	unlock, err := r.lock(name)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	template, err := r.GetByName(name)
+	if err != nil {
+		return nil, err
+	}
+	changed, err := applyTemplateMutation(template, mutate)
+	if err != nil || !changed {
+		return template, err
+	}
+	if template.Name != name {
+		return nil, fmt.Errorf("template %s cannot be renamed to %s", name, template.Name)
+	}
+	if err := r.update(template); err != nil {
+		return nil, err
+	}
+	return template, nil
+}
+
+// lock takes the lock of a template, which every writer holds
+func (r *BoltTemplateRepository) lock(name string) (func(), error) {
+	templateDir, err := r.root.path(name)
+	if err != nil {
+		return nil, err
+	}
+	unlock, err := LockEntity(templateDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock template %s: %w", name, err)
+	}
+	return unlock, nil
+}
+
+// update overwrites the metadata of an existing template
+func (r *BoltTemplateRepository) update(template *domain.Template) error {
+	return r.store.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(templatesBucket).Get([]byte(template.Name)) == nil {
+			return fmt.Errorf("template with name %s: %w", template.Name, domain.ErrTemplateNotFound)
+		}
+		return putTemplate(tx, template)
+	})
+}
+
+// putTemplate stores a template and moves its index entries from the previous version of the document
+func putTemplate(tx *bolt.Tx, template *domain.Template) error {
+	templates := tx.Bucket(templatesBucket)
+
+	var previous domain.Template
+	if found, _ := getDocument(templates, template.Name, &previous); found {
+		if err := deleteTemplateIndexes(tx, &previous); err != nil {
+			return err
+		}
+	}
+
+	if err := putDocument(templates, template.Name, template); err != nil {
+		return err
+	}
+	if err := tx.Bucket(templatesByCreatedBucket).Put(indexKey(timeIndexValue(template.CreatedAt), template.Name), nil); err != nil {
+		return err
+	}
+	for _, tag := range template.Tags {
+		if err := tx.Bucket(templatesByTagBucket).Put(indexKey(tag, template.Name), nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteTemplateIndexes removes the index entries of a template
+func deleteTemplateIndexes(tx *bolt.Tx, template *domain.Template) error {
+	if err := tx.Bucket(templatesByCreatedBucket).Delete(indexKey(timeIndexValue(template.CreatedAt), template.Name)); err != nil {
+		return err
+	}
+	for _, tag := range template.Tags {
+		if err := tx.Bucket(templatesByTagBucket).Delete(indexKey(tag, template.Name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetByName retrieves a template by its name
+func (r *BoltTemplateRepository) GetByName(name string) (*domain.Template, error) {
+	var template domain.Template
+	err := r.store.db.View(func(tx *bolt.Tx) error {
+		found, err := getDocument(tx.Bucket(templatesBucket), name, &template)
+		if !found {
+			return fmt.Errorf("template with name %s: %w", name, domain.ErrTemplateNotFound)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &template, nil
+}
+
+// List returns all templates ordered by name
+func (r *BoltTemplateRepository) List() ([]*domain.Template, error) {
+	templates := []*domain.Template{}
+	err := r.store.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(templatesBucket).ForEach(func(k, v []byte) error {
+			var template domain.Template
+			if err := decodeDocument(string(k), v, &template); err != nil {
+				return err
+			}
+			templates = append(templates, &template)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return templates, nil
+}
+
+// ListByTag returns all templates carrying a tag
+func (r *BoltTemplateRepository) ListByTag(tag string) ([]*domain.Template, error) {
+	templates := []*domain.Template{}
+	err := r.store.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(templatesBucket)
+		for _, name := range scanIndex(tx.Bucket(templatesByTagBucket), tag) {
+			var template domain.Template
+			found, err := getDocument(bucket, name, &template)
+			if err != nil {
+				return err
+			}
+			if found {
+				templates = append(templates, &template)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return templates, nil
+}
+
+// Delete removes a template and its images by its name
+func (r *BoltTemplateRepository) Delete(name string) error {
+	return r.DeleteIf(name, nil)
+}
+
+// DeleteIf removes a template and its images by its name unless check, run while the template is locked,
+// fails. check runs outside of a transaction, so it may use the store.
+func (r *BoltTemplateRepository) DeleteIf(name string, check func() error) error {
+	unlock, err := r.lock(name)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if check != nil {
+		if _, err := r.GetByName(name); err != nil && !errors.Is(err, domain.ErrCorruptMetadata) {
+			return err
+		}
+		if err := check(); err != nil {
+			return err
+		}
+	}
+
+	err = r.store.db.Update(func(tx *bolt.Tx) error {
+		var template domain.Template
+		found, err := getDocument(tx.Bucket(templatesBucket), name, &template)
+		if !found {
+			return fmt.Errorf("template with name %s: %w", name, domain.ErrTemplateNotFound)
+		}
+		// Corrupt metadata can still be deleted; its index entries no longer resolve to a document
+		if err == nil {
+			if err := deleteTemplateIndexes(tx, &template); err != nil {
+				return err
+			}
+		}
+		return tx.Bucket(templatesBucket).Delete([]byte(name))
+	})
+	if err != nil {
+		return err
+	}
+
+	// Remove the images left in the template directory
+	if err := r.root.removeAll(name); err != nil {
+		return fmt.Errorf("failed to delete template directory: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,48 @@
+package repository
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"memes-generator/internal/domain"
+)
+
+// filterMemesByTemplate returns the memes made from a template, for repositories without a template index
+func filterMemesByTemplate(memes []*domain.Meme, template string) []*domain.Meme {
+	filtered := []*domain.Meme{}
+	for _, meme := range memes {
+		if meme.Template == template {
+			filtered = append(filtered, meme)
+		}
+	}
+	return filtered
+}
+
+// filterTemplatesByTag returns the templates carrying a tag, for repositories without a tag index
+func filterTemplatesByTag(templates []*domain.Template, tag string) []*domain.Template {
+	filtered := []*domain.Template{}
+	for _, template := range templates {
+		if slices.Contains(template.Tags, tag) {
+			filtered = append(filtered, template)
+		}
+	}
+	return filtered
+}
+
+// applyTemplateMutation applies a mutation to a template and reports whether it changed the template
+func applyTemplateMutation(template *domain.Template, mutate func(*domain.Template) error) (bool, error) {
+	before, err := json.Marshal(template)
+	if err != nil {
+		return false, fmt.Errorf("failed to encode template metadata: %w", err)
+	}
+	if err := mutate(template); err != nil {
+		return false, err
+	}
+	after, err := json.Marshal(template)
+	if err != nil {
+		return false, fmt.Errorf("failed to encode template metadata: %w", err)
+	}
+	return !bytes.Equal(before, after), nil
+}
@@ -0,0 +1,341 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"memes-generator/internal/config"
+	"memes-generator/internal/domain"
+	"memes-generator/internal/meme"
+)
+
+// Kinds of inconsistencies found by Fsck
+const (
+	FsckCorruptMetadata   = "corrupt_metadata"
+	FsckOrphanDirectory   = "orphan_directory"
+	FsckStaleTempFile     = "stale_temp_file"
+	FsckMissingImage      = "missing_image"
+	FsckUnreadableImage   = "unreadable_image"
+	FsckMissingTemplate   = "missing_template"
+	FsckTemplateNoImage   = "template_missing_image"
+	FsckIndexInconsistent = "index_inconsistent"
+	FsckUnsupportedSchema = "unsupported_schema"
+	FsckInvalidName       = "invalid_name"
+	FsckMissingSnapshot   = "missing_snapshot"
+	FsckMissingBlob       = "missing_blob"
+)
+
+// FsckIssue is one inconsistency found in the data directory
+type FsckIssue struct {
+	Kind        string `json:"kind"`
+	Entity      string `json:"entity"`
+	Name        string `json:"name"`
+	Detail      string `json:"detail"`
+	Repaired    bool   `json:"repaired"`
+	Repair      string `json:"repair,omitempty"`
+	RepairError string `json:"repair_error,omitempty"`
+}
+
+// FsckReport is the result of a consistency check of the data directory
+type FsckReport struct {
+	DataDir    string      `json:"data_dir"`
+	Backend    string      `json:"backend"`
+	Repair     bool        `json:"repair"`
+	Memes      int         `json:"memes"`
+	Templates  int         `json:"templates"`
+	Issues     []FsckIssue `json:"issues"`
+	Repaired   int         `json:"repaired"`
+	Unrepaired int         `json:"unrepaired"`
+}
+
+// fsck holds the state of one consistency check
+type fsck struct {
+	storage *Storage
+	repair  bool
+	report  *FsckReport
+}
+
+// Fsck checks the data directory of a file or bolt storage backend for inconsistencies. With repair set it
+// re-renders missing and unreadable meme images, quarantines entries with unreadable metadata, removes
+// stale temporary files and rebuilds the metadata store indexes. Problems that need a decision, such as
+// memes pointing at deleted templates, are only reported.
+func Fsck(storage *Storage, repair bool) (*FsckReport, error) {
+	if storage.Backend == config.StorageBackendS3 {
+		return nil, fmt.Errorf("fsck checks a local data directory and does not support the %s backend", config.StorageBackendS3)
+	}
+
+	f := &fsck{
+		storage: storage,
+		repair:  repair,
+		report: &FsckReport{
+			DataDir: config.GetDataDir(),
+			Backend: storage.Backend,
+			Repair:  repair,
+			Issues:  []FsckIssue{},
+		},
+	}
+
+	if err := f.checkDirectories("meme", config.GetMemesDir(), f.memeExists); err != nil {
+		return nil, err
+	}
+	if err := f.checkDirectories("template", config.GetTemplatesDir(), f.templateExists); err != nil {
+		return nil, err
+	}
+	if err := f.checkMemes(); err != nil {
+		return nil, err
+	}
+	if err := f.checkTemplates(); err != nil {
+		return nil, err
+	}
+	if err := f.checkIndexes(); err != nil {
+		return nil, err
+	}
+
+	for _, issue := range f.report.Issues {
+		if issue.Repaired {
+			f.report.Repaired++
+		} else {
+			f.report.Unrepaired++
+		}
+	}
+
+	return f.report, nil
+}
+
+// add records an issue, applying its repair when repairing is enabled and a repair exists
+func (f *fsck) add(issue FsckIssue, repair string, fix func() error) {
+	if f.repair && fix != nil {
+		if err := fix(); err != nil {
+			issue.RepairError = err.Error()
+		} else {
+			issue.Repaired = true
+			issue.Repair = repair
+		}
+	}
+	f.report.Issues = append(f.report.Issues, issue)
+}
+
+// checkDirectories looks for leftover temporary files and for entity directories whose metadata is
+// missing or unreadable. exists reports whether the metadata of a directory can be loaded.
+func (f *fsck) checkDirectories(entity, dir string, exists func(name string) error) error {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %s directory: %w", entity, err)
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		path := filepath.Join(dir, name)
+		if !entry.IsDir() {
+			f.checkTempFile(entity, "", path)
+			continue
+		}
+		if isHiddenEntry(name) {
+			continue
+		}
+
+		filepath.WalkDir(path, func(p string, d os.DirEntry, err error) error {
+			if err == nil && !d.IsDir() {
+				f.checkTempFile(entity, name, p)
+			}
+			return nil
+		})
+
+		err := exists(name)
+		switch {
+		case err == nil:
+		case errors.Is(err, domain.ErrCorruptMetadata):
+			f.add(FsckIssue{Kind: FsckCorruptMetadata, Entity: entity, Name: name, Detail: err.Error()},
+				"moved to quarantine", func() error { return f.quarantine(entity, path) })
+		case errors.Is(err, domain.ErrInvalidName):
+			// Directories that no valid name maps to cannot be reached through the repositories
+			f.add(FsckIssue{Kind: FsckInvalidName, Entity: entity, Name: name, Detail: err.Error()},
+				"moved to quarantine", func() error { return f.quarantine(entity, path) })
+		case errors.Is(err, domain.ErrUnsupportedSchema):
+			// Metadata written by a newer build is left for that build to read
+			f.add(FsckIssue{Kind: FsckUnsupportedSchema, Entity: entity, Name: name, Detail: err.Error()}, "", nil)
+		case errors.Is(err, domain.ErrMemeNotFound) || errors.Is(err, domain.ErrTemplateNotFound):
+			// A directory the metadata store does not know about holds only images
+			f.add(FsckIssue{Kind: FsckOrphanDirectory, Entity: entity, Name: name, Detail: "directory has no metadata in the " + f.storage.Backend + " store"},
+				"moved to quarantine", func() error { return f.quarantine(entity, path) })
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkTempFile reports a temporary file left behind by an interrupted atomic write
+func (f *fsck) checkTempFile(entity, name, path string) {
+	if !strings.HasPrefix(filepath.Base(path), ".tmp-") {
+		return
+	}
+	info, err := os.Stat(path)
+	// Files that are still being written by a running process are left alone
+	if err != nil || time.Since(info.ModTime()) < time.Minute {
+		return
+	}
+	f.add(FsckIssue{Kind: FsckStaleTempFile, Entity: entity, Name: name, Detail: path},
+		"removed", func() error { return os.Remove(path) })
+}
+
+// memeExists reports whether the metadata of a meme directory can be loaded
+func (f *fsck) memeExists(id string) error {
+	_, err := f.storage.Memes.GetByID(id)
+	return err
+}
+
+// templateExists reports whether the metadata of a template directory can be loaded
+func (f *fsck) templateExists(name string) error {
+	_, err := f.storage.Templates.GetByName(name)
+	return err
+}
+
+// checkMemes checks the image and template of every meme
+func (f *fsck) checkMemes() error {
+	// Unreadable metadata is reported by the directory scan
+	memes, err := f.storage.Memes.List()
+	if err != nil && domain.CorruptEntries(err) == nil {
+		return fmt.Errorf("failed to list memes: %w", err)
+	}
+	f.report.Memes = len(memes)
+
+	renderer := meme.NewRenderer(f.storage.Images)
+	for _, m := range memes {
+		template, err := f.storage.Templates.GetByName(m.Template)
+		if errors.Is(err, domain.ErrTemplateNotFound) {
+			f.add(FsckIssue{Kind: FsckMissingTemplate, Entity: "meme", Name: m.ID, Detail: fmt.Sprintf("template %s does not exist", m.Template)}, "", nil)
+		}
+		if m.TemplateSnapshot != nil {
+			if err := f.checkBlob(m.TemplateSnapshot.Image); err != nil {
+				f.add(FsckIssue{Kind: FsckMissingSnapshot, Entity: "meme", Name: m.ID, Detail: err.Error()}, "", nil)
+			}
+		}
+
+		rerender := func() error {
+			var buf bytes.Buffer
+			if _, err := m.RenderImage(context.Background(), renderer, template, config.GetServerURL(), &buf); err != nil {
+				return err
+			}
+			return f.storage.Images.SaveMemeImage(m.ID, "generated_meme.png", buf.Bytes())
+		}
+
+		src, _, err := f.storage.Images.OpenMemeImage(m.ID)
+		if errors.Is(err, domain.ErrImageNotFound) {
+			f.add(FsckIssue{Kind: FsckMissingImage, Entity: "meme", Name: m.ID, Detail: "meme has no image"}, "re-rendered image", rerender)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		_, _, err = image.DecodeConfig(src)
+		src.Close()
+		if err != nil {
+			f.add(FsckIssue{Kind: FsckUnreadableImage, Entity: "meme", Name: m.ID, Detail: err.Error()}, "re-rendered image", rerender)
+		}
+	}
+
+	return nil
+}
+
+// checkBlob checks that a blob exists and can be opened
+func (f *fsck) checkBlob(hash string) error {
+	src, _, err := f.storage.Blobs.Open(hash)
+	if err != nil {
+		return err
+	}
+	return src.Close()
+}
+
+// checkTemplates checks that every template has an image and that the images of its variants and versions
+// exist
+func (f *fsck) checkTemplates() error {
+	// Unreadable metadata is reported by the directory scan
+	templates, err := f.storage.Templates.List()
+	if err != nil && domain.CorruptEntries(err) == nil {
+		return fmt.Errorf("failed to list templates: %w", err)
+	}
+	f.report.Templates = len(templates)
+
+	for _, template := range templates {
+		for _, variant := range template.Variants {
+			if variant.Image == nil {
+				continue
+			}
+			if err := f.checkBlob(variant.Image.Blob); errors.Is(err, domain.ErrImageNotFound) {
+				f.add(FsckIssue{Kind: FsckMissingBlob, Entity: "template", Name: template.Name, Detail: fmt.Sprintf("variant %s: %v", variant.Name, err)}, "", nil)
+			}
+		}
+		for _, version := range template.Versions {
+			if version.Image == nil || template.Image != nil && version.Image.Blob == template.Image.Blob {
+				continue
+			}
+			if err := f.checkBlob(version.Image.Blob); errors.Is(err, domain.ErrImageNotFound) {
+				f.add(FsckIssue{Kind: FsckMissingBlob, Entity: "template", Name: template.Name, Detail: fmt.Sprintf("version %d: %v", version.Version, err)}, "", nil)
+			}
+		}
+		if template.Image != nil {
+			// The metadata describes images kept as blobs, so only opening the blob shows that it exists
+			if err := f.checkBlob(template.Image.Blob); errors.Is(err, domain.ErrImageNotFound) {
+				f.add(FsckIssue{Kind: FsckMissingBlob, Entity: "template", Name: template.Name, Detail: err.Error()}, "", nil)
+			}
+			continue
+		}
+		if _, err := f.storage.Images.StatTemplateImage(template.Name); errors.Is(err, domain.ErrImageNotFound) {
+			f.add(FsckIssue{Kind: FsckTemplateNoImage, Entity: "template", Name: template.Name, Detail: "template has no image"}, "", nil)
+		}
+	}
+
+	return nil
+}
+
+// checkIndexes verifies the indexes of the embedded metadata store
+func (f *fsck) checkIndexes() error {
+	if f.storage.metadata == nil {
+		return nil
+	}
+
+	mismatches, err := f.storage.metadata.CheckIndexes()
+	if err != nil {
+		return fmt.Errorf("failed to check metadata store indexes: %w", err)
+	}
+	if mismatches > 0 {
+		f.add(FsckIssue{Kind: FsckIndexInconsistent, Entity: "store", Name: config.GetMetadataDBPath(), Detail: fmt.Sprintf("%d index entries are missing or stale", mismatches)},
+			"rebuilt indexes", f.storage.metadata.RebuildIndexes)
+	}
+
+	return nil
+}
+
+// quarantine moves an entity directory out of the data set into DATA_DIR/quarantine for manual inspection
+func (f *fsck) quarantine(entity, path string) error {
+	unlock, err := LockEntity(path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	dir := filepath.Join(config.GetDataDir(), "quarantine", entity+"s")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create quarantine directory: %w", err)
+	}
+	target := filepath.Join(dir, fmt.Sprintf("%s-%d", filepath.Base(path), time.Now().Unix()))
+	if err := os.Rename(path, target); err != nil {
+		return fmt.Errorf("failed to move %s to quarantine: %w", path, err)
+	}
+	return nil
+}
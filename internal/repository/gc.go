@@ -0,0 +1,165 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"memes-generator/internal/domain"
+)
+
+// CollectedBlob is an unreferenced blob removed by a garbage collection
+type CollectedBlob struct {
+	Hash string `json:"hash"`
+	Size int64  `json:"size"`
+}
+
+// GCReport is the result of a blob garbage collection. References counts the pointers from meme and
+// template metadata to blobs, and SavedBytes the bytes that would be stored again without deduplication.
+type GCReport struct {
+	Backend    string          `json:"backend"`
+	DryRun     bool            `json:"dry_run"`
+	Grace      string          `json:"grace"`
+	Blobs      int             `json:"blobs"`
+	Bytes      int64           `json:"bytes"`
+	Referenced int             `json:"referenced"`
+	References int             `json:"references"`
+	SavedBytes int64           `json:"saved_bytes"`
+	Recent     int             `json:"recent"`
+	Collected  []CollectedBlob `json:"collected"`
+	FreedBytes int64           `json:"freed_bytes"`
+}
+
+// CollectGarbage deletes the blobs no meme or template references any more. Reference counts are derived
+// from the metadata rather than stored, so they cannot drift. Unreferenced blobs modified within grace are
+// kept, as their meme or template may still be on its way to storage; references are counted again and the
+// modification time of every blob is checked again right before deleting it. With dryRun set the blobs are
+// reported but not deleted.
+// Metadata that cannot be read stops the collection, as the blobs it references would be collected; use
+// Fsck to repair it first.
+func CollectGarbage(storage *Storage, grace time.Duration, dryRun bool) (*GCReport, error) {
+	report := &GCReport{
+		Backend:   storage.Backend,
+		DryRun:    dryRun,
+		Grace:     grace.String(),
+		Collected: []CollectedBlob{},
+	}
+
+	blobs, err := storage.Blobs.List()
+	if err != nil {
+		return nil, err
+	}
+	refs, err := blobReferences(storage)
+	if err != nil {
+		return nil, err
+	}
+
+	cutoff := time.Now().Add(-grace)
+	var candidates []CollectedBlob
+	for _, blob := range blobs {
+		report.Blobs++
+		report.Bytes += blob.Size
+		if count := refs[blob.Hash]; count > 0 {
+			report.Referenced++
+			report.References += count
+			report.SavedBytes += int64(count-1) * blob.Size
+			continue
+		}
+		if blob.ModifiedAt.After(cutoff) {
+			report.Recent++
+			continue
+		}
+		candidates = append(candidates, CollectedBlob{Hash: blob.Hash, Size: blob.Size})
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].Hash < candidates[j].Hash })
+
+	if !dryRun && len(candidates) > 0 {
+		// A meme created meanwhile may have picked up a blob that looked unreferenced
+		if refs, err = blobReferences(storage); err != nil {
+			return nil, err
+		}
+	}
+	for _, blob := range candidates {
+		if refs[blob.Hash] > 0 {
+			continue
+		}
+		if !dryRun {
+			// Storing the blob again since it was listed refreshed its modification time for a new reference
+			info, err := storage.Blobs.Stat(blob.Hash)
+			if errors.Is(err, domain.ErrImageNotFound) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			if info.ModifiedAt.After(cutoff) {
+				report.Recent++
+				continue
+			}
+			if err := storage.Blobs.Delete(blob.Hash); err != nil {
+				return nil, err
+			}
+		}
+		report.Collected = append(report.Collected, blob)
+		report.FreedBytes += blob.Size
+	}
+
+	return report, nil
+}
+
+// blobReferences counts the references to every blob from the images and template snapshots of memes and
+// the images and variant images of templates and their versions. A template counts once per blob, however many of its
+// versions share it.
+func blobReferences(storage *Storage) (map[string]int, error) {
+	refs := make(map[string]int)
+
+	templates, err := storage.Templates.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list templates: %w", err)
+	}
+	for _, template := range templates {
+		for _, blob := range templateBlobs(template) {
+			refs[blob]++
+		}
+	}
+
+	memes, err := storage.Memes.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list memes: %w", err)
+	}
+	for _, m := range memes {
+		if m.Image != nil {
+			refs[m.Image.Blob]++
+		}
+		if m.TemplateSnapshot != nil {
+			refs[m.TemplateSnapshot.Image]++
+		}
+	}
+
+	return refs, nil
+}
+
+// templateBlobs returns the distinct blobs holding the current image, the variant images and the version
+// images of a template
+func templateBlobs(template *domain.Template) []string {
+	seen := make(map[string]bool)
+	var blobs []string
+	add := func(ref *domain.ImageRef) {
+		if ref != nil && !seen[ref.Blob] {
+			seen[ref.Blob] = true
+			blobs = append(blobs, ref.Blob)
+		}
+	}
+	add(template.Image)
+	for _, variant := range template.Variants {
+		add(variant.Image)
+	}
+	for _, version := range template.Versions {
+		add(version.Image)
+		for _, variant := range version.Variants {
+			add(variant.Image)
+		}
+	}
+	return blobs
+}
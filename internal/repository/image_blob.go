@@ -0,0 +1,158 @@
+package repository
+
+import (
+	"fmt"
+	"io"
+
+	"memes-generator/internal/domain"
+	"memes-generator/internal/meme"
+)
+
+// legacyImageStore reads and removes the images kept in the images/ directories of memes and templates,
+// the layout used before the blob store
+type legacyImageStore interface {
+	StatTemplateImage(name string) (meme.ImageInfo, error)
+	OpenTemplateImage(name string) (io.ReadCloser, meme.ImageInfo, error)
+	DeleteTemplateImages(name string) error
+	OpenMemeImage(id string) (io.ReadCloser, meme.ImageInfo, error)
+	DeleteMemeImages(id string) error
+}
+
+// BlobImageStore implements domain.ImageStore on top of a blob store. Saved images are stored as blobs and
+// the metadata of their meme or template is pointed at them; entities without such a pointer are read from
+// the legacy images/ layout until migrate moves their images into blobs.
+type BlobImageStore struct {
+	blobs     domain.BlobStore
+	legacy    legacyImageStore
+	memes     domain.MemeRepository
+	templates domain.TemplateRepository
+}
+
+// NewBlobImageStore creates a new blob-backed image store
+func NewBlobImageStore(blobs domain.BlobStore, legacy legacyImageStore, memes domain.MemeRepository, templates domain.TemplateRepository) *BlobImageStore {
+	return &BlobImageStore{
+		blobs:     blobs,
+		legacy:    legacy,
+		memes:     memes,
+		templates: templates,
+	}
+}
+
+// StatTemplateImage describes the current image of a template
+func (s *BlobImageStore) StatTemplateImage(name string) (meme.ImageInfo, error) {
+	if template, err := s.templates.GetByName(name); err == nil && template.Image != nil {
+		return refImageInfo(template.Image), nil
+	}
+	return s.legacy.StatTemplateImage(name)
+}
+
+// OpenTemplateImage opens the current image of a template
+func (s *BlobImageStore) OpenTemplateImage(name string) (io.ReadCloser, meme.ImageInfo, error) {
+	if template, err := s.templates.GetByName(name); err == nil && template.Image != nil {
+		return s.openRef(template.Image, "template "+name)
+	}
+	return s.legacy.OpenTemplateImage(name)
+}
+
+// SaveTemplateImage stores the image of a template as a blob and points the template metadata at it,
+// applying update in the same locked update of the metadata
+func (s *BlobImageStore) SaveTemplateImage(name string, data []byte, contentType string, update func(template *domain.Template, previous *domain.ImageRef) error) error {
+	if _, err := s.templates.GetByName(name); err != nil {
+		return err
+	}
+
+	ref, err := s.put("template"+imageExtension(contentType), contentType, data)
+	if err != nil {
+		return fmt.Errorf("failed to save template image: %w", err)
+	}
+	_, err = s.templates.Mutate(name, func(template *domain.Template) error {
+		previous := template.Image
+		template.Image = ref
+		if update == nil {
+			return nil
+		}
+		return update(template, previous)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save template image: %w", err)
+	}
+	return s.legacy.DeleteTemplateImages(name)
+}
+
+// OpenMemeImage opens the generated image of a meme
+func (s *BlobImageStore) OpenMemeImage(id string) (io.ReadCloser, meme.ImageInfo, error) {
+	if m, err := s.memes.GetByID(id); err == nil && m.Image != nil {
+		return s.openRef(m.Image, "meme "+id)
+	}
+	return s.legacy.OpenMemeImage(id)
+}
+
+// SaveMemeImage stores the image of a meme as a blob and points the meme metadata at it, replacing the
+// previous image
+func (s *BlobImageStore) SaveMemeImage(id, filename string, data []byte) error {
+	if err := validateImageFilename(filename); err != nil {
+		return err
+	}
+	m, err := s.memes.GetByID(id)
+	if err != nil {
+		return err
+	}
+
+	ref, err := s.put(filename, imageContentType(filename), data)
+	if err != nil {
+		return fmt.Errorf("failed to save meme image: %w", err)
+	}
+	m.Image = ref
+	if err := s.memes.Update(m); err != nil {
+		return fmt.Errorf("failed to save meme image: %w", err)
+	}
+	return s.legacy.DeleteMemeImages(id)
+}
+
+// SaveSnapshot stores an immutable copy of a template image as a blob and returns its hash
+func (s *BlobImageStore) SaveSnapshot(data []byte) (string, error) {
+	return s.blobs.Put(data)
+}
+
+// OpenSnapshot opens a template image snapshot by its content hash
+func (s *BlobImageStore) OpenSnapshot(hash string) (io.ReadCloser, meme.ImageInfo, error) {
+	src, info, err := s.blobs.Open(hash)
+	if err != nil {
+		return nil, meme.ImageInfo{}, fmt.Errorf("snapshot %s: %w", hash, err)
+	}
+	return src, meme.ImageInfo{
+		Name:        hash,
+		ContentType: info.ContentType,
+		Size:        info.Size,
+		Version:     hash,
+	}, nil
+}
+
+// put stores image data as a blob and returns a reference to it
+func (s *BlobImageStore) put(name, contentType string, data []byte) (*domain.ImageRef, error) {
+	hash, err := s.blobs.Put(data)
+	if err != nil {
+		return nil, err
+	}
+	return &domain.ImageRef{Blob: hash, Name: name, ContentType: contentType, Size: int64(len(data))}, nil
+}
+
+// openRef opens the blob an image reference points at
+func (s *BlobImageStore) openRef(ref *domain.ImageRef, entity string) (io.ReadCloser, meme.ImageInfo, error) {
+	src, _, err := s.blobs.Open(ref.Blob)
+	if err != nil {
+		return nil, meme.ImageInfo{}, fmt.Errorf("%s: %w", entity, err)
+	}
+	return src, refImageInfo(ref), nil
+}
+
+// refImageInfo describes the image an image reference points at. Blobs never change, so the hash serves
+// as the version.
+func refImageInfo(ref *domain.ImageRef) meme.ImageInfo {
+	return meme.ImageInfo{
+		Name:        ref.Name,
+		ContentType: ref.ContentType,
+		Size:        ref.Size,
+		Version:     ref.Blob,
+	}
+}
@@ -0,0 +1,175 @@
+package repository
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"memes-generator/internal/config"
+	"memes-generator/internal/domain"
+	"memes-generator/internal/meme"
+)
+
+// FileImageStore reads the images kept in the images/ directories of memes and templates below the
+// configured data directory, the layout used before the blob store. BlobImageStore falls back to it for
+// entities whose metadata does not point at a blob yet, and removes the files once they have been moved.
+type FileImageStore struct {
+	templates entityRoot
+	memes     entityRoot
+}
+
+// NewFileImageStore creates a new file-based image store
+func NewFileImageStore() *FileImageStore {
+	return &FileImageStore{
+		templates: templateRoot(config.GetTemplatesDir()),
+		memes:     memeRoot(config.GetMemesDir()),
+	}
+}
+
+// StatTemplateImage describes the current image of a template
+func (s *FileImageStore) StatTemplateImage(name string) (meme.ImageInfo, error) {
+	file, info, err := openEntityImage(s.templates, name)
+	if err != nil {
+		return meme.ImageInfo{}, fmt.Errorf("template %s: %w", name, err)
+	}
+	file.Close()
+	return info, nil
+}
+
+// OpenTemplateImage opens the current image of a template
+func (s *FileImageStore) OpenTemplateImage(name string) (io.ReadCloser, meme.ImageInfo, error) {
+	file, info, err := openEntityImage(s.templates, name)
+	if err != nil {
+		return nil, meme.ImageInfo{}, fmt.Errorf("template %s: %w", name, err)
+	}
+	return file, info, nil
+}
+
+// DeleteTemplateImages removes the image files of a template
+func (s *FileImageStore) DeleteTemplateImages(name string) error {
+	templateDir, err := s.templates.path(name)
+	if err != nil {
+		return err
+	}
+	return deleteImages(filepath.Join(templateDir, "images"))
+}
+
+// OpenMemeImage opens the generated image of a meme
+func (s *FileImageStore) OpenMemeImage(id string) (io.ReadCloser, meme.ImageInfo, error) {
+	file, info, err := openEntityImage(s.memes, id)
+	if err != nil {
+		return nil, meme.ImageInfo{}, fmt.Errorf("meme %s: %w", id, err)
+	}
+	return file, info, nil
+}
+
+// DeleteMemeImages removes the image files of a meme
+func (s *FileImageStore) DeleteMemeImages(id string) error {
+	memeDir, err := s.memes.path(id)
+	if err != nil {
+		return err
+	}
+	return deleteImages(filepath.Join(memeDir, "images"))
+}
+
+// validateImageFilename checks that an image file name names a visible image file in the images directory
+func validateImageFilename(filename string) error {
+	if filename != filepath.Base(filename) || strings.ContainsAny(filename, `/\`) || isHiddenEntry(filename) || !isImageFile(filename) {
+		return fmt.Errorf("%w: image file name %q", domain.ErrInvalidName, filename)
+	}
+	return nil
+}
+
+// deleteImages removes the image files of the images directory of a meme or template, holding the entity
+// lock so it does not interleave with other mutations of the same entity
+func deleteImages(imagesDir string) error {
+	unlock, err := LockEntity(filepath.Dir(imagesDir))
+	if err != nil {
+		return fmt.Errorf("failed to lock images directory: %w", err)
+	}
+	defer unlock()
+
+	entries, err := os.ReadDir(imagesDir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read images directory: %w", err)
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() && isImageFile(entry.Name()) {
+			if err := os.Remove(filepath.Join(imagesDir, entry.Name())); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to remove image: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// openEntityImage opens the first image file in the images directory of a meme or template together with
+// its description
+func openEntityImage(root entityRoot, name string) (*os.File, meme.ImageInfo, error) {
+	if err := root.validate(name); err != nil {
+		return nil, meme.ImageInfo{}, err
+	}
+
+	entries, err := root.readDir(name, "images")
+	if err != nil {
+		return nil, meme.ImageInfo{}, domain.ErrImageNotFound
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || isHiddenEntry(entry.Name()) || !isImageFile(entry.Name()) {
+			continue
+		}
+
+		file, err := root.open(name, "images", entry.Name())
+		if err != nil {
+			return nil, meme.ImageInfo{}, fmt.Errorf("failed to open image: %w", err)
+		}
+		info, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return nil, meme.ImageInfo{}, fmt.Errorf("failed to stat image: %w", err)
+		}
+		return file, imageInfo(entry.Name(), info), nil
+	}
+
+	return nil, meme.ImageInfo{}, domain.ErrImageNotFound
+}
+
+// imageInfo builds an image description whose version changes whenever the file is replaced or modified
+func imageInfo(path string, info os.FileInfo) meme.ImageInfo {
+	return meme.ImageInfo{
+		Name:        info.Name(),
+		ContentType: imageContentType(path),
+		Size:        info.Size(),
+		Version:     fmt.Sprintf("%s:%d:%d", info.Name(), info.Size(), info.ModTime().UnixNano()),
+	}
+}
+
+// imageExtension returns the file extension for an image MIME type
+func imageExtension(contentType string) string {
+	switch contentType {
+	case "image/png":
+		return ".png"
+	case "image/gif":
+		return ".gif"
+	default:
+		return ".jpg"
+	}
+}
+
+// imageContentType returns the MIME type of an image file based on its extension
+func imageContentType(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".png":
+		return "image/png"
+	case ".gif":
+		return "image/gif"
+	default:
+		return "image/jpeg"
+	}
+}
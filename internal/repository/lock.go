@@ -0,0 +1,29 @@
+package repository
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// lockDirName is the directory next to meme and template directories that holds their lock files.
+// Lock files live outside the entity directories so that deleting an entity does not delete its lock.
+const lockDirName = ".locks"
+
+// LockEntity takes an exclusive advisory lock on a meme or template directory and returns the function
+// releasing it. The lock is shared by every process using the same data directory, including the web
+// server and cmd/generate.
+func LockEntity(entityDir string) (func(), error) {
+	parent, name := filepath.Split(filepath.Clean(entityDir))
+	lockDir := filepath.Join(parent, lockDirName)
+	if err := os.MkdirAll(lockDir, 0755); err != nil {
+		return nil, err
+	}
+	return lockFile(filepath.Join(lockDir, name+".lock"))
+}
+
+// isHiddenEntry reports whether a directory entry is internal bookkeeping, such as the lock directory
+// or a temporary file, rather than a meme or template
+func isHiddenEntry(name string) bool {
+	return strings.HasPrefix(name, ".")
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+
+package repository
+
+import "sync"
+
+// lockFileMutexes serializes writers within this process where flock is not available
+var lockFileMutexes sync.Map
+
+// lockFile takes an in-process lock keyed by the lock file path. Writers in other processes are not
+// serialized on this platform.
+func lockFile(path string) (func(), error) {
+	mu, _ := lockFileMutexes.LoadOrStore(path, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	return mu.(*sync.Mutex).Unlock, nil
+}
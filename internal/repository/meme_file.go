@@ -2,10 +2,10 @@ package repository
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
-	"time"
 
 	"memes-generator/internal/config"
 	"memes-generator/internal/domain"
@@ -17,13 +17,15 @@ const (
 
 // MemeFileRepository implements domain.MemeRepository using file system
 type MemeFileRepository struct {
-	dataPath string
+	root entityRoot
+	ids  domain.IDGenerator
 }
 
 // NewMemeFileRepository creates a new file-based meme repository
-func NewMemeFileRepository() *MemeFileRepository {
+func NewMemeFileRepository(ids domain.IDGenerator) *MemeFileRepository {
 	return &MemeFileRepository{
-		dataPath: config.GetMemesDir(),
+		root: memeRoot(config.GetMemesDir()),
+		ids:  ids,
 	}
 }
 
@@ -31,80 +33,120 @@ func NewMemeFileRepository() *MemeFileRepository {
 func (r *MemeFileRepository) Create(meme *domain.Meme) error {
 	// Generate unique ID if not set
 	if meme.ID == "" {
-		meme.ID = r.GenerateID()
+		meme.ID = r.ids.NewID()
 	}
 
+	memeDir, err := r.root.path(meme.ID)
+	if err != nil {
+		return err
+	}
+	unlock, err := LockEntity(memeDir)
+	if err != nil {
+		return fmt.Errorf("failed to lock meme %s: %w", meme.ID, err)
+	}
+	defer unlock()
+
 	// Create meme directory
-	memeDir := filepath.Join(r.dataPath, meme.ID)
 	if err := os.MkdirAll(memeDir, 0755); err != nil {
 		return fmt.Errorf("failed to create meme directory: %w", err)
 	}
 
-	// Save metadata
-	metadataPath := filepath.Join(memeDir, "metadata.json")
-	file, err := os.Create(metadataPath)
+	return r.writeMetadata(memeDir, meme)
+}
+
+// Update overwrites the metadata of an existing meme
+func (r *MemeFileRepository) Update(meme *domain.Meme) error {
+	memeDir, err := r.root.path(meme.ID)
+	if err != nil {
+		return err
+	}
+	unlock, err := LockEntity(memeDir)
 	if err != nil {
-		return fmt.Errorf("failed to create metadata file: %w", err)
+		return fmt.Errorf("failed to lock meme %s: %w", meme.ID, err)
 	}
-	defer file.Close()
+	defer unlock()
 
-	encoder := json.NewEncoder(file)
-	encoder.SetIndent("", "  ")
-	if err := encoder.Encode(meme); err != nil {
+	// Check if meme exists
+	if _, err := os.Stat(memeDir); os.IsNotExist(err) {
+		return fmt.Errorf("meme with ID %s: %w", meme.ID, domain.ErrMemeNotFound)
+	}
+
+	return r.writeMetadata(memeDir, meme)
+}
+
+// writeMetadata atomically replaces the metadata in a meme directory; the caller holds the meme lock
+func (r *MemeFileRepository) writeMetadata(memeDir string, meme *domain.Meme) error {
+	meme.SchemaVersion = domain.MemeSchemaVersion
+	data, err := json.MarshalIndent(meme, "", "  ")
+	if err != nil {
 		return fmt.Errorf("failed to encode meme metadata: %w", err)
 	}
 
+	if err := writeFileAtomic(filepath.Join(memeDir, "metadata.json"), append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to save meme metadata: %w", err)
+	}
+
 	return nil
 }
 
-// GetByID retrieves a meme by its ID
+// GetByID retrieves a meme by its ID.
+// Missing or undecodable metadata of an existing meme directory is reported as domain.ErrCorruptMetadata.
 func (r *MemeFileRepository) GetByID(id string) (*domain.Meme, error) {
-	memeDir := filepath.Join(r.dataPath, id)
-	metadataPath := filepath.Join(memeDir, "metadata.json")
+	memeDir, err := r.root.path(id)
+	if err != nil {
+		return nil, err
+	}
 
 	// Check if meme exists
 	if _, err := os.Stat(memeDir); os.IsNotExist(err) {
-		return nil, fmt.Errorf("meme with ID %s not found", id)
+		return nil, fmt.Errorf("meme with ID %s: %w", id, domain.ErrMemeNotFound)
 	}
 
 	// Read metadata
-	file, err := os.Open(metadataPath)
+	data, err := r.root.readFile(id, "metadata.json")
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("meme %s: %w: metadata.json is missing", id, domain.ErrCorruptMetadata)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to open metadata file: %w", err)
+		return nil, fmt.Errorf("failed to read metadata file: %w", err)
 	}
-	defer file.Close()
 
-	var meme domain.Meme
-	decoder := json.NewDecoder(file)
-	if err := decoder.Decode(&meme); err != nil {
-		return nil, fmt.Errorf("failed to decode meme metadata: %w", err)
+	meme, err := DecodeMeme(data)
+	if err != nil {
+		return nil, fmt.Errorf("meme %s: %w", id, err)
 	}
 
-	return &meme, nil
+	return meme, nil
 }
 
-// List returns all memes
+// List returns all memes. Memes whose metadata cannot be read are left out and reported with a
+// *domain.CorruptEntriesError.
 func (r *MemeFileRepository) List() ([]*domain.Meme, error) {
 	// Check if data directory exists
-	if _, err := os.Stat(r.dataPath); os.IsNotExist(err) {
+	if _, err := os.Stat(r.root.dir); os.IsNotExist(err) {
 		return []*domain.Meme{}, nil
 	}
 
-	entries, err := os.ReadDir(r.dataPath)
+	entries, err := os.ReadDir(r.root.dir)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read data directory: %w", err)
 	}
 
 	var memes []*domain.Meme
+	var corrupt []string
 	for _, entry := range entries {
-		if !entry.IsDir() {
+		if !entry.IsDir() || isHiddenEntry(entry.Name()) {
 			continue
 		}
 
 		// Try to load meme metadata
 		meme, err := r.GetByID(entry.Name())
+		if errors.Is(err, domain.ErrMemeNotFound) {
+			// Deleted since the directory was read
+			continue
+		}
 		if err != nil {
-			// Skip invalid meme directories
+			corrupt = append(corrupt, entry.Name())
 			continue
 		}
 
@@ -116,38 +158,43 @@ func (r *MemeFileRepository) List() ([]*domain.Meme, error) {
 		memes = []*domain.Meme{}
 	}
 
+	if corrupt != nil {
+		return memes, &domain.CorruptEntriesError{Entity: "meme", Names: corrupt}
+	}
 	return memes, nil
 }
 
+// ListByTemplate returns all memes made from a template
+func (r *MemeFileRepository) ListByTemplate(template string) ([]*domain.Meme, error) {
+	memes, err := r.List()
+	if err != nil && domain.CorruptEntries(err) == nil {
+		return nil, err
+	}
+	// Unreadable entries may or may not match, so they are reported as well
+	return filterMemesByTemplate(memes, template), err
+}
+
 // Delete removes a meme by its ID
 func (r *MemeFileRepository) Delete(id string) error {
-	memeDir := filepath.Join(r.dataPath, id)
+	memeDir, err := r.root.path(id)
+	if err != nil {
+		return err
+	}
+	unlock, err := LockEntity(memeDir)
+	if err != nil {
+		return fmt.Errorf("failed to lock meme %s: %w", id, err)
+	}
+	defer unlock()
 
 	// Check if meme exists
 	if _, err := os.Stat(memeDir); os.IsNotExist(err) {
-		return fmt.Errorf("meme with ID %s not found", id)
+		return fmt.Errorf("meme with ID %s: %w", id, domain.ErrMemeNotFound)
 	}
 
 	// Remove meme directory
-	if err := os.RemoveAll(memeDir); err != nil {
+	if err := r.root.removeAll(id); err != nil {
 		return fmt.Errorf("failed to delete meme directory: %w", err)
 	}
 
 	return nil
 }
-
-// GenerateID creates a unique ID for a meme
-func (r *MemeFileRepository) GenerateID() string {
-	// In a real application, you would use a proper UUID generator
-	// For simplicity, we'll use timestamp-based ID
-	// Ensure uniqueness by checking if ID already exists
-	for {
-		id := fmt.Sprintf("meme_%d", time.Now().UnixNano())
-		memeDir := filepath.Join(r.dataPath, id)
-		if _, err := os.Stat(memeDir); os.IsNotExist(err) {
-			return id
-		}
-		// If ID exists, generate a new one
-		time.Sleep(time.Nanosecond)
-	}
-}
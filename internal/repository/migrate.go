@@ -0,0 +1,366 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"time"
+
+	"memes-generator/internal/domain"
+	"memes-generator/internal/meme"
+)
+
+// MigratedDocument is a stored document upgraded to the current schema version
+type MigratedDocument struct {
+	Entity string `json:"entity"`
+	Name   string `json:"name"`
+	From   int    `json:"from"`
+	To     int    `json:"to"`
+}
+
+// RenamedTemplate is a template created before slugs were introduced that was moved to a generated slug
+type RenamedTemplate struct {
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Memes int    `json:"memes"`
+}
+
+// MovedImage is an image moved into the blob store: the image of a meme or template kept in its images/
+// directory, or a template snapshot kept in the snapshot directory used before the blob store
+type MovedImage struct {
+	Entity string `json:"entity"`
+	Name   string `json:"name"`
+	Blob   string `json:"blob"`
+}
+
+// BackfilledUsage is a template whose usage counters were rebuilt from the memes made from it
+type BackfilledUsage struct {
+	Template string `json:"template"`
+	Memes    int    `json:"memes"`
+}
+
+// MigrationReport is the result of a bulk schema migration
+type MigrationReport struct {
+	Backend   string             `json:"backend"`
+	DryRun    bool               `json:"dry_run"`
+	Memes     int                `json:"memes"`
+	Templates int                `json:"templates"`
+	Migrated  []MigratedDocument `json:"migrated"`
+	Renamed   []RenamedTemplate  `json:"renamed"`
+	Blobs     []MovedImage       `json:"blobs"`
+	Usage     []BackfilledUsage  `json:"usage"`
+}
+
+// snapshotMigrator is a blob store that can move the template snapshots written before the blob store into it
+type snapshotMigrator interface {
+	migrateSnapshots(dryRun bool) ([]string, error)
+}
+
+// Migrate rewrites every meme and template stored with an older schema version in the current one.
+// Reads already upgrade documents lazily, so migrating only saves that work and lets older documents be
+// dropped from the registry later. With dryRun set the documents are reported but not written. Metadata
+// that cannot be read stops the migration, as renaming templates would miss the memes it belongs to; use
+// Fsck to repair it first.
+// Templates whose name is not a slug are then moved to a generated slug, keeping the old name as an alias.
+// Then the images still kept in images/ directories and the template snapshots are moved into the blob
+// store. Finally templates without usage counters, such as those used before usage was tracked, get them
+// rebuilt from their memes.
+func Migrate(storage *Storage, dryRun bool) (*MigrationReport, error) {
+	report := &MigrationReport{
+		Backend:  storage.Backend,
+		DryRun:   dryRun,
+		Migrated: []MigratedDocument{},
+		Renamed:  []RenamedTemplate{},
+		Blobs:    []MovedImage{},
+		Usage:    []BackfilledUsage{},
+	}
+
+	memes, err := storage.Memes.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list memes: %w", err)
+	}
+	report.Memes = len(memes)
+	for _, meme := range memes {
+		if meme.SchemaVersion >= domain.MemeSchemaVersion {
+			continue
+		}
+		report.Migrated = append(report.Migrated, MigratedDocument{Entity: "meme", Name: meme.ID, From: meme.SchemaVersion, To: domain.MemeSchemaVersion})
+		if dryRun {
+			continue
+		}
+		if err := storage.Memes.Update(meme); err != nil {
+			return nil, fmt.Errorf("failed to migrate meme %s: %w", meme.ID, err)
+		}
+	}
+
+	templates, err := storage.Templates.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list templates: %w", err)
+	}
+	report.Templates = len(templates)
+	for _, template := range templates {
+		if template.SchemaVersion >= domain.TemplateSchemaVersion {
+			continue
+		}
+		report.Migrated = append(report.Migrated, MigratedDocument{Entity: "template", Name: template.Name, From: template.SchemaVersion, To: domain.TemplateSchemaVersion})
+		if dryRun {
+			continue
+		}
+		// Writing the template back stamps the current schema version
+		_, err := storage.Templates.Mutate(template.Name, func(stored *domain.Template) error {
+			stored.SchemaVersion = domain.TemplateSchemaVersion
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to migrate template %s: %w", template.Name, err)
+		}
+	}
+
+	if err := renameLegacyTemplates(storage, templates, report, dryRun); err != nil {
+		return nil, err
+	}
+
+	if err := moveImagesToBlobs(storage, report, dryRun); err != nil {
+		return nil, err
+	}
+
+	if err := backfillUsage(storage, report, dryRun); err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+// backfillUsage records the memes of every template without usage counters as its uses, on the days they
+// were created. Memes deleted before usage was tracked are not counted.
+func backfillUsage(storage *Storage, report *MigrationReport, dryRun bool) error {
+	usages, err := storage.Usage.List()
+	if err != nil {
+		return fmt.Errorf("failed to list template usage: %w", err)
+	}
+	used := make(map[string]bool)
+	for _, usage := range usages {
+		used[usage.Template] = usage.Count > 0
+	}
+
+	templates, err := storage.Templates.List()
+	if err != nil {
+		return fmt.Errorf("failed to list templates: %w", err)
+	}
+	for _, template := range templates {
+		if used[template.Name] {
+			continue
+		}
+		memes, err := storage.Memes.ListByTemplate(template.Name)
+		if err != nil {
+			return fmt.Errorf("failed to list memes of template %s: %w", template.Name, err)
+		}
+		if len(memes) == 0 {
+			continue
+		}
+		report.Usage = append(report.Usage, BackfilledUsage{Template: template.Name, Memes: len(memes)})
+		if dryRun {
+			continue
+		}
+		for _, m := range memes {
+			if err := storage.Usage.Record(m); err != nil {
+				return fmt.Errorf("failed to record usage of template %s: %w", template.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// moveImagesToBlobs stores the images kept in the images/ directories of templates and memes as blobs,
+// points the metadata at them and removes the files. Template snapshots written before the blob store are
+// moved as well.
+func moveImagesToBlobs(storage *Storage, report *MigrationReport, dryRun bool) error {
+	// Renaming templates rewrote them, so they are listed again
+	templates, err := storage.Templates.List()
+	if err != nil {
+		return fmt.Errorf("failed to list templates: %w", err)
+	}
+	for _, template := range templates {
+		if template.Image != nil {
+			continue
+		}
+		name := template.Name
+		data, info, err := readImage(storage.Images.OpenTemplateImage(name))
+		if errors.Is(err, domain.ErrImageNotFound) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read image of template %s: %w", name, err)
+		}
+		report.Blobs = append(report.Blobs, MovedImage{Entity: "template", Name: name, Blob: contentHash(data)})
+		if dryRun {
+			continue
+		}
+		if err := storage.Images.SaveTemplateImage(name, data, info.ContentType, nil); err != nil {
+			return fmt.Errorf("failed to move image of template %s: %w", name, err)
+		}
+	}
+
+	memes, err := storage.Memes.List()
+	if err != nil {
+		return fmt.Errorf("failed to list memes: %w", err)
+	}
+	for _, m := range memes {
+		if m.Image != nil {
+			continue
+		}
+		data, info, err := readImage(storage.Images.OpenMemeImage(m.ID))
+		if errors.Is(err, domain.ErrImageNotFound) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read image of meme %s: %w", m.ID, err)
+		}
+		report.Blobs = append(report.Blobs, MovedImage{Entity: "meme", Name: m.ID, Blob: contentHash(data)})
+		if dryRun {
+			continue
+		}
+		if err := storage.Images.SaveMemeImage(m.ID, info.Name, data); err != nil {
+			return fmt.Errorf("failed to move image of meme %s: %w", m.ID, err)
+		}
+	}
+
+	migrator, ok := storage.Blobs.(snapshotMigrator)
+	if !ok {
+		return nil
+	}
+	hashes, err := migrator.migrateSnapshots(dryRun)
+	if err != nil {
+		return err
+	}
+	for _, hash := range hashes {
+		report.Blobs = append(report.Blobs, MovedImage{Entity: "snapshot", Name: hash, Blob: hash})
+	}
+	return nil
+}
+
+// readImage reads an opened image into memory
+func readImage(src io.ReadCloser, info meme.ImageInfo, err error) ([]byte, meme.ImageInfo, error) {
+	if err != nil {
+		return nil, info, err
+	}
+	defer src.Close()
+
+	data, err := io.ReadAll(src)
+	if err != nil {
+		return nil, info, err
+	}
+	return data, info, nil
+}
+
+// renameLegacyTemplates moves every template named before slugs existed, e.g. "Кот в шоке", to a slug
+// generated from its display name. The old name becomes an alias, so lookups by it redirect to the slug,
+// and memes are repointed. A template is copied before the original is deleted; if a previous run was
+// interrupted, the copy carrying the old name as an alias is reused.
+func renameLegacyTemplates(storage *Storage, templates []*domain.Template, report *MigrationReport, dryRun bool) error {
+	taken := make(map[string]bool)
+	for _, template := range templates {
+		taken[domain.AliasKey(template.Name)] = true
+		for _, alias := range template.Aliases {
+			taken[domain.AliasKey(alias)] = true
+		}
+	}
+
+	for _, template := range templates {
+		if domain.IsSlug(template.Name) {
+			continue
+		}
+
+		slug := renamedCopy(templates, template.Name)
+		if slug == "" {
+			displayName := template.DisplayName
+			if displayName == "" {
+				displayName = template.Name
+			}
+			base := domain.Slugify(displayName)
+			for n := 1; ; n++ {
+				slug = domain.SlugCandidate(base, n)
+				if taken[slug] || domain.IsReservedSlug(slug) {
+					continue
+				}
+				if _, err := storage.Templates.GetByName(slug); !errors.Is(err, domain.ErrTemplateNotFound) {
+					continue
+				}
+				break
+			}
+			taken[slug] = true
+		}
+
+		memes, err := storage.Memes.ListByTemplate(template.Name)
+		if err != nil {
+			return fmt.Errorf("failed to list memes of template %s: %w", template.Name, err)
+		}
+		report.Renamed = append(report.Renamed, RenamedTemplate{From: template.Name, To: slug, Memes: len(memes)})
+		if dryRun {
+			continue
+		}
+
+		if err := renameTemplate(storage, template, slug, memes); err != nil {
+			return fmt.Errorf("failed to rename template %s to %s: %w", template.Name, slug, err)
+		}
+	}
+
+	return nil
+}
+
+// renamedCopy returns the slug of a template that already carries name as an alias
+func renamedCopy(templates []*domain.Template, name string) string {
+	for _, template := range templates {
+		if !domain.IsSlug(template.Name) {
+			continue
+		}
+		for _, alias := range template.Aliases {
+			if alias == name {
+				return template.Name
+			}
+		}
+	}
+	return ""
+}
+
+// renameTemplate copies a template with its image to slug, repoints its memes and deletes the original
+func renameTemplate(storage *Storage, template *domain.Template, slug string, memes []*domain.Meme) error {
+	renamed := *template
+	renamed.Name = slug
+	if renamed.DisplayName == "" {
+		renamed.DisplayName = template.Name
+	}
+	renamed.Aliases = append([]string{}, template.Aliases...)
+	if !slices.Contains(renamed.Aliases, template.Name) {
+		renamed.Aliases = append(renamed.Aliases, template.Name)
+	}
+	renamed.UpdatedAt = time.Now()
+	if err := storage.Templates.Create(&renamed); err != nil {
+		return err
+	}
+
+	src, info, err := storage.Images.OpenTemplateImage(template.Name)
+	if err == nil {
+		data, err := io.ReadAll(src)
+		src.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read template image: %w", err)
+		}
+		if err := storage.Images.SaveTemplateImage(slug, data, info.ContentType, nil); err != nil {
+			return err
+		}
+	} else if !errors.Is(err, domain.ErrImageNotFound) {
+		return err
+	}
+
+	for _, meme := range memes {
+		meme.Template = slug
+		meme.UpdatedAt = time.Now()
+		if err := storage.Memes.Update(meme); err != nil {
+			return fmt.Errorf("failed to repoint meme %s: %w", meme.ID, err)
+		}
+	}
+
+	return storage.Templates.Delete(template.Name)
+}
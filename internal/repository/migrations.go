@@ -0,0 +1,106 @@
+package repository
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"memes-generator/internal/domain"
+	"memes-generator/internal/schema"
+)
+
+// Migrations of stored metadata. Each migration upgrades a document from version N to N+1 and must
+// tolerate documents written by any earlier build; register new ones when bumping the schema versions
+// in the domain package.
+var (
+	memeSchema     = schema.NewRegistry("meme", domain.MemeSchemaVersion)
+	templateSchema = schema.NewRegistry("template", domain.TemplateSchemaVersion)
+)
+
+func init() {
+	memeSchema.Register(0, fillUpdatedAt)
+	templateSchema.Register(0, fillUpdatedAt)
+	templateSchema.Register(1, fillDisplayName)
+	templateSchema.Register(2, startVersionHistory)
+}
+
+// fillUpdatedAt upgrades unversioned documents, which could be stored without a modification time,
+// by falling back to the creation time
+func fillUpdatedAt(doc map[string]any) error {
+	updated, _ := doc["updated_at"].(string)
+	if t, err := time.Parse(time.RFC3339Nano, updated); err == nil && !t.IsZero() {
+		return nil
+	}
+	doc["updated_at"] = doc["created_at"]
+	return nil
+}
+
+// fillDisplayName upgrades templates stored before display names were separated from slugs: the
+// display name starts out as the name the template was created with
+func fillDisplayName(doc map[string]any) error {
+	if name, _ := doc["display_name"].(string); name != "" {
+		return nil
+	}
+	doc["display_name"] = doc["name"]
+	return nil
+}
+
+// startVersionHistory upgrades templates stored before versioning: a template with an image or caption
+// options gets them recorded as version 1, so memes and rollbacks can refer to its current state
+func startVersionHistory(doc map[string]any) error {
+	if _, ok := doc["versions"]; ok {
+		return nil
+	}
+	version := map[string]any{
+		"version":    1,
+		"change":     string(domain.TemplateChangeInitial),
+		"created_at": doc["updated_at"],
+	}
+	for _, field := range []string{"image", "layout", "default_style"} {
+		if value, ok := doc[field]; ok && value != nil {
+			version[field] = value
+		}
+	}
+	if len(version) == 3 {
+		return nil
+	}
+	doc["version"] = 1
+	doc["versions"] = []any{version}
+	return nil
+}
+
+// DecodeMeme decodes stored meme metadata, upgrading it to the current schema.
+// Undecodable documents are reported as domain.ErrCorruptMetadata.
+func DecodeMeme(data []byte) (*domain.Meme, error) {
+	var meme domain.Meme
+	if err := decodeVersioned(memeSchema, data, &meme); err != nil {
+		return nil, err
+	}
+	return &meme, nil
+}
+
+// DecodeTemplate decodes stored template metadata, upgrading it to the current schema.
+// Undecodable documents are reported as domain.ErrCorruptMetadata.
+func DecodeTemplate(data []byte) (*domain.Template, error) {
+	var template domain.Template
+	if err := decodeVersioned(templateSchema, data, &template); err != nil {
+		return nil, err
+	}
+	return &template, nil
+}
+
+// decodeVersioned migrates a stored document with a registry and decodes it into v
+func decodeVersioned(registry *schema.Registry, data []byte, v any) error {
+	migrated, err := registry.Migrate(data)
+	if errors.Is(err, domain.ErrUnsupportedSchema) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %v", domain.ErrCorruptMetadata, err)
+	}
+	if err := json.Unmarshal(migrated, v); err != nil {
+		return fmt.Errorf("%w: %v", domain.ErrCorruptMetadata, err)
+	}
+	return nil
+}
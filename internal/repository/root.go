@@ -0,0 +1,93 @@
+package repository
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"memes-generator/internal/domain"
+)
+
+// entityRoot confines file access to the meme or template directories below one data directory.
+// Names are checked with the domain validation rules before they are joined to the directory, entity
+// directories that are symbolic links are refused, and files are read through os.Root so that a symlink
+// inside an entity directory cannot lead outside of the data directory either.
+type entityRoot struct {
+	dir      string
+	validate func(name string) error
+}
+
+// memeRoot confines access to the meme directories below dir
+func memeRoot(dir string) entityRoot {
+	return entityRoot{dir: dir, validate: domain.ValidateMemeID}
+}
+
+// templateRoot confines access to the template directories below dir
+func templateRoot(dir string) entityRoot {
+	return entityRoot{dir: dir, validate: domain.ValidateTemplateName}
+}
+
+// path returns the directory of an entity
+func (e entityRoot) path(name string) (string, error) {
+	if err := e.validate(name); err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(e.dir, name)
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		return "", fmt.Errorf("%w: %s is a symbolic link", domain.ErrInvalidName, path)
+	}
+	return path, nil
+}
+
+// removeAll deletes the directory of an entity. A symbolic link is removed itself, never its target.
+func (e entityRoot) removeAll(name string) error {
+	if err := e.validate(name); err != nil {
+		return err
+	}
+	return os.RemoveAll(filepath.Join(e.dir, name))
+}
+
+// open opens a file below the directory of an entity through os.Root
+func (e entityRoot) open(name string, elem ...string) (*os.File, error) {
+	if err := e.validate(name); err != nil {
+		return nil, err
+	}
+
+	root, err := os.OpenRoot(e.dir)
+	if err != nil {
+		return nil, err
+	}
+	defer root.Close()
+
+	return root.Open(filepath.Join(append([]string{name}, elem...)...))
+}
+
+// readFile reads a file below the directory of an entity
+func (e entityRoot) readFile(name string, elem ...string) ([]byte, error) {
+	file, err := e.open(name, elem...)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return io.ReadAll(file)
+}
+
+// readDir lists a directory below the directory of an entity sorted by file name
+func (e entityRoot) readDir(name string, elem ...string) ([]os.DirEntry, error) {
+	dir, err := e.open(name, elem...)
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+
+	entries, err := dir.ReadDir(-1)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
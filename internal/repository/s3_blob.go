@@ -0,0 +1,144 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path"
+
+	"memes-generator/internal/domain"
+)
+
+// S3BlobStore implements domain.BlobStore using S3-compatible object storage. Blobs are kept under
+// blobs/<first two hex digits>/<sha256>; template snapshots written before the blob store existed are still
+// read from snapshots/ until migrate moves them.
+type S3BlobStore struct {
+	client *S3Client
+}
+
+// NewS3BlobStore creates a new object storage blob store
+func NewS3BlobStore(client *S3Client) *S3BlobStore {
+	return &S3BlobStore{
+		client: client,
+	}
+}
+
+// Put stores data under its content hash and returns the hash. Objects cannot be touched, so stored bytes
+// are uploaded again, which refreshes the modification time that garbage collection spares recent blobs by.
+func (s *S3BlobStore) Put(data []byte) (string, error) {
+	hash := contentHash(data)
+	if err := s.client.PutObject("blobs/"+blobKey(hash), data, sniffContentType(data)); err != nil {
+		return "", fmt.Errorf("failed to save blob: %w", err)
+	}
+	return hash, nil
+}
+
+// Open opens a blob by its content hash
+func (s *S3BlobStore) Open(hash string) (io.ReadCloser, domain.BlobInfo, error) {
+	if err := domain.ValidateContentHash(hash); err != nil {
+		return nil, domain.BlobInfo{}, err
+	}
+
+	body, object, err := s.client.GetObject("blobs/" + blobKey(hash))
+	if errors.Is(err, errObjectNotFound) {
+		body, object, err = s.client.GetObject("snapshots/" + blobKey(hash))
+	}
+	if errors.Is(err, errObjectNotFound) {
+		return nil, domain.BlobInfo{}, fmt.Errorf("blob %s: %w", hash, domain.ErrImageNotFound)
+	}
+	if err != nil {
+		return nil, domain.BlobInfo{}, fmt.Errorf("failed to open blob: %w", err)
+	}
+
+	return body, domain.BlobInfo{
+		Hash:        hash,
+		Size:        object.Size,
+		ContentType: object.ContentType,
+		ModifiedAt:  object.LastModified,
+	}, nil
+}
+
+// Stat describes a blob of the store without downloading it. Snapshots still kept under the legacy prefix
+// are not found, like they are not listed.
+func (s *S3BlobStore) Stat(hash string) (domain.BlobInfo, error) {
+	if err := domain.ValidateContentHash(hash); err != nil {
+		return domain.BlobInfo{}, err
+	}
+	object, err := s.client.HeadObject("blobs/" + blobKey(hash))
+	if errors.Is(err, errObjectNotFound) {
+		return domain.BlobInfo{}, fmt.Errorf("blob %s: %w", hash, domain.ErrImageNotFound)
+	}
+	if err != nil {
+		return domain.BlobInfo{}, fmt.Errorf("failed to stat blob: %w", err)
+	}
+	return domain.BlobInfo{Hash: hash, Size: object.Size, ContentType: object.ContentType, ModifiedAt: object.LastModified}, nil
+}
+
+// List returns every blob of the store. Snapshots still kept under the legacy prefix are not listed, so
+// garbage collection never removes them.
+func (s *S3BlobStore) List() ([]domain.BlobInfo, error) {
+	objects, _, err := s.client.ListObjects("blobs/", "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list blobs: %w", err)
+	}
+
+	var blobs []domain.BlobInfo
+	for _, object := range objects {
+		hash := path.Base(object.Key)
+		if domain.ValidateContentHash(hash) != nil || object.Key != "blobs/"+blobKey(hash) {
+			continue
+		}
+		blobs = append(blobs, domain.BlobInfo{Hash: hash, Size: object.Size, ModifiedAt: object.LastModified})
+	}
+	return blobs, nil
+}
+
+// Delete removes a blob; deleting a missing blob is not an error
+func (s *S3BlobStore) Delete(hash string) error {
+	if err := domain.ValidateContentHash(hash); err != nil {
+		return err
+	}
+	if err := s.client.DeleteObject("blobs/" + blobKey(hash)); err != nil {
+		return fmt.Errorf("failed to delete blob %s: %w", hash, err)
+	}
+	return nil
+}
+
+// migrateSnapshots copies the template snapshots under the legacy prefix into the blob store, deletes the
+// originals and returns their hashes. With dryRun set the snapshots are only listed.
+func (s *S3BlobStore) migrateSnapshots(dryRun bool) ([]string, error) {
+	objects, _, err := s.client.ListObjects("snapshots/", "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snapshots: %w", err)
+	}
+
+	var moved []string
+	for _, object := range objects {
+		hash := path.Base(object.Key)
+		if domain.ValidateContentHash(hash) != nil || object.Key != "snapshots/"+blobKey(hash) {
+			continue
+		}
+		moved = append(moved, hash)
+		if dryRun {
+			continue
+		}
+
+		body, _, err := s.client.GetObject(object.Key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read snapshot %s: %w", hash, err)
+		}
+		data, err := io.ReadAll(body)
+		body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read snapshot %s: %w", hash, err)
+		}
+		if _, err := s.Put(data); err != nil {
+			return nil, err
+		}
+		if err := s.client.DeleteObject(object.Key); err != nil {
+			return nil, fmt.Errorf("failed to delete snapshot %s: %w", hash, err)
+		}
+	}
+
+	return moved, nil
+}
@@ -0,0 +1,403 @@
+package repository
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"memes-generator/internal/config"
+)
+
+// errObjectNotFound is returned when a requested object does not exist in the bucket
+var errObjectNotFound = errors.New("object not found")
+
+// errPreconditionFailed is returned when a conditional request finds the object changed by another writer
+var errPreconditionFailed = errors.New("object precondition failed")
+
+// S3Client is a minimal client for S3-compatible object storage such as Cloud.ru Object Storage or MinIO.
+// Requests use path-style addressing and are signed with AWS Signature Version 4.
+type S3Client struct {
+	endpoint        *url.URL
+	region          string
+	bucket          string
+	prefix          string
+	accessKeyID     string
+	secretAccessKey string
+	httpClient      *http.Client
+}
+
+// S3Object describes an object stored in the bucket
+type S3Object struct {
+	Key          string
+	Size         int64
+	ETag         string
+	ContentType  string
+	LastModified time.Time
+}
+
+// NewS3Client creates a new object storage client from the S3_* environment variables
+func NewS3Client() (*S3Client, error) {
+	if config.GetS3Endpoint() == "" || config.GetS3Bucket() == "" {
+		return nil, fmt.Errorf("%s and %s must be set for the %s storage backend", config.S3EndpointEnv, config.S3BucketEnv, config.StorageBackendS3)
+	}
+
+	endpoint, err := url.Parse(strings.TrimRight(config.GetS3Endpoint(), "/"))
+	if err != nil || endpoint.Scheme == "" || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid %s %q", config.S3EndpointEnv, config.GetS3Endpoint())
+	}
+
+	prefix := strings.Trim(config.GetS3Prefix(), "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	return &S3Client{
+		endpoint:        endpoint,
+		region:          config.GetS3Region(),
+		bucket:          config.GetS3Bucket(),
+		prefix:          prefix,
+		accessKeyID:     config.GetS3AccessKeyID(),
+		secretAccessKey: config.GetS3SecretAccessKey(),
+		httpClient: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+	}, nil
+}
+
+// PutObject stores an object, replacing any previous object with the same key
+func (c *S3Client) PutObject(key string, data []byte, contentType string) error {
+	header := http.Header{}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+
+	resp, err := c.do(http.MethodPut, key, nil, header, data)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// PutObjectIfAbsent stores an object only if no object exists under its key
+func (c *S3Client) PutObjectIfAbsent(key string, data []byte, contentType string) error {
+	header := http.Header{}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	header.Set("If-None-Match", "*")
+
+	resp, err := c.do(http.MethodPut, key, nil, header, data)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// PutObjectIfMatch stores an object only if its current version still carries etag
+func (c *S3Client) PutObjectIfMatch(key string, data []byte, contentType, etag string) error {
+	header := http.Header{}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	header.Set("If-Match", `"`+etag+`"`)
+
+	resp, err := c.do(http.MethodPut, key, nil, header, data)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// GetObject opens an object for reading
+func (c *S3Client) GetObject(key string) (io.ReadCloser, S3Object, error) {
+	resp, err := c.do(http.MethodGet, key, nil, nil, nil)
+	if err != nil {
+		return nil, S3Object{}, err
+	}
+	return resp.Body, objectFromResponse(key, resp), nil
+}
+
+// HeadObject describes an object without reading it
+func (c *S3Client) HeadObject(key string) (S3Object, error) {
+	resp, err := c.do(http.MethodHead, key, nil, nil, nil)
+	if err != nil {
+		return S3Object{}, err
+	}
+	resp.Body.Close()
+	return objectFromResponse(key, resp), nil
+}
+
+// DeleteObject removes an object; removing a missing object is not an error
+func (c *S3Client) DeleteObject(key string) error {
+	resp, err := c.do(http.MethodDelete, key, nil, nil, nil)
+	if errors.Is(err, errObjectNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// DeletePrefix removes every object whose key starts with prefix
+func (c *S3Client) DeletePrefix(prefix string) error {
+	objects, _, err := c.ListObjects(prefix, "")
+	if err != nil {
+		return err
+	}
+	for _, object := range objects {
+		if err := c.DeleteObject(object.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListObjects lists the objects under prefix in key order. With a delimiter, keys containing it after
+// the prefix are rolled up into the returned common prefixes instead, like directories in a file system.
+// Keys and prefixes are relative to the configured S3_PREFIX.
+func (c *S3Client) ListObjects(prefix, delimiter string) ([]S3Object, []string, error) {
+	var objects []S3Object
+	var prefixes []string
+
+	token := ""
+	for {
+		query := url.Values{}
+		query.Set("list-type", "2")
+		query.Set("prefix", c.prefix+prefix)
+		if delimiter != "" {
+			query.Set("delimiter", delimiter)
+		}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		resp, err := c.do(http.MethodGet, "", query, nil, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		var result struct {
+			Contents []struct {
+				Key          string    `xml:"Key"`
+				Size         int64     `xml:"Size"`
+				ETag         string    `xml:"ETag"`
+				LastModified time.Time `xml:"LastModified"`
+			} `xml:"Contents"`
+			CommonPrefixes []struct {
+				Prefix string `xml:"Prefix"`
+			} `xml:"CommonPrefixes"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to decode object listing: %w", err)
+		}
+
+		for _, content := range result.Contents {
+			objects = append(objects, S3Object{
+				Key:          strings.TrimPrefix(content.Key, c.prefix),
+				Size:         content.Size,
+				ETag:         strings.Trim(content.ETag, `"`),
+				LastModified: content.LastModified,
+			})
+		}
+		for _, common := range result.CommonPrefixes {
+			prefixes = append(prefixes, strings.TrimPrefix(common.Prefix, c.prefix))
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+
+	return objects, prefixes, nil
+}
+
+// do sends a signed request for an object key, or for the bucket itself when key is empty.
+// Responses other than 2xx are turned into errors; 404 is reported as errObjectNotFound, and a failed
+// condition, 412 or a 409 conflict with a concurrent conditional write, as errPreconditionFailed.
+func (c *S3Client) do(method, key string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	path := "/" + c.bucket
+	if key != "" {
+		path += "/" + c.prefix + key
+	}
+
+	reqURL := *c.endpoint
+	reqURL.Path = c.endpoint.Path + path
+	reqURL.RawPath = encodePath(c.endpoint.Path + path)
+	reqURL.RawQuery = encodeQuery(query)
+
+	req, err := http.NewRequest(method, reqURL.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create object storage request: %w", err)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.ContentLength = int64(len(body))
+	c.sign(req, body, time.Now().UTC())
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send object storage request: %w", err)
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%s %s: %w", method, path, errObjectNotFound)
+	}
+	if resp.StatusCode == http.StatusPreconditionFailed || resp.StatusCode == http.StatusConflict && header.Get("If-Match")+header.Get("If-None-Match") != "" {
+		return nil, fmt.Errorf("%s %s: %w", method, path, errPreconditionFailed)
+	}
+
+	var s3Err struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if xml.Unmarshal(data, &s3Err) == nil && s3Err.Code != "" {
+		return nil, fmt.Errorf("object storage %s %s failed with status %d: %s: %s", method, path, resp.StatusCode, s3Err.Code, s3Err.Message)
+	}
+	return nil, fmt.Errorf("object storage %s %s failed with status %d", method, path, resp.StatusCode)
+}
+
+// sign adds an AWS Signature Version 4 authorization header to a request
+func (c *S3Client) sign(req *http.Request, body []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("Host", req.URL.Host)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	// Every header set above, plus the content type, is covered by the signature
+	var names []string
+	for name := range req.Header {
+		names = append(names, strings.ToLower(name))
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(req.Header.Get(name)) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + c.region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+c.secretAccessKey), date)
+	key = hmacSHA256(key, c.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.accessKeyID, scope, signedHeaders, signature))
+	req.Header.Del("Host")
+	req.Host = req.URL.Host
+}
+
+// objectFromResponse describes an object from the headers of a GET or HEAD response
+func objectFromResponse(key string, resp *http.Response) S3Object {
+	size, _ := strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
+	modified, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return S3Object{
+		Key:          key,
+		Size:         size,
+		ETag:         strings.Trim(resp.Header.Get("ETag"), `"`),
+		ContentType:  resp.Header.Get("Content-Type"),
+		LastModified: modified,
+	}
+}
+
+// encodePath escapes an object path the way Signature Version 4 expects, keeping slashes
+func encodePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = uriEncode(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// encodeQuery builds a query string sorted by key with Signature Version 4 escaping
+func encodeQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, key := range keys {
+		for _, value := range query[key] {
+			parts = append(parts, uriEncode(key)+"="+uriEncode(value))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// uriEncode percent-encodes everything except the RFC 3986 unreserved characters
+func uriEncode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if ('A' <= ch && ch <= 'Z') || ('a' <= ch && ch <= 'z') || ('0' <= ch && ch <= '9') ||
+			ch == '-' || ch == '_' || ch == '.' || ch == '~' {
+			b.WriteByte(ch)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", ch)
+		}
+	}
+	return b.String()
+}
+
+// sha256Hex returns the hex-encoded SHA-256 of data
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of data under key
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,136 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path"
+
+	"memes-generator/internal/domain"
+	"memes-generator/internal/meme"
+)
+
+// S3ImageStore reads the images kept under the images/ prefixes of memes and templates in S3-compatible
+// object storage, the layout used before the blob store. BlobImageStore falls back to it for entities
+// whose metadata does not point at a blob yet, and removes the objects once they have been moved.
+type S3ImageStore struct {
+	client *S3Client
+}
+
+// NewS3ImageStore creates a new object storage image store
+func NewS3ImageStore(client *S3Client) *S3ImageStore {
+	return &S3ImageStore{
+		client: client,
+	}
+}
+
+// StatTemplateImage describes the current image of a template
+func (s *S3ImageStore) StatTemplateImage(name string) (meme.ImageInfo, error) {
+	if err := domain.ValidateTemplateName(name); err != nil {
+		return meme.ImageInfo{}, err
+	}
+
+	object, err := s.findImage("templates/" + name + "/images/")
+	if err != nil {
+		return meme.ImageInfo{}, fmt.Errorf("template %s: %w", name, err)
+	}
+	return objectImageInfo(object), nil
+}
+
+// OpenTemplateImage opens the current image of a template
+func (s *S3ImageStore) OpenTemplateImage(name string) (io.ReadCloser, meme.ImageInfo, error) {
+	if err := domain.ValidateTemplateName(name); err != nil {
+		return nil, meme.ImageInfo{}, err
+	}
+
+	object, err := s.findImage("templates/" + name + "/images/")
+	if err != nil {
+		return nil, meme.ImageInfo{}, fmt.Errorf("template %s: %w", name, err)
+	}
+	return s.openImage(object.Key)
+}
+
+// DeleteTemplateImages removes the image objects of a template
+func (s *S3ImageStore) DeleteTemplateImages(name string) error {
+	if err := domain.ValidateTemplateName(name); err != nil {
+		return err
+	}
+	return s.deleteImages("templates/" + name + "/images/")
+}
+
+// OpenMemeImage opens the generated image of a meme
+func (s *S3ImageStore) OpenMemeImage(id string) (io.ReadCloser, meme.ImageInfo, error) {
+	if err := domain.ValidateMemeID(id); err != nil {
+		return nil, meme.ImageInfo{}, err
+	}
+
+	object, err := s.findImage("memes/" + id + "/images/")
+	if err != nil {
+		return nil, meme.ImageInfo{}, fmt.Errorf("meme %s: %w", id, err)
+	}
+	return s.openImage(object.Key)
+}
+
+// DeleteMemeImages removes the image objects of a meme
+func (s *S3ImageStore) DeleteMemeImages(id string) error {
+	if err := domain.ValidateMemeID(id); err != nil {
+		return err
+	}
+	return s.deleteImages("memes/" + id + "/images/")
+}
+
+// deleteImages removes the image objects under a prefix
+func (s *S3ImageStore) deleteImages(prefix string) error {
+	objects, _, err := s.client.ListObjects(prefix, "/")
+	if err != nil {
+		return fmt.Errorf("failed to list images: %w", err)
+	}
+	for _, object := range objects {
+		if isImageFile(object.Key) {
+			if err := s.client.DeleteObject(object.Key); err != nil {
+				return fmt.Errorf("failed to remove image: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// findImage returns the first image object under a prefix
+func (s *S3ImageStore) findImage(prefix string) (S3Object, error) {
+	objects, _, err := s.client.ListObjects(prefix, "/")
+	if err != nil {
+		return S3Object{}, err
+	}
+
+	for _, object := range objects {
+		if isImageFile(object.Key) {
+			return object, nil
+		}
+	}
+
+	return S3Object{}, domain.ErrImageNotFound
+}
+
+// openImage opens an image object together with its description
+func (s *S3ImageStore) openImage(key string) (io.ReadCloser, meme.ImageInfo, error) {
+	body, object, err := s.client.GetObject(key)
+	if errors.Is(err, errObjectNotFound) {
+		// The image was replaced between listing and reading it
+		return nil, meme.ImageInfo{}, domain.ErrImageNotFound
+	}
+	if err != nil {
+		return nil, meme.ImageInfo{}, fmt.Errorf("failed to open image: %w", err)
+	}
+	return body, objectImageInfo(object), nil
+}
+
+// objectImageInfo builds an image description whose version changes whenever the object is replaced
+func objectImageInfo(object S3Object) meme.ImageInfo {
+	name := path.Base(object.Key)
+	return meme.ImageInfo{
+		Name:        name,
+		ContentType: imageContentType(object.Key),
+		Size:        object.Size,
+		Version:     fmt.Sprintf("%s:%d:%s", name, object.Size, object.ETag),
+	}
+}
@@ -0,0 +1,171 @@
+package repository
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"memes-generator/internal/domain"
+)
+
+// S3MemeRepository implements domain.MemeRepository using S3-compatible object storage.
+// Each meme is kept under memes/<meme_id>/ in the bucket, mirroring the file layout.
+type S3MemeRepository struct {
+	client *S3Client
+	ids    domain.IDGenerator
+}
+
+// NewS3MemeRepository creates a new object storage meme repository
+func NewS3MemeRepository(client *S3Client, ids domain.IDGenerator) *S3MemeRepository {
+	return &S3MemeRepository{
+		client: client,
+		ids:    ids,
+	}
+}
+
+// memeMetadataKey returns the object key of a meme's metadata
+func memeMetadataKey(id string) string {
+	return "memes/" + id + "/metadata.json"
+}
+
+// Create saves a new meme to the bucket
+func (r *S3MemeRepository) Create(meme *domain.Meme) error {
+	// Generate unique ID if not set
+	if meme.ID == "" {
+		meme.ID = r.ids.NewID()
+	}
+	if err := domain.ValidateMemeID(meme.ID); err != nil {
+		return err
+	}
+
+	return r.writeMetadata(meme)
+}
+
+// Update overwrites the metadata of an existing meme
+func (r *S3MemeRepository) Update(meme *domain.Meme) error {
+	if err := domain.ValidateMemeID(meme.ID); err != nil {
+		return err
+	}
+
+	// Check if meme exists
+	if _, err := r.client.HeadObject(memeMetadataKey(meme.ID)); err != nil {
+		if errors.Is(err, errObjectNotFound) {
+			return fmt.Errorf("meme with ID %s: %w", meme.ID, domain.ErrMemeNotFound)
+		}
+		return err
+	}
+
+	return r.writeMetadata(meme)
+}
+
+// writeMetadata saves meme metadata into the bucket
+func (r *S3MemeRepository) writeMetadata(meme *domain.Meme) error {
+	meme.SchemaVersion = domain.MemeSchemaVersion
+	data, err := json.MarshalIndent(meme, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode meme metadata: %w", err)
+	}
+
+	if err := r.client.PutObject(memeMetadataKey(meme.ID), data, "application/json"); err != nil {
+		return fmt.Errorf("failed to save meme metadata: %w", err)
+	}
+
+	return nil
+}
+
+// GetByID retrieves a meme by its ID
+func (r *S3MemeRepository) GetByID(id string) (*domain.Meme, error) {
+	if err := domain.ValidateMemeID(id); err != nil {
+		return nil, err
+	}
+
+	body, _, err := r.client.GetObject(memeMetadataKey(id))
+	if errors.Is(err, errObjectNotFound) {
+		return nil, fmt.Errorf("meme with ID %s: %w", id, domain.ErrMemeNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open metadata object: %w", err)
+	}
+	defer body.Close()
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read metadata object: %w", err)
+	}
+	meme, err := DecodeMeme(data)
+	if err != nil {
+		return nil, fmt.Errorf("meme %s: %w", id, err)
+	}
+
+	return meme, nil
+}
+
+// List returns all memes; memes whose metadata cannot be read are reported with a *domain.CorruptEntriesError
+func (r *S3MemeRepository) List() ([]*domain.Meme, error) {
+	_, prefixes, err := r.client.ListObjects("memes/", "/")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list memes: %w", err)
+	}
+
+	var memes []*domain.Meme
+	var corrupt []string
+	for _, prefix := range prefixes {
+		id := strings.TrimSuffix(strings.TrimPrefix(prefix, "memes/"), "/")
+
+		// Try to load meme metadata
+		meme, err := r.GetByID(id)
+		if errors.Is(err, domain.ErrMemeNotFound) {
+			// Prefixes without a metadata object hold no meme
+			continue
+		}
+		if err != nil {
+			corrupt = append(corrupt, id)
+			continue
+		}
+
+		memes = append(memes, meme)
+	}
+
+	// Ensure we always return an array, even if empty
+	if memes == nil {
+		memes = []*domain.Meme{}
+	}
+
+	if corrupt != nil {
+		return memes, &domain.CorruptEntriesError{Entity: "meme", Names: corrupt}
+	}
+	return memes, nil
+}
+
+// ListByTemplate returns all memes made from a template
+func (r *S3MemeRepository) ListByTemplate(template string) ([]*domain.Meme, error) {
+	memes, err := r.List()
+	if err != nil && domain.CorruptEntries(err) == nil {
+		return nil, err
+	}
+	// Unreadable entries may or may not match, so they are reported as well
+	return filterMemesByTemplate(memes, template), err
+}
+
+// Delete removes a meme and its images by its ID
+func (r *S3MemeRepository) Delete(id string) error {
+	if err := domain.ValidateMemeID(id); err != nil {
+		return err
+	}
+
+	// Check if meme exists
+	if _, err := r.client.HeadObject(memeMetadataKey(id)); err != nil {
+		if errors.Is(err, errObjectNotFound) {
+			return fmt.Errorf("meme with ID %s: %w", id, domain.ErrMemeNotFound)
+		}
+		return err
+	}
+
+	if err := r.client.DeletePrefix("memes/" + id + "/"); err != nil {
+		return fmt.Errorf("failed to delete meme objects: %w", err)
+	}
+
+	return nil
+}
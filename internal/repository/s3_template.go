@@ -0,0 +1,239 @@
+package repository
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"memes-generator/internal/domain"
+)
+
+// S3TemplateRepository implements domain.TemplateRepository using S3-compatible object storage.
+// Each template is kept under templates/<name>/ in the bucket, mirroring the file layout.
+type S3TemplateRepository struct {
+	client *S3Client
+}
+
+// NewS3TemplateRepository creates a new object storage template repository
+func NewS3TemplateRepository(client *S3Client) *S3TemplateRepository {
+	return &S3TemplateRepository{
+		client: client,
+	}
+}
+
+// templateMetadataKey returns the object key of a template's metadata
+func templateMetadataKey(name string) string {
+	return "templates/" + name + "/metadata.json"
+}
+
+// Create saves a new template to the bucket. The metadata object is only written if it does not exist
+// yet, so a slug that is taken is refused with domain.ErrTemplateExists.
+func (r *S3TemplateRepository) Create(template *domain.Template) error {
+	if err := domain.ValidateTemplateName(template.Name); err != nil {
+		return err
+	}
+
+	data, err := encodeS3Template(template)
+	if err != nil {
+		return err
+	}
+	err = r.client.PutObjectIfAbsent(templateMetadataKey(template.Name), data, "application/json")
+	if errors.Is(err, errPreconditionFailed) {
+		return fmt.Errorf("template with name %s: %w", template.Name, domain.ErrTemplateExists)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save template metadata: %w", err)
+	}
+	return nil
+}
+
+// Update overwrites the metadata of an existing template
+func (r *S3TemplateRepository) Update(template *domain.Template) error {
+	if err := domain.ValidateTemplateName(template.Name); err != nil {
+		return err
+	}
+
+	// Check if template exists
+	if _, err := r.client.HeadObject(templateMetadataKey(template.Name)); err != nil {
+		if errors.Is(err, errObjectNotFound) {
+			return fmt.Errorf("template with name %s: %w", template.Name, domain.ErrTemplateNotFound)
+		}
+		return err
+	}
+
+	return r.writeMetadata(template)
+}
+
+// maxMutateAttempts bounds how often Mutate retries after losing a race with another writer
+const maxMutateAttempts = 10
+
+// Mutate applies mutate to a template and writes the result only if the metadata object was not replaced
+// in the meantime. The bucket offers no locks, so when another writer got in between, the template is
+// read again and mutate is retried.
+func (r *S3TemplateRepository) Mutate(name string, mutate func(*domain.Template) error) (*domain.Template, error) {
+	for attempt := 0; attempt < maxMutateAttempts; attempt++ {
+		template, object, err := r.getTemplate(name)
+		if err != nil {
+			return nil, err
+		}
+		changed, err := applyTemplateMutation(template, mutate)
+		if err != nil || !changed {
+			return template, err
+		}
+		if template.Name != name {
+			return nil, fmt.Errorf("template %s cannot be renamed to %s", name, template.Name)
+		}
+
+		data, err := encodeS3Template(template)
+		if err != nil {
+			return nil, err
+		}
+		err = r.client.PutObjectIfMatch(templateMetadataKey(name), data, "application/json", object.ETag)
+		if errors.Is(err, errPreconditionFailed) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to save template metadata: %w", err)
+		}
+		return template, nil
+	}
+	return nil, fmt.Errorf("failed to save template metadata: template %s kept changing concurrently", name)
+}
+
+// writeMetadata saves template metadata into the bucket
+func (r *S3TemplateRepository) writeMetadata(template *domain.Template) error {
+	data, err := encodeS3Template(template)
+	if err != nil {
+		return err
+	}
+
+	if err := r.client.PutObject(templateMetadataKey(template.Name), data, "application/json"); err != nil {
+		return fmt.Errorf("failed to save template metadata: %w", err)
+	}
+
+	return nil
+}
+
+// encodeS3Template stamps the current schema version on a template and encodes its metadata object
+func encodeS3Template(template *domain.Template) ([]byte, error) {
+	template.SchemaVersion = domain.TemplateSchemaVersion
+	data, err := json.MarshalIndent(template, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode template metadata: %w", err)
+	}
+	return data, nil
+}
+
+// GetByName retrieves a template by its name
+func (r *S3TemplateRepository) GetByName(name string) (*domain.Template, error) {
+	template, _, err := r.getTemplate(name)
+	return template, err
+}
+
+// getTemplate retrieves a template together with the description of its metadata object
+func (r *S3TemplateRepository) getTemplate(name string) (*domain.Template, S3Object, error) {
+	if err := domain.ValidateTemplateName(name); err != nil {
+		return nil, S3Object{}, err
+	}
+
+	body, object, err := r.client.GetObject(templateMetadataKey(name))
+	if errors.Is(err, errObjectNotFound) {
+		return nil, S3Object{}, fmt.Errorf("template with name %s: %w", name, domain.ErrTemplateNotFound)
+	}
+	if err != nil {
+		return nil, S3Object{}, fmt.Errorf("failed to open metadata object: %w", err)
+	}
+	defer body.Close()
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, S3Object{}, fmt.Errorf("failed to read metadata object: %w", err)
+	}
+	template, err := DecodeTemplate(data)
+	if err != nil {
+		return nil, S3Object{}, fmt.Errorf("template %s: %w", name, err)
+	}
+
+	return template, object, nil
+}
+
+// List returns all templates; templates whose metadata cannot be read are reported with a *domain.CorruptEntriesError
+func (r *S3TemplateRepository) List() ([]*domain.Template, error) {
+	_, prefixes, err := r.client.ListObjects("templates/", "/")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list templates: %w", err)
+	}
+
+	var templates []*domain.Template
+	var corrupt []string
+	for _, prefix := range prefixes {
+		name := strings.TrimSuffix(strings.TrimPrefix(prefix, "templates/"), "/")
+
+		// Try to load template metadata
+		template, err := r.GetByName(name)
+		if errors.Is(err, domain.ErrTemplateNotFound) {
+			// Prefixes without a metadata object hold no template
+			continue
+		}
+		if err != nil {
+			corrupt = append(corrupt, name)
+			continue
+		}
+
+		templates = append(templates, template)
+	}
+
+	// Ensure we always return an array, even if empty
+	if templates == nil {
+		templates = []*domain.Template{}
+	}
+
+	if corrupt != nil {
+		return templates, &domain.CorruptEntriesError{Entity: "template", Names: corrupt}
+	}
+	return templates, nil
+}
+
+// ListByTag returns all templates carrying a tag
+func (r *S3TemplateRepository) ListByTag(tag string) ([]*domain.Template, error) {
+	templates, err := r.List()
+	if err != nil && domain.CorruptEntries(err) == nil {
+		return nil, err
+	}
+	// Unreadable entries may or may not match, so they are reported as well
+	return filterTemplatesByTag(templates, tag), err
+}
+
+// Delete removes a template and its images by its name
+func (r *S3TemplateRepository) Delete(name string) error {
+	return r.DeleteIf(name, nil)
+}
+
+// DeleteIf removes a template and its images by its name unless check fails. The bucket offers no locks, so
+// unlike the other backends a writer may still get in between check and the deletion.
+func (r *S3TemplateRepository) DeleteIf(name string, check func() error) error {
+	if err := domain.ValidateTemplateName(name); err != nil {
+		return err
+	}
+
+	// Check if template exists
+	if _, err := r.client.HeadObject(templateMetadataKey(name)); err != nil {
+		if errors.Is(err, errObjectNotFound) {
+			return fmt.Errorf("template with name %s: %w", name, domain.ErrTemplateNotFound)
+		}
+		return err
+	}
+	if check != nil {
+		if err := check(); err != nil {
+			return err
+		}
+	}
+
+	if err := r.client.DeletePrefix("templates/" + name + "/"); err != nil {
+		return fmt.Errorf("failed to delete template objects: %w", err)
+	}
+
+	return nil
+}
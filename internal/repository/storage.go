@@ -0,0 +1,84 @@
+package repository
+
+import (
+	"fmt"
+	"io"
+
+	"memes-generator/internal/config"
+	"memes-generator/internal/domain"
+	"memes-generator/internal/idgen"
+)
+
+// Storage bundles the repositories, usage counters, blob store and image store of one storage backend
+type Storage struct {
+	Backend   string
+	Memes     domain.MemeRepository
+	Templates domain.TemplateRepository
+	Usage     domain.UsageRepository
+	Blobs     domain.BlobStore
+	Images    domain.ImageStore
+
+	// metadata is the embedded metadata store of the bolt backend
+	metadata *BoltStore
+
+	// closer releases resources held by the backend, such as the lock on the metadata store
+	closer io.Closer
+}
+
+// Close releases the resources held by the storage backend
+func (s *Storage) Close() error {
+	if s.closer == nil {
+		return nil
+	}
+	return s.closer.Close()
+}
+
+// NewStorage creates the repositories of the storage backend selected by STORAGE_BACKEND
+func NewStorage() (*Storage, error) {
+	ids := idgen.NewULIDGenerator("meme_")
+
+	switch config.GetStorageBackend() {
+	case config.StorageBackendFile:
+		memes, templates, blobs := NewMemeFileRepository(ids), NewTemplateFileRepository(), NewFileBlobStore()
+		return &Storage{
+			Backend:   config.StorageBackendFile,
+			Memes:     memes,
+			Templates: templates,
+			Usage:     NewUsageFileRepository(),
+			Blobs:     blobs,
+			Images:    NewBlobImageStore(blobs, NewFileImageStore(), memes, templates),
+		}, nil
+	case config.StorageBackendBolt:
+		store, err := NewBoltStore()
+		if err != nil {
+			return nil, err
+		}
+		memes, templates, blobs := NewBoltMemeRepository(store, ids), NewBoltTemplateRepository(store), NewFileBlobStore()
+		return &Storage{
+			Backend:   config.StorageBackendBolt,
+			Memes:     memes,
+			Templates: templates,
+			Usage:     NewBoltUsageRepository(store),
+			Blobs:     blobs,
+			Images:    NewBlobImageStore(blobs, NewFileImageStore(), memes, templates),
+			metadata:  store,
+			closer:    store,
+		}, nil
+	case config.StorageBackendS3:
+		client, err := NewS3Client()
+		if err != nil {
+			return nil, err
+		}
+		memes, templates, blobs := NewS3MemeRepository(client, ids), NewS3TemplateRepository(client), NewS3BlobStore(client)
+		return &Storage{
+			Backend:   config.StorageBackendS3,
+			Memes:     memes,
+			Templates: templates,
+			Usage:     NewS3UsageRepository(client),
+			Blobs:     blobs,
+			Images:    NewBlobImageStore(blobs, NewS3ImageStore(client), memes, templates),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown %s %q", config.StorageBackendEnv, config.GetStorageBackend())
+	}
+}
@@ -2,8 +2,8 @@ package repository
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io/fs"
 	"os"
 	"path/filepath"
 	"strings"
@@ -18,89 +18,161 @@ const (
 
 // TemplateFileRepository implements domain.TemplateRepository using file system
 type TemplateFileRepository struct {
-	dataPath string
+	root entityRoot
 }
 
 // NewTemplateFileRepository creates a new file-based template repository
 func NewTemplateFileRepository() *TemplateFileRepository {
 	return &TemplateFileRepository{
-		dataPath: config.GetTemplatesDir(),
+		root: templateRoot(config.GetTemplatesDir()),
 	}
 }
 
-// Create saves a new template to the file system
+// Create saves a new template to the file system; a slug whose directory exists is refused with
+// domain.ErrTemplateExists
 func (r *TemplateFileRepository) Create(template *domain.Template) error {
-	// Create template directory
-	templateDir := filepath.Join(r.dataPath, template.Name)
-	if err := os.MkdirAll(templateDir, 0755); err != nil {
+	templateDir, err := r.root.path(template.Name)
+	if err != nil {
+		return err
+	}
+	unlock, err := LockEntity(templateDir)
+	if err != nil {
+		return fmt.Errorf("failed to lock template %s: %w", template.Name, err)
+	}
+	defer unlock()
+
+	// Creating the template directory claims the slug
+	if err := os.Mkdir(templateDir, 0755); err != nil {
+		if os.IsExist(err) {
+			return fmt.Errorf("template with name %s: %w", template.Name, domain.ErrTemplateExists)
+		}
 		return fmt.Errorf("failed to create template directory: %w", err)
 	}
 
-	// Save metadata
-	metadataPath := filepath.Join(templateDir, "metadata.json")
-	file, err := os.Create(metadataPath)
+	return r.writeMetadata(templateDir, template)
+}
+
+// Update overwrites the metadata of an existing template
+func (r *TemplateFileRepository) Update(template *domain.Template) error {
+	templateDir, err := r.root.path(template.Name)
+	if err != nil {
+		return err
+	}
+	unlock, err := LockEntity(templateDir)
 	if err != nil {
-		return fmt.Errorf("failed to create metadata file: %w", err)
+		return fmt.Errorf("failed to lock template %s: %w", template.Name, err)
 	}
-	defer file.Close()
+	defer unlock()
 
-	encoder := json.NewEncoder(file)
-	encoder.SetIndent("", "  ")
-	if err := encoder.Encode(template); err != nil {
+	// Check if template exists
+	if _, err := os.Stat(templateDir); os.IsNotExist(err) {
+		return fmt.Errorf("template with name %s: %w", template.Name, domain.ErrTemplateNotFound)
+	}
+
+	return r.writeMetadata(templateDir, template)
+}
+
+// Mutate applies mutate to a template while holding the template lock across the read and the write
+func (r *TemplateFileRepository) Mutate(name string, mutate func(*domain.Template) error) (*domain.Template, error) {
+	templateDir, err := r.root.path(name)
+	if err != nil {
+		return nil, err
+	}
+	unlock, err := LockEntity(templateDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock template %s: %w", name, err)
+	}
+	defer unlock()
+
+	template, err := r.GetByName(name)
+	if err != nil {
+		return nil, err
+	}
+	changed, err := applyTemplateMutation(template, mutate)
+	if err != nil || !changed {
+		return template, err
+	}
+	if template.Name != name {
+		return nil, fmt.Errorf("template %s cannot be renamed to %s", name, template.Name)
+	}
+	if err := r.writeMetadata(templateDir, template); err != nil {
+		return nil, err
+	}
+	return template, nil
+}
+
+// writeMetadata atomically replaces the metadata in a template directory; the caller holds the template lock
+func (r *TemplateFileRepository) writeMetadata(templateDir string, template *domain.Template) error {
+	template.SchemaVersion = domain.TemplateSchemaVersion
+	data, err := json.MarshalIndent(template, "", "  ")
+	if err != nil {
 		return fmt.Errorf("failed to encode template metadata: %w", err)
 	}
+	if err := writeFileAtomic(filepath.Join(templateDir, "metadata.json"), append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to save template metadata: %w", err)
+	}
 
 	return nil
 }
 
-// GetByName retrieves a template by its name
+// GetByName retrieves a template by its name.
+// Missing or undecodable metadata of an existing template directory is reported as domain.ErrCorruptMetadata.
 func (r *TemplateFileRepository) GetByName(name string) (*domain.Template, error) {
-	templateDir := filepath.Join(r.dataPath, name)
-	metadataPath := filepath.Join(templateDir, "metadata.json")
+	templateDir, err := r.root.path(name)
+	if err != nil {
+		return nil, err
+	}
 
 	// Check if template exists
 	if _, err := os.Stat(templateDir); os.IsNotExist(err) {
-		return nil, fmt.Errorf("template with name %s not found", name)
+		return nil, fmt.Errorf("template with name %s: %w", name, domain.ErrTemplateNotFound)
 	}
 
 	// Read metadata
-	file, err := os.Open(metadataPath)
+	data, err := r.root.readFile(name, "metadata.json")
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("template %s: %w: metadata.json is missing", name, domain.ErrCorruptMetadata)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to open metadata file: %w", err)
+		return nil, fmt.Errorf("failed to read metadata file: %w", err)
 	}
-	defer file.Close()
 
-	var template domain.Template
-	decoder := json.NewDecoder(file)
-	if err := decoder.Decode(&template); err != nil {
-		return nil, fmt.Errorf("failed to decode template metadata: %w", err)
+	template, err := DecodeTemplate(data)
+	if err != nil {
+		return nil, fmt.Errorf("template %s: %w", name, err)
 	}
 
-	return &template, nil
+	return template, nil
 }
 
-// List returns all templates
+// List returns all templates. Templates whose metadata cannot be read are left out and reported with a
+// *domain.CorruptEntriesError.
 func (r *TemplateFileRepository) List() ([]*domain.Template, error) {
 	// Check if data directory exists
-	if _, err := os.Stat(r.dataPath); os.IsNotExist(err) {
+	if _, err := os.Stat(r.root.dir); os.IsNotExist(err) {
 		return []*domain.Template{}, nil
 	}
 
-	entries, err := os.ReadDir(r.dataPath)
+	entries, err := os.ReadDir(r.root.dir)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read data directory: %w", err)
 	}
 
 	var templates []*domain.Template
+	var corrupt []string
 	for _, entry := range entries {
-		if !entry.IsDir() {
+		if !entry.IsDir() || isHiddenEntry(entry.Name()) {
 			continue
 		}
 
 		// Try to load template metadata
 		template, err := r.GetByName(entry.Name())
+		if errors.Is(err, domain.ErrTemplateNotFound) {
+			// Deleted since the directory was read
+			continue
+		}
 		if err != nil {
-			// Skip invalid template directories
+			corrupt = append(corrupt, entry.Name())
 			continue
 		}
 
@@ -112,106 +184,55 @@ func (r *TemplateFileRepository) List() ([]*domain.Template, error) {
 		templates = []*domain.Template{}
 	}
 
+	if corrupt != nil {
+		return templates, &domain.CorruptEntriesError{Entity: "template", Names: corrupt}
+	}
 	return templates, nil
 }
 
-// Delete removes a template by its name
-func (r *TemplateFileRepository) Delete(name string) error {
-	templateDir := filepath.Join(r.dataPath, name)
-
-	// Check if template exists
-	if _, err := os.Stat(templateDir); os.IsNotExist(err) {
-		return fmt.Errorf("template with name %s not found", name)
+// ListByTag returns all templates carrying a tag
+func (r *TemplateFileRepository) ListByTag(tag string) ([]*domain.Template, error) {
+	templates, err := r.List()
+	if err != nil && domain.CorruptEntries(err) == nil {
+		return nil, err
 	}
-
-	// Remove template directory
-	if err := os.RemoveAll(templateDir); err != nil {
-		return fmt.Errorf("failed to delete template directory: %w", err)
-	}
-
-	return nil
+	// Unreadable entries may or may not match, so they are reported as well
+	return filterTemplatesByTag(templates, tag), err
 }
 
-// SaveImage saves an image file for a template
-func (r *TemplateFileRepository) SaveImage(name string, imageData []byte, mimeType string) error {
-	templateDir := filepath.Join(r.dataPath, name)
-
-	// Create images directory
-	imagesDir := filepath.Join(templateDir, "images")
-	if err := os.MkdirAll(imagesDir, 0755); err != nil {
-		return fmt.Errorf("failed to create images directory: %w", err)
-	}
+// Delete removes a template by its name
+func (r *TemplateFileRepository) Delete(name string) error {
+	return r.DeleteIf(name, nil)
+}
 
-	// Determine file extension based on MIME type
-	var ext string
-	switch mimeType {
-	case "image/jpeg":
-		ext = ".jpg"
-	case "image/png":
-		ext = ".png"
-	default:
-		ext = ".jpg" // default to jpg
+// DeleteIf removes a template by its name unless check, run while the template is locked, fails
+func (r *TemplateFileRepository) DeleteIf(name string, check func() error) error {
+	templateDir, err := r.root.path(name)
+	if err != nil {
+		return err
 	}
-
-	// Save image file
-	imagePath := filepath.Join(imagesDir, "template"+ext)
-	if err := os.WriteFile(imagePath, imageData, 0644); err != nil {
-		return fmt.Errorf("failed to save template image: %w", err)
+	unlock, err := LockEntity(templateDir)
+	if err != nil {
+		return fmt.Errorf("failed to lock template %s: %w", name, err)
 	}
+	defer unlock()
 
-	return nil
-}
-
-// GetImage retrieves the image file for a template
-func (r *TemplateFileRepository) GetImage(name string) ([]byte, string, error) {
-	templateDir := filepath.Join(r.dataPath, name)
-	imagesDir := filepath.Join(templateDir, "images")
-
-	// Check if images directory exists
-	if _, err := os.Stat(imagesDir); os.IsNotExist(err) {
-		return nil, "", fmt.Errorf("images directory not found for template %s", name)
+	// Check if template exists
+	if _, err := os.Stat(templateDir); os.IsNotExist(err) {
+		return fmt.Errorf("template with name %s: %w", name, domain.ErrTemplateNotFound)
 	}
-
-	// Walk the directory to find the first image file
-	var imagePath string
-	var mimeType string
-	err := filepath.WalkDir(imagesDir, func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
+	if check != nil {
+		if err := check(); err != nil {
 			return err
 		}
-
-		// Skip directories
-		if d.IsDir() {
-			return nil
-		}
-
-		// Check if file is an image
-		if isImageFile(path) {
-			imagePath = path
-			ext := strings.ToLower(filepath.Ext(path))
-			switch ext {
-			case ".jpg", ".jpeg":
-				mimeType = "image/jpeg"
-			case ".png":
-				mimeType = "image/png"
-			}
-			return filepath.SkipDir // Stop walking after finding the first image
-		}
-
-		return nil
-	})
-
-	if err != nil || imagePath == "" {
-		return nil, "", fmt.Errorf("no image found for template %s", name)
 	}
 
-	// Read image file
-	imageData, err := os.ReadFile(imagePath)
-	if err != nil {
-		return nil, "", fmt.Errorf("failed to read template image: %w", err)
+	// Remove template directory
+	if err := r.root.removeAll(name); err != nil {
+		return fmt.Errorf("failed to delete template directory: %w", err)
 	}
 
-	return imageData, mimeType, nil
+	return nil
 }
 
 // isImageFile checks if a file is an image based on its extension
@@ -0,0 +1,82 @@
+package repository
+
+import (
+	"fmt"
+
+	bolt "go.etcd.io/bbolt"
+
+	"memes-generator/internal/domain"
+)
+
+// BoltUsageRepository implements domain.UsageRepository using the embedded metadata store. Counters are
+// updated inside a store transaction, which serializes concurrent creates.
+type BoltUsageRepository struct {
+	store *BoltStore
+}
+
+// NewBoltUsageRepository creates a new usage repository backed by the metadata store
+func NewBoltUsageRepository(store *BoltStore) *BoltUsageRepository {
+	return &BoltUsageRepository{
+		store: store,
+	}
+}
+
+// Record counts the creation of a meme towards the usage of its template
+func (r *BoltUsageRepository) Record(meme *domain.Meme) error {
+	if err := domain.ValidateTemplateName(meme.Template); err != nil {
+		return err
+	}
+
+	return r.store.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(templateUsageBucket)
+		usage := domain.TemplateUsage{Template: meme.Template}
+		if _, err := getDocument(bucket, meme.Template, &usage); err != nil {
+			return err
+		}
+		usage.Add(meme.CreatedAt)
+		return putDocument(bucket, meme.Template, &usage)
+	})
+}
+
+// Get returns the usage of a template
+func (r *BoltUsageRepository) Get(template string) (*domain.TemplateUsage, error) {
+	if err := domain.ValidateTemplateName(template); err != nil {
+		return nil, err
+	}
+
+	usage := domain.TemplateUsage{Template: template}
+	err := r.store.db.View(func(tx *bolt.Tx) error {
+		_, err := getDocument(tx.Bucket(templateUsageBucket), template, &usage)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("usage of template %s: %w", template, err)
+	}
+	return &usage, nil
+}
+
+// List returns the usage of every template used at least once
+func (r *BoltUsageRepository) List() ([]*domain.TemplateUsage, error) {
+	usages := []*domain.TemplateUsage{}
+	err := r.store.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(templateUsageBucket).ForEach(func(k, v []byte) error {
+			var usage domain.TemplateUsage
+			if err := decodeDocument(string(k), v, &usage); err != nil {
+				return err
+			}
+			usages = append(usages, &usage)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return usages, nil
+}
+
+// Delete removes the usage of a template; deleting a template that was never used is not an error
+func (r *BoltUsageRepository) Delete(template string) error {
+	return r.store.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(templateUsageBucket).Delete([]byte(template))
+	})
+}
@@ -0,0 +1,127 @@
+package repository
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"memes-generator/internal/config"
+	"memes-generator/internal/domain"
+)
+
+// UsageFileRepository implements domain.UsageRepository using the file system. The usage of every template
+// is kept in $DATA_DIR/usage/<name>.json and updated under the same advisory locks as memes and templates,
+// so concurrent creates in the web server and cmd/generate never lose a count.
+type UsageFileRepository struct {
+	dir string
+}
+
+// NewUsageFileRepository creates a new file-based usage repository
+func NewUsageFileRepository() *UsageFileRepository {
+	return &UsageFileRepository{
+		dir: config.GetUsageDir(),
+	}
+}
+
+// Record counts the creation of a meme towards the usage of its template
+func (r *UsageFileRepository) Record(meme *domain.Meme) error {
+	if err := domain.ValidateTemplateName(meme.Template); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(r.dir, 0755); err != nil {
+		return fmt.Errorf("failed to create usage directory: %w", err)
+	}
+	unlock, err := LockEntity(filepath.Join(r.dir, meme.Template))
+	if err != nil {
+		return fmt.Errorf("failed to lock usage of template %s: %w", meme.Template, err)
+	}
+	defer unlock()
+
+	usage, err := r.Get(meme.Template)
+	if err != nil {
+		return err
+	}
+	usage.Add(meme.CreatedAt)
+
+	data, err := json.MarshalIndent(usage, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode template usage: %w", err)
+	}
+	if err := writeFileAtomic(r.path(meme.Template), append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to save template usage: %w", err)
+	}
+	return nil
+}
+
+// Get returns the usage of a template
+func (r *UsageFileRepository) Get(template string) (*domain.TemplateUsage, error) {
+	if err := domain.ValidateTemplateName(template); err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(r.path(template))
+	if os.IsNotExist(err) {
+		return &domain.TemplateUsage{Template: template}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read template usage: %w", err)
+	}
+
+	var usage domain.TemplateUsage
+	if err := json.Unmarshal(data, &usage); err != nil {
+		return nil, fmt.Errorf("usage of template %s: %w: %v", template, domain.ErrCorruptMetadata, err)
+	}
+	usage.Template = template
+	return &usage, nil
+}
+
+// List returns the usage of every template used at least once. Unreadable counters are left out and logged.
+func (r *UsageFileRepository) List() ([]*domain.TemplateUsage, error) {
+	entries, err := os.ReadDir(r.dir)
+	if os.IsNotExist(err) {
+		return []*domain.TemplateUsage{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read usage directory: %w", err)
+	}
+
+	usages := []*domain.TemplateUsage{}
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".json")
+		if entry.IsDir() || isHiddenEntry(entry.Name()) || !ok {
+			continue
+		}
+		usage, err := r.Get(name)
+		if err != nil {
+			log.Printf("Skipping usage of template %s in listing: %v", name, err)
+			continue
+		}
+		usages = append(usages, usage)
+	}
+	return usages, nil
+}
+
+// Delete removes the usage of a template; deleting a template that was never used is not an error
+func (r *UsageFileRepository) Delete(template string) error {
+	if err := domain.ValidateTemplateName(template); err != nil {
+		return err
+	}
+	unlock, err := LockEntity(filepath.Join(r.dir, template))
+	if err != nil {
+		return fmt.Errorf("failed to lock usage of template %s: %w", template, err)
+	}
+	defer unlock()
+
+	if err := os.Remove(r.path(template)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete template usage: %w", err)
+	}
+	return nil
+}
+
+// path returns the file holding the usage of a template
+func (r *UsageFileRepository) path(template string) string {
+	return filepath.Join(r.dir, template+".json")
+}
@@ -0,0 +1,107 @@
+package repository
+
+import (
+	"fmt"
+	"strings"
+
+	"memes-generator/internal/domain"
+)
+
+// S3UsageRepository implements domain.UsageRepository using S3-compatible object storage. Object storage
+// offers no atomic update, so every use is an empty object usage/<name>/<day>/<meme ID> and the counters are
+// obtained by listing them: replicas creating memes concurrently never overwrite each other's counts.
+type S3UsageRepository struct {
+	client *S3Client
+}
+
+// NewS3UsageRepository creates a new object storage usage repository
+func NewS3UsageRepository(client *S3Client) *S3UsageRepository {
+	return &S3UsageRepository{
+		client: client,
+	}
+}
+
+// Record counts the creation of a meme towards the usage of its template
+func (r *S3UsageRepository) Record(meme *domain.Meme) error {
+	if err := domain.ValidateTemplateName(meme.Template); err != nil {
+		return err
+	}
+	if err := domain.ValidateMemeID(meme.ID); err != nil {
+		return err
+	}
+
+	key := "usage/" + meme.Template + "/" + meme.CreatedAt.UTC().Format(domain.UsageDayFormat) + "/" + meme.ID
+	if err := r.client.PutObject(key, nil, "application/octet-stream"); err != nil {
+		return fmt.Errorf("failed to record template usage: %w", err)
+	}
+	return nil
+}
+
+// Get returns the usage of a template
+func (r *S3UsageRepository) Get(template string) (*domain.TemplateUsage, error) {
+	if err := domain.ValidateTemplateName(template); err != nil {
+		return nil, err
+	}
+
+	usages, err := r.list("usage/" + template + "/")
+	if err != nil {
+		return nil, err
+	}
+	if usage, ok := usages[template]; ok {
+		return usage, nil
+	}
+	return &domain.TemplateUsage{Template: template}, nil
+}
+
+// List returns the usage of every template used at least once
+func (r *S3UsageRepository) List() ([]*domain.TemplateUsage, error) {
+	usages, err := r.list("usage/")
+	if err != nil {
+		return nil, err
+	}
+
+	list := []*domain.TemplateUsage{}
+	for _, name := range sortedKeys(usages) {
+		list = append(list, usages[name])
+	}
+	return list, nil
+}
+
+// list sums up the usage objects under prefix by template. The last use is the newest upload, as objects
+// are written when their meme is created.
+func (r *S3UsageRepository) list(prefix string) (map[string]*domain.TemplateUsage, error) {
+	objects, _, err := r.client.ListObjects(prefix, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list template usage: %w", err)
+	}
+
+	usages := make(map[string]*domain.TemplateUsage)
+	for _, object := range objects {
+		parts := strings.Split(strings.TrimPrefix(object.Key, "usage/"), "/")
+		if len(parts) != 3 {
+			continue
+		}
+		usage, ok := usages[parts[0]]
+		if !ok {
+			usage = &domain.TemplateUsage{Template: parts[0], Days: make(map[string]int64)}
+			usages[parts[0]] = usage
+		}
+		usage.Days[parts[1]]++
+		usage.Count++
+		if object.LastModified.After(usage.LastUsedAt) {
+			usage.LastUsedAt = object.LastModified
+		}
+	}
+	return usages, nil
+}
+
+// Delete removes the usage of a template; deleting a template that was never used is not an error
+func (r *S3UsageRepository) Delete(template string) error {
+	if err := domain.ValidateTemplateName(template); err != nil {
+		return err
+	}
+	if err := r.client.DeletePrefix("usage/" + template + "/"); err != nil {
+		return fmt.Errorf("failed to delete template usage: %w", err)
+	}
+	return nil
+}
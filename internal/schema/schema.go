@@ -0,0 +1,92 @@
+package schema
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ErrUnsupportedVersion is returned for documents written by a newer schema than this build understands
+var ErrUnsupportedVersion = errors.New("unsupported schema version")
+
+// VersionField is the JSON field holding the schema version of a document.
+// Documents written before versioning was introduced have no such field and count as version 0.
+const VersionField = "schema_version"
+
+// Migration upgrades a decoded JSON document from one schema version to the next in place
+type Migration func(doc map[string]any) error
+
+// Registry holds the migrations of one kind of document, each upgrading version N to N+1
+type Registry struct {
+	kind       string
+	current    int
+	migrations map[int]Migration
+}
+
+// NewRegistry creates an empty migration registry for documents whose current schema version is current
+func NewRegistry(kind string, current int) *Registry {
+	return &Registry{
+		kind:       kind,
+		current:    current,
+		migrations: make(map[int]Migration),
+	}
+}
+
+// Register adds the migration upgrading documents from version from to from+1
+func (r *Registry) Register(from int, migration Migration) {
+	if from < 0 || from >= r.current {
+		panic(fmt.Sprintf("schema: %s migration from version %d is outside 0..%d", r.kind, from, r.current-1))
+	}
+	if _, ok := r.migrations[from]; ok {
+		panic(fmt.Sprintf("schema: duplicate %s migration from version %d", r.kind, from))
+	}
+	r.migrations[from] = migration
+}
+
+// Current returns the schema version written by this build
+func (r *Registry) Current() int {
+	return r.current
+}
+
+// Version returns the schema version a JSON document was written with
+func Version(data []byte) (int, error) {
+	var header struct {
+		SchemaVersion int `json:"schema_version"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return 0, err
+	}
+	return header.SchemaVersion, nil
+}
+
+// Migrate upgrades a JSON document to the current schema by applying every migration from its version on.
+// The schema_version field of the result still holds the version the document was stored with, so callers
+// can tell upgraded documents apart until they are written back. Current documents are returned unchanged.
+func (r *Registry) Migrate(data []byte) ([]byte, error) {
+	version, err := Version(data)
+	if err != nil {
+		return nil, err
+	}
+	if version > r.current {
+		return nil, fmt.Errorf("%w: %s schema version %d is newer than %d", ErrUnsupportedVersion, r.kind, version, r.current)
+	}
+	if version == r.current {
+		return data, nil
+	}
+
+	var doc map[string]any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	for v := version; v < r.current; v++ {
+		migration, ok := r.migrations[v]
+		if !ok {
+			return nil, fmt.Errorf("no %s migration from schema version %d", r.kind, v)
+		}
+		if err := migration(doc); err != nil {
+			return nil, fmt.Errorf("failed to migrate %s from schema version %d: %w", r.kind, v, err)
+		}
+	}
+
+	return json.Marshal(doc)
+}
@@ -229,8 +229,8 @@ func (s *CloudRuJobService) GetJob(jobName string) (*Job, error) {
 	return &job, nil
 }
 
-// ExecuteJob executes a job with the specified parameters
-func (s *CloudRuJobService) ExecuteJob(jobName, memePath string) error {
+// ExecuteJob executes a job running generate-meme with the given arguments
+func (s *CloudRuJobService) ExecuteJob(jobName string, args []string) error {
 	// First get the job to retrieve its current configuration
 	job, err := s.GetJob(jobName)
 	if err != nil {
@@ -242,8 +242,8 @@ func (s *CloudRuJobService) ExecuteJob(jobName, memePath string) error {
 		// Set the command to "generate-meme"
 		job.Template.Containers[0].Command = []string{"generate-meme"}
 
-		// Set the args to include the meme path
-		job.Template.Containers[0].Args = []string{"--meme-path", memePath}
+		// Set the args identifying the meme to generate
+		job.Template.Containers[0].Args = args
 	}
 
 	// Prepare the patch request
@@ -0,0 +1,37 @@
+package usecase
+
+import (
+	"fmt"
+	"io"
+
+	"memes-generator/internal/domain"
+)
+
+// BackupUsecase implements backup and restore business logic
+type BackupUsecase struct {
+	backupRepo domain.BackupRepository
+}
+
+// NewBackupUsecase creates a new backup usecase
+func NewBackupUsecase(backupRepo domain.BackupRepository) *BackupUsecase {
+	return &BackupUsecase{
+		backupRepo: backupRepo,
+	}
+}
+
+// WriteBackup streams an archive of all memes and templates to w
+func (uc *BackupUsecase) WriteBackup(w io.Writer) (*domain.BackupManifest, error) {
+	return uc.backupRepo.WriteBackup(w)
+}
+
+// Restore restores a backup archive in merge or replace mode; an empty mode merges
+func (uc *BackupUsecase) Restore(r io.Reader, mode string) (*domain.RestoreReport, error) {
+	if mode == "" {
+		mode = domain.RestoreMerge
+	}
+	if mode != domain.RestoreMerge && mode != domain.RestoreReplace {
+		return nil, fmt.Errorf("unknown restore mode %q, expected %s or %s", mode, domain.RestoreMerge, domain.RestoreReplace)
+	}
+
+	return uc.backupRepo.Restore(r, mode)
+}
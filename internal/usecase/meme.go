@@ -1,47 +1,173 @@
 package usecase
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	"image/png"
+	"io"
 	"log"
 	"path/filepath"
 	"time"
 
 	"memes-generator/internal/config"
 	"memes-generator/internal/domain"
+	"memes-generator/internal/meme"
 	"memes-generator/internal/service"
+	"memes-generator/internal/watermark"
 )
 
+// generatedImageName is the file name of the image rendered for a meme
+const generatedImageName = "generated_meme.png"
+
 // MemeUsecase implements domain.MemeUsecase
 type MemeUsecase struct {
-	memeRepo domain.MemeRepository
+	memeRepo     domain.MemeRepository
+	templateRepo domain.TemplateRepository
+	usageRepo    domain.UsageRepository
+	imageStore   domain.ImageStore
+	renderer     *meme.Renderer
 }
 
 // NewMemeUsecase creates a new meme usecase
-func NewMemeUsecase(memeRepo domain.MemeRepository) *MemeUsecase {
+func NewMemeUsecase(memeRepo domain.MemeRepository, templateRepo domain.TemplateRepository, usageRepo domain.UsageRepository, imageStore domain.ImageStore, renderer *meme.Renderer) *MemeUsecase {
 	return &MemeUsecase{
-		memeRepo: memeRepo,
+		memeRepo:     memeRepo,
+		templateRepo: templateRepo,
+		usageRepo:    usageRepo,
+		imageStore:   imageStore,
+		renderer:     renderer,
+	}
+}
+
+// resolveMemeTemplate resolves a template slug, alias or display name to the template's slug, returning the
+// template as well when it exists. Unknown but valid names are kept: memes of templates without metadata
+// render on the default background.
+func (uc *MemeUsecase) resolveMemeTemplate(name string) (string, *domain.Template, error) {
+	template, err := resolveTemplate(uc.templateRepo, name)
+	if errors.Is(err, domain.ErrTemplateNotFound) {
+		return name, nil, nil
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	return template.Name, template, nil
+}
+
+// resolveMemeVariant looks up the variant of a template a meme asks for. Memes of unknown templates can
+// only use the template image itself.
+func resolveMemeVariant(template *domain.Template, name, variant string) (*domain.TemplateVariant, error) {
+	if template == nil {
+		if variant != "" && variant != domain.OriginalVariant {
+			return nil, fmt.Errorf("template %s variant %s: %w", name, variant, domain.ErrVariantNotFound)
+		}
+		return nil, nil
+	}
+	return template.ResolveVariant(variant)
+}
+
+// snapshotTemplate freezes the current image and caption options of a template, or of one of its variants,
+// for a new meme, so later changes to the template never alter the meme. An image kept as a blob is shared
+// with the template as it is; older images are copied into a blob first. Unknown templates and templates
+// without an image yield no snapshot; their memes render on the default background.
+func (uc *MemeUsecase) snapshotTemplate(name string, template *domain.Template, variant *domain.TemplateVariant) (*domain.TemplateSnapshot, error) {
+	if template == nil {
+		return nil, nil
+	}
+	var snapshot *domain.TemplateSnapshot
+	if variant != nil {
+		snapshot = &domain.TemplateSnapshot{Image: variant.Image.Blob, ContentType: variant.Image.ContentType}
+	} else if template.Image != nil {
+		snapshot = &domain.TemplateSnapshot{Image: template.Image.Blob, ContentType: template.Image.ContentType}
+	} else {
+		src, info, err := uc.imageStore.OpenTemplateImage(name)
+		if errors.Is(err, domain.ErrImageNotFound) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(src)
+		src.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read template image: %w", err)
+		}
+		hash, err := uc.imageStore.SaveSnapshot(data)
+		if err != nil {
+			return nil, err
+		}
+		snapshot = &domain.TemplateSnapshot{Image: hash, ContentType: info.ContentType}
 	}
+
+	snapshot.Version = template.Version
+	layout, style := template.VariantCaptionOptions(variant)
+	if !layout.IsZero() {
+		snapshot.Layout = &layout
+	}
+	if !style.IsZero() {
+		snapshot.Style = &style
+	}
+	return snapshot, nil
 }
 
-// CreateMeme creates a new meme
-func (uc *MemeUsecase) CreateMeme(template, textTop, textBottom string) (*domain.Meme, error) {
+// CreateMeme creates a new meme from a variant of a template; an empty variant picks the default one
+func (uc *MemeUsecase) CreateMeme(templateName, variantName, textTop, textBottom string, internal bool) (*domain.Meme, error) {
+	name, template, err := uc.resolveMemeTemplate(templateName)
+	if err != nil {
+		return nil, err
+	}
+
 	meme := &domain.Meme{
-		Template:   template,
+		Template:   name,
 		TextTop:    textTop,
 		TextBottom: textBottom,
+		Internal:   internal,
 		CreatedAt:  time.Now(),
 		UpdatedAt:  time.Now(),
 	}
+	store := func(template *domain.Template) error {
+		variant, err := resolveMemeVariant(template, name, variantName)
+		if err != nil {
+			return err
+		}
+		if meme.TemplateSnapshot, err = uc.snapshotTemplate(name, template, variant); err != nil {
+			return fmt.Errorf("failed to snapshot template %s: %w", name, err)
+		}
+		if variant != nil {
+			meme.Variant = variant.Name
+		}
+		return uc.memeRepo.Create(meme)
+	}
 
-	if err := uc.memeRepo.Create(meme); err != nil {
+	if template == nil {
+		err = store(nil)
+	} else {
+		// The meme is stored while the template is locked, so deleting the template either sees the meme or
+		// happens before it and makes the create fail
+		_, err = uc.templateRepo.Mutate(name, func(template *domain.Template) error {
+			return store(template)
+		})
+	}
+	if err != nil {
 		return nil, err
 	}
 
+	// Usage is counted for existing templates only, so a template created later under the name of an
+	// unknown one starts from zero. The meme is saved either way, so a failed count is only logged.
+	if template != nil {
+		if err := uc.usageRepo.Record(meme); err != nil {
+			log.Printf("Failed to record usage of template %s: %v", name, err)
+		}
+	}
+
 	// Handle different generation modes based on environment variable
 	if config.IsBackgroundMode() {
 		// Generate meme in background using goroutine
 		go func() {
-			imagesDir := filepath.Join(config.GetMemesDir(), meme.ID, "images")
-			if err := meme.CreateMemeImage(textTop, textBottom, filepath.Join(imagesDir, "generated_meme.png")); err != nil {
+			if err := uc.renderMemeImage(meme); err != nil {
 				log.Printf("Failed to generate meme in background: %v", err)
 			}
 		}()
@@ -49,15 +175,13 @@ func (uc *MemeUsecase) CreateMeme(template, textTop, textBottom string) (*domain
 		// Generate meme using Cloud.ru Container App Job
 		go func() {
 			jobService := service.NewCloudRuJobService()
-			memePath := filepath.Join(config.GetMemesDir(), meme.ID)
-			if err := jobService.ExecuteJob(config.GetContainerJobName(), memePath); err != nil {
+			if err := jobService.ExecuteJob(config.GetContainerJobName(), generateJobArgs(meme.ID)); err != nil {
 				log.Printf("Failed to execute Container App Job: %v", err)
 			}
 		}()
 	} else {
 		// Default behavior - generate meme synchronously
-		imagesDir := filepath.Join(config.GetMemesDir(), meme.ID, "images")
-		if err := meme.CreateMemeImage(textTop, textBottom, filepath.Join(imagesDir, "generated_meme.png")); err != nil {
+		if err := uc.renderMemeImage(meme); err != nil {
 			// If image generation fails, we still return the meme but log the error
 			// In a production environment, you might want to handle this differently
 			return meme, nil
@@ -67,17 +191,129 @@ func (uc *MemeUsecase) CreateMeme(template, textTop, textBottom string) (*domain
 	return meme, nil
 }
 
+// generateJobArgs returns the generate-meme arguments for a meme. With file storage the job keeps
+// receiving the meme directory on the shared volume; other backends look the meme up by ID.
+func generateJobArgs(id string) []string {
+	if config.GetStorageBackend() != config.StorageBackendFile {
+		return []string{"--meme-id", id}
+	}
+	return []string{"--meme-path", filepath.Join(config.GetMemesDir(), id)}
+}
+
+// renderMemeImage renders the image of a meme and stores it in the image store
+func (uc *MemeUsecase) renderMemeImage(m *domain.Meme) error {
+	// Memes of templates without metadata render with the default caption options
+	template, _ := uc.templateRepo.GetByName(m.Template)
+
+	var buf bytes.Buffer
+	if _, err := m.RenderImage(context.Background(), uc.renderer, template, config.GetServerURL(), &buf); err != nil {
+		return err
+	}
+	return uc.imageStore.SaveMemeImage(m.ID, generatedImageName, buf.Bytes())
+}
+
 // GetMemeByID retrieves a meme by its ID
 func (uc *MemeUsecase) GetMemeByID(id string) (*domain.Meme, error) {
+	if err := domain.ValidateMemeID(id); err != nil {
+		return nil, err
+	}
 	return uc.memeRepo.GetByID(id)
 }
 
-// ListMemes returns all memes
+// ListMemes returns all memes. Memes whose metadata cannot be read are reported with a
+// *domain.CorruptEntriesError together with the others.
 func (uc *MemeUsecase) ListMemes() ([]*domain.Meme, error) {
 	return uc.memeRepo.List()
 }
 
 // DeleteMeme removes a meme by its ID
 func (uc *MemeUsecase) DeleteMeme(id string) error {
+	if err := domain.ValidateMemeID(id); err != nil {
+		return err
+	}
 	return uc.memeRepo.Delete(id)
 }
+
+// PreviewMeme renders a meme from a variant of a template without saving it, streaming the encoded image to w.
+// An empty variant picks the default one. Unknown templates fall back to the default background, like saved
+// memes do.
+func (uc *MemeUsecase) PreviewMeme(ctx context.Context, templateName, variantName, textTop, textBottom, format string, w io.Writer) (meme.RenderResult, error) {
+	name, template, err := uc.resolveMemeTemplate(templateName)
+	if err != nil {
+		return meme.RenderResult{}, err
+	}
+	variant, err := resolveMemeVariant(template, name, variantName)
+	if err != nil {
+		return meme.RenderResult{}, err
+	}
+
+	var spec meme.RenderSpec
+	if variant != nil {
+		spec = uc.renderer.SnapshotSpec(variant.Image.Blob)
+	} else if spec, err = uc.renderer.TemplateSpec(name); err != nil {
+		spec = meme.RenderSpec{}
+	}
+
+	spec.TextTop = textTop
+	spec.TextBottom = textBottom
+	spec.Layout, spec.Style = template.VariantCaptionOptions(variant)
+	spec.Format = format
+	return meme.Render(ctx, spec, w)
+}
+
+// InspectMemeImage reads the provenance metadata embedded into a generated meme file
+func (uc *MemeUsecase) InspectMemeImage(image io.Reader) (*meme.Provenance, error) {
+	return meme.ReadProvenance(image)
+}
+
+// OpenMemeImage opens the stored image of a meme
+func (uc *MemeUsecase) OpenMemeImage(id string) (io.ReadCloser, meme.ImageInfo, error) {
+	if err := domain.ValidateMemeID(id); err != nil {
+		return nil, meme.ImageInfo{}, err
+	}
+	return uc.imageStore.OpenMemeImage(id)
+}
+
+// WatermarkMemeImage embeds an invisible watermark identifying the meme and its requester into a meme image.
+// The result is PNG encoded and keeps the meme's provenance metadata.
+func (uc *MemeUsecase) WatermarkMemeImage(m *domain.Meme, requesterID string, src io.Reader) ([]byte, error) {
+	if !config.IsWatermarkEnabled() {
+		return nil, domain.ErrWatermarkDisabled
+	}
+	if requesterID == "" {
+		return nil, domain.ErrRequesterRequired
+	}
+
+	img, _, err := image.Decode(src)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode meme image: %w", err)
+	}
+
+	payload, err := watermark.Payload{MemeID: m.ID, RequesterID: requesterID}.Encode()
+	if err != nil {
+		return nil, err
+	}
+	// The watermark only carries a hash of the requester ID; the log maps it back to the requester
+	log.Printf("Watermarking meme %s for requester %s (requester hash %s)", m.ID, requesterID, watermark.RequesterHash(requesterID))
+	marked, err := watermark.Embed(img, payload, config.GetWatermarkKey())
+	if err != nil {
+		return nil, fmt.Errorf("failed to embed watermark: %w", err)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, marked); err != nil {
+		return nil, fmt.Errorf("failed to encode watermarked image: %w", err)
+	}
+
+	return meme.EmbedProvenance(buf.Bytes(), m.Provenance(config.GetServerURL()))
+}
+
+// GetRenderCacheStats returns the hit/miss counters and sizes of the render caches
+func (uc *MemeUsecase) GetRenderCacheStats() meme.CacheStats {
+	return meme.GetCacheStats()
+}
+
+// PurgeRenderCache empties the decoded template cache and the on-disk render cache
+func (uc *MemeUsecase) PurgeRenderCache() error {
+	return meme.PurgeCache()
+}
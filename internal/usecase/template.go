@@ -1,75 +1,707 @@
 package usecase
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"reflect"
+	"sort"
+	"strings"
 	"time"
+	"unicode/utf8"
 
+	"memes-generator/internal/config"
 	"memes-generator/internal/domain"
-	"memes-generator/internal/repository"
+	"memes-generator/internal/imagehash"
+	"memes-generator/internal/meme"
+)
+
+const (
+	// defaultIdentifyLimit is the number of closest templates returned by IdentifyTemplate when no limit is given
+	defaultIdentifyLimit = 5
+
+	// duplicateThreshold is the highest hash distance at which two template images count as the same picture
+	duplicateThreshold = 6.0
+
+	// maxDescriptionLength is the longest template description in characters
+	maxDescriptionLength = 1000
 )
 
 // TemplateUsecase implements template business logic
 type TemplateUsecase struct {
 	templateRepo domain.TemplateRepository
+	memeRepo     domain.MemeRepository
+	usageRepo    domain.UsageRepository
+	imageStore   domain.ImageStore
 }
 
 // NewTemplateUsecase creates a new template usecase
-func NewTemplateUsecase(templateRepo domain.TemplateRepository) *TemplateUsecase {
+func NewTemplateUsecase(templateRepo domain.TemplateRepository, memeRepo domain.MemeRepository, usageRepo domain.UsageRepository, imageStore domain.ImageStore) *TemplateUsecase {
 	return &TemplateUsecase{
 		templateRepo: templateRepo,
+		memeRepo:     memeRepo,
+		usageRepo:    usageRepo,
+		imageStore:   imageStore,
 	}
 }
 
-// CreateTemplate creates a new template
-func (uc *TemplateUsecase) CreateTemplate(name string) (*domain.Template, error) {
-	template := &domain.Template{
-		Name:      name,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+// CreateTemplate creates a new template with a display name, optional aliases and descriptive details.
+// The template is stored under a slug generated from the display name, suffixed with -2, -3, ...
+// when another template already uses it; aliases must not name another template.
+func (uc *TemplateUsecase) CreateTemplate(displayName string, aliases []string, details domain.TemplateDetails) (*domain.Template, error) {
+	if err := domain.ValidateDisplayName(displayName); err != nil {
+		return nil, err
+	}
+	for _, alias := range aliases {
+		if err := domain.ValidateDisplayName(alias); err != nil {
+			return nil, err
+		}
+	}
+	description, err := normalizeDescription(details.Description)
+	if err != nil {
+		return nil, err
+	}
+	category, err := normalizeCategory(details.Category)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := domain.NormalizeTags(details.Tags)
+	if err != nil {
+		return nil, err
 	}
 
-	if err := uc.templateRepo.Create(template); err != nil {
+	templates, err := readableTemplates(uc.templateRepo.List())
+	if err != nil {
 		return nil, err
 	}
+	owners := aliasOwners(templates)
 
-	return template, nil
+	template := &domain.Template{
+		DisplayName: strings.TrimSpace(displayName),
+		Description: description,
+		Category:    category,
+		Tags:        tags,
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+	seen := make(map[string]bool)
+	for _, alias := range aliases {
+		key := domain.AliasKey(alias)
+		if seen[key] {
+			continue
+		}
+		if owner, ok := owners[key]; ok {
+			return nil, fmt.Errorf("%w: %q belongs to template %s", domain.ErrAliasTaken, alias, owner)
+		}
+		seen[key] = true
+		template.Aliases = append(template.Aliases, strings.TrimSpace(alias))
+	}
+
+	// Create refuses taken slugs, so a template created concurrently under the same slug moves this one on
+	// to the next suffix; slugs skipped by the listing, e.g. with corrupt metadata, are refused as well
+	base := domain.Slugify(displayName)
+	for n := 1; ; n++ {
+		slug := domain.SlugCandidate(base, n)
+		if _, ok := owners[slug]; ok || seen[slug] || domain.IsReservedSlug(slug) {
+			continue
+		}
+		template.Name = slug
+		err := uc.templateRepo.Create(template)
+		if errors.Is(err, domain.ErrTemplateExists) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return template, nil
+	}
 }
 
-// GetTemplateByName retrieves a template by its name
+// aliasOwners maps the lookup key of every slug and alias to the slug of the template it belongs to
+func aliasOwners(templates []*domain.Template) map[string]string {
+	owners := make(map[string]string)
+	for _, template := range templates {
+		owners[domain.AliasKey(template.Name)] = template.Name
+		for _, alias := range template.Aliases {
+			owners[domain.AliasKey(alias)] = template.Name
+		}
+	}
+	return owners
+}
+
+// normalizeDescription checks the length of a template description and trims surrounding whitespace
+func normalizeDescription(description string) (string, error) {
+	if utf8.RuneCountInString(description) > maxDescriptionLength {
+		return "", fmt.Errorf("%w: description is longer than %d characters", domain.ErrInvalidTemplate, maxDescriptionLength)
+	}
+	return strings.TrimSpace(description), nil
+}
+
+// normalizeCategory normalizes a template category; an empty category leaves the template uncategorized
+func normalizeCategory(category string) (string, error) {
+	if strings.TrimSpace(category) == "" {
+		return "", nil
+	}
+	return domain.NormalizeLabel(category)
+}
+
+// GetTemplateByName retrieves a template by its slug
 func (uc *TemplateUsecase) GetTemplateByName(name string) (*domain.Template, error) {
+	if err := domain.ValidateTemplateName(name); err != nil {
+		return nil, err
+	}
 	return uc.templateRepo.GetByName(name)
 }
 
-// ListTemplates returns all templates
-func (uc *TemplateUsecase) ListTemplates() ([]*domain.Template, error) {
-	return uc.templateRepo.List()
+// ResolveTemplate retrieves a template by its slug, one of its aliases or its display name.
+// Callers compare the returned template's Name with the requested name to redirect to the canonical slug.
+func (uc *TemplateUsecase) ResolveTemplate(name string) (*domain.Template, error) {
+	return resolveTemplate(uc.templateRepo, name)
+}
+
+// resolveTemplate looks a template up by slug first, then by alias, which is unique, and finally by display
+// name, which only resolves when a single template carries it. Names that are neither a valid slug nor a
+// known alias fail with the validation error.
+func resolveTemplate(repo domain.TemplateRepository, name string) (*domain.Template, error) {
+	nameErr := domain.ValidateTemplateName(name)
+	if nameErr == nil {
+		template, err := repo.GetByName(name)
+		if err == nil {
+			return template, nil
+		}
+		if !errors.Is(err, domain.ErrTemplateNotFound) {
+			return nil, err
+		}
+	}
+
+	if key := domain.AliasKey(name); key != "" {
+		templates, err := readableTemplates(repo.List())
+		if err != nil {
+			return nil, err
+		}
+		var byDisplayName []*domain.Template
+		for _, template := range templates {
+			for _, alias := range template.Aliases {
+				if domain.AliasKey(alias) == key {
+					return template, nil
+				}
+			}
+			if domain.AliasKey(template.DisplayName) == key || domain.AliasKey(template.Name) == key {
+				byDisplayName = append(byDisplayName, template)
+			}
+		}
+		if len(byDisplayName) == 1 {
+			return byDisplayName[0], nil
+		}
+	}
+
+	if nameErr != nil {
+		return nil, nameErr
+	}
+	return nil, fmt.Errorf("template %s: %w", name, domain.ErrTemplateNotFound)
+}
+
+// UpdateTemplate changes the display name, description, category, tags, caption layout, default caption
+// style or default variant of a template. A replaced display name becomes an alias, so lookups by the old
+// name keep resolving to the template. Changed caption options and default variants are recorded as a new
+// version by the author.
+func (uc *TemplateUsecase) UpdateTemplate(name string, patch domain.TemplatePatch, author string) (*domain.Template, error) {
+	if err := domain.ValidateTemplateName(name); err != nil {
+		return nil, err
+	}
+	if patch.DisplayName != nil {
+		if err := domain.ValidateDisplayName(*patch.DisplayName); err != nil {
+			return nil, err
+		}
+	}
+	var description, category string
+	var tags []string
+	var err error
+	if patch.Description != nil {
+		if description, err = normalizeDescription(*patch.Description); err != nil {
+			return nil, err
+		}
+	}
+	if patch.Category != nil {
+		if category, err = normalizeCategory(*patch.Category); err != nil {
+			return nil, err
+		}
+	}
+	if patch.Tags != nil {
+		if tags, err = domain.NormalizeTags(*patch.Tags); err != nil {
+			return nil, err
+		}
+	}
+	if patch.Layout != nil {
+		if err := patch.Layout.Validate(); err != nil {
+			return nil, fmt.Errorf("%w: %v", domain.ErrInvalidTemplate, err)
+		}
+	}
+	if patch.DefaultStyle != nil {
+		if err := patch.DefaultStyle.Validate(); err != nil {
+			return nil, fmt.Errorf("%w: %v", domain.ErrInvalidTemplate, err)
+		}
+	}
+	var defaultVariant string
+	if patch.DefaultVariant != nil && *patch.DefaultVariant != "" && *patch.DefaultVariant != domain.OriginalVariant {
+		defaultVariant = *patch.DefaultVariant
+		if err := domain.ValidateVariantName(defaultVariant); err != nil {
+			return nil, err
+		}
+	}
+
+	return uc.templateRepo.Mutate(name, func(template *domain.Template) error {
+		before := versionState(template)
+
+		if patch.DisplayName != nil {
+			displayName := strings.TrimSpace(*patch.DisplayName)
+			if previous := template.DisplayName; previous != "" && domain.AliasKey(previous) != domain.AliasKey(displayName) {
+				if err := uc.keepAsAlias(template, previous); err != nil {
+					return err
+				}
+			}
+			template.DisplayName = displayName
+		}
+		if patch.Description != nil {
+			template.Description = description
+		}
+		if patch.Category != nil {
+			template.Category = category
+		}
+		if patch.Tags != nil {
+			template.Tags = tags
+		}
+		if patch.Layout != nil {
+			template.Layout = nil
+			if !patch.Layout.IsZero() {
+				layout := *patch.Layout
+				template.Layout = &layout
+			}
+		}
+		if patch.DefaultStyle != nil {
+			template.DefaultStyle = nil
+			if !patch.DefaultStyle.IsZero() {
+				style := *patch.DefaultStyle
+				template.DefaultStyle = &style
+			}
+		}
+		if patch.DefaultVariant != nil {
+			if _, ok := template.FindVariant(defaultVariant); defaultVariant != "" && !ok {
+				return fmt.Errorf("template %s variant %s: %w", template.Name, defaultVariant, domain.ErrVariantNotFound)
+			}
+			template.DefaultVariant = defaultVariant
+		}
+
+		template.UpdatedAt = time.Now()
+		if !sameVersionState(before, versionState(template)) {
+			change := domain.TemplateChangeLayout
+			if reflect.DeepEqual(before.Layout, template.Layout) && reflect.DeepEqual(before.DefaultStyle, template.DefaultStyle) {
+				change = domain.TemplateChangeVariant
+			}
+			recordVersion(template, before, change, author)
+		}
+		return nil
+	})
 }
 
-// DeleteTemplate removes a template by its name
-func (uc *TemplateUsecase) DeleteTemplate(name string) error {
-	return uc.templateRepo.Delete(name)
+// keepAsAlias adds a former display name to the aliases of a template unless it already resolves to it
+// or belongs to another template
+func (uc *TemplateUsecase) keepAsAlias(template *domain.Template, name string) error {
+	key := domain.AliasKey(name)
+	if key == domain.AliasKey(template.Name) {
+		return nil
+	}
+	for _, alias := range template.Aliases {
+		if domain.AliasKey(alias) == key {
+			return nil
+		}
+	}
+
+	templates, err := readableTemplates(uc.templateRepo.List())
+	if err != nil {
+		return err
+	}
+	if owner, ok := aliasOwners(templates)[key]; ok && owner != template.Name {
+		return nil
+	}
+
+	template.Aliases = append(template.Aliases, name)
+	return nil
+}
+
+// DeleteTemplate removes a template by its slug. The policy decides what happens to memes that still use the
+// template; an empty policy falls back to TEMPLATE_DELETE_POLICY. It returns the number of memes that used it.
+func (uc *TemplateUsecase) DeleteTemplate(name string, policy string) (int, error) {
+	if err := domain.ValidateTemplateName(name); err != nil {
+		return 0, err
+	}
+	if policy == "" {
+		policy = config.GetTemplateDeletePolicy()
+	}
+	deletePolicy, err := domain.ParseTemplateDeletePolicy(policy)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := uc.templateRepo.GetByName(name); err != nil && !errors.Is(err, domain.ErrCorruptMetadata) {
+		return 0, err
+	}
+
+	// The memes are listed and handled with the template locked, so no meme is created for it in the meantime
+	var memes []*domain.Meme
+	err = uc.templateRepo.DeleteIf(name, func() error {
+		var err error
+		if memes, err = uc.memeRepo.ListByTemplate(name); err != nil {
+			return err
+		}
+
+		switch deletePolicy {
+		case domain.TemplateDeleteBlock:
+			if len(memes) > 0 {
+				return fmt.Errorf("%w: %d memes use template %s", domain.ErrTemplateInUse, len(memes), name)
+			}
+		case domain.TemplateDeleteCascade:
+			for _, m := range memes {
+				if err := uc.memeRepo.Delete(m.ID); err != nil && !errors.Is(err, domain.ErrMemeNotFound) {
+					return fmt.Errorf("failed to delete meme %s: %w", m.ID, err)
+				}
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, domain.ErrTemplateInUse) {
+		return len(memes), err
+	}
+	if err != nil {
+		return 0, err
+	}
+	meme.InvalidateTemplate(name)
+	if err := uc.usageRepo.Delete(name); err != nil {
+		log.Printf("Failed to delete usage of template %s: %v", name, err)
+	}
+	return len(memes), nil
 }
 
-// SaveTemplateImage saves an image for a template
-func (uc *TemplateUsecase) SaveTemplateImage(name string, imageData []byte, mimeType string) error {
+// SaveTemplateImage saves an image for a template, records its perceptual hashes and records the new image
+// as a version by the author. Images that duplicate another template are rejected with a
+// *domain.DuplicateTemplateError unless force is set.
+func (uc *TemplateUsecase) SaveTemplateImage(name string, imageData []byte, mimeType string, force bool, author string) error {
+	return uc.saveTemplateImage(name, imageData, mimeType, force, author, domain.TemplateChangeImage, nil)
+}
+
+// saveTemplateImage saves an image for a template, recording the version as the given change. The version
+// is compared with before, or with the stored state of the template for a nil before, so a caller that
+// already stored other changes of the same operation gets them recorded together with the image.
+func (uc *TemplateUsecase) saveTemplateImage(name string, imageData []byte, mimeType string, force bool, author string, change domain.TemplateChange, before *domain.TemplateVersion) error {
+	if err := domain.ValidateTemplateName(name); err != nil {
+		return err
+	}
+
 	// First verify that the template exists
 	if _, err := uc.templateRepo.GetByName(name); err != nil {
 		return err
 	}
 
-	// Cast to the file repository to access SaveImage method
-	if fileRepo, ok := uc.templateRepo.(*repository.TemplateFileRepository); ok {
-		return fileRepo.SaveImage(name, imageData, mimeType)
+	img, _, err := image.Decode(bytes.NewReader(imageData))
+	if err != nil {
+		return fmt.Errorf("%w: %v", domain.ErrInvalidImage, err)
+	}
+	fingerprint := imagehash.Compute(img)
+
+	if !force {
+		if err := uc.checkDuplicate(name, fingerprint); err != nil {
+			return err
+		}
 	}
 
+	// The image, its hashes and the version go into the template in one locked update
+	err = uc.imageStore.SaveTemplateImage(name, imageData, mimeType, func(template *domain.Template, previous *domain.ImageRef) error {
+		state := before
+		if state == nil {
+			current := versionState(template)
+			current.Image = previous
+			state = &current
+		}
+		template.Hashes = &fingerprint
+		template.UpdatedAt = time.Now()
+		if !sameVersionState(*state, versionState(template)) {
+			recordVersion(template, *state, change, author)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	meme.InvalidateTemplate(name)
 	return nil
 }
 
-// GetTemplateImage retrieves the image for a template
+// checkDuplicate reports the closest other template whose image is a near-duplicate of fingerprint
+func (uc *TemplateUsecase) checkDuplicate(name string, fingerprint imagehash.Fingerprint) error {
+	templates, err := readableTemplates(uc.templateRepo.List())
+	if err != nil {
+		return err
+	}
+
+	var duplicate *domain.DuplicateTemplateError
+	for _, other := range templates {
+		if other.Name == name {
+			continue
+		}
+		otherFingerprint, ok := uc.templateFingerprint(other)
+		if !ok {
+			continue
+		}
+		distance := imagehash.Compare(fingerprint, *otherFingerprint)
+		if distance.Score <= duplicateThreshold && (duplicate == nil || distance.Score < duplicate.Distance.Score) {
+			duplicate = &domain.DuplicateTemplateError{Existing: other.Name, Distance: distance}
+		}
+	}
+
+	if duplicate != nil {
+		return duplicate
+	}
+	return nil
+}
+
+// FindDuplicateTemplates groups templates whose images are near-duplicates of each other
+func (uc *TemplateUsecase) FindDuplicateTemplates() ([]*domain.TemplateCluster, error) {
+	templates, err := readableTemplates(uc.templateRepo.List())
+	if err != nil {
+		return nil, err
+	}
+
+	var hashed []*domain.Template
+	var fingerprints []imagehash.Fingerprint
+	for _, template := range templates {
+		if fingerprint, ok := uc.templateFingerprint(template); ok {
+			hashed = append(hashed, template)
+			fingerprints = append(fingerprints, *fingerprint)
+		}
+	}
+
+	// Union-find over all near-duplicate pairs
+	parent := make([]int, len(hashed))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	maxDistance := make(map[int]float64)
+	for i := range hashed {
+		for j := i + 1; j < len(hashed); j++ {
+			distance := imagehash.Compare(fingerprints[i], fingerprints[j])
+			if distance.Score > duplicateThreshold {
+				continue
+			}
+			ri, rj := find(i), find(j)
+			root := min(ri, rj)
+			parent[ri], parent[rj] = root, root
+			maxDistance[root] = max(maxDistance[root], maxDistance[ri], maxDistance[rj], distance.Score)
+		}
+	}
+
+	groups := make(map[int]*domain.TemplateCluster)
+	var roots []int
+	for i, template := range hashed {
+		root := find(i)
+		cluster, ok := groups[root]
+		if !ok {
+			cluster = &domain.TemplateCluster{}
+			groups[root] = cluster
+			roots = append(roots, root)
+		}
+		cluster.Templates = append(cluster.Templates, template.Name)
+	}
+
+	clusters := []*domain.TemplateCluster{}
+	for _, root := range roots {
+		cluster := groups[root]
+		if len(cluster.Templates) < 2 {
+			continue
+		}
+		cluster.MaxDistance = maxDistance[root]
+		clusters = append(clusters, cluster)
+	}
+
+	return clusters, nil
+}
+
+// MergeTemplates repoints all memes of the duplicate templates to the survivor and removes the duplicates.
+// It returns the number of memes that were repointed.
+func (uc *TemplateUsecase) MergeTemplates(survivor string, duplicates []string) (int, error) {
+	for _, name := range append([]string{survivor}, duplicates...) {
+		if err := domain.ValidateTemplateName(name); err != nil {
+			return 0, err
+		}
+	}
+
+	if _, err := uc.templateRepo.GetByName(survivor); err != nil {
+		return 0, err
+	}
+
+	for _, name := range duplicates {
+		if name == survivor {
+			return 0, fmt.Errorf("template %s cannot be merged into itself", name)
+		}
+		if _, err := uc.templateRepo.GetByName(name); err != nil {
+			return 0, err
+		}
+	}
+
+	repointed := 0
+	for _, name := range duplicates {
+		// The memes are repointed with the duplicate locked, so no meme is created for it in the meantime
+		err := uc.templateRepo.DeleteIf(name, func() error {
+			// A meme left out of the listing would keep pointing at the deleted duplicate
+			memes, err := uc.memeRepo.ListByTemplate(name)
+			if err != nil {
+				return fmt.Errorf("failed to list memes of template %s: %w", name, err)
+			}
+			for _, meme := range memes {
+				meme.Template = survivor
+				meme.UpdatedAt = time.Now()
+				if err := uc.memeRepo.Update(meme); err != nil {
+					return fmt.Errorf("failed to repoint meme %s: %w", meme.ID, err)
+				}
+				// The survivor takes over the uses of the memes it receives; those of deleted memes are lost
+				if err := uc.usageRepo.Record(meme); err != nil {
+					log.Printf("Failed to record usage of template %s: %v", survivor, err)
+				}
+				repointed++
+			}
+			return nil
+		})
+		if err != nil {
+			return repointed, err
+		}
+		meme.InvalidateTemplate(name)
+		if err := uc.usageRepo.Delete(name); err != nil {
+			log.Printf("Failed to delete usage of template %s: %v", name, err)
+		}
+	}
+
+	return repointed, nil
+}
+
+// GetTemplateImage retrieves the image for a template together with its MIME type
 func (uc *TemplateUsecase) GetTemplateImage(name string) ([]byte, string, error) {
-	// Cast to the file repository to access GetImage method
-	if fileRepo, ok := uc.templateRepo.(*repository.TemplateFileRepository); ok {
-		return fileRepo.GetImage(name)
+	if err := domain.ValidateTemplateName(name); err != nil {
+		return nil, "", err
+	}
+
+	src, info, err := uc.imageStore.OpenTemplateImage(name)
+	if err != nil {
+		return nil, "", err
+	}
+	defer src.Close()
+
+	imageData, err := io.ReadAll(src)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read template image: %w", err)
+	}
+
+	return imageData, info.ContentType, nil
+}
+
+// IdentifyTemplate finds the templates closest to a meme image by perceptual hash distance
+func (uc *TemplateUsecase) IdentifyTemplate(src io.Reader, limit int) ([]*domain.TemplateMatch, error) {
+	img, _, err := image.Decode(src)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", domain.ErrInvalidImage, err)
+	}
+	query := imagehash.Compute(img)
+
+	templates, err := readableTemplates(uc.templateRepo.List())
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []*domain.TemplateMatch
+	for _, template := range templates {
+		fingerprint, ok := uc.templateFingerprint(template)
+		if !ok {
+			continue
+		}
+		matches = append(matches, &domain.TemplateMatch{
+			Template: template,
+			Distance: imagehash.Compare(query, *fingerprint),
+		})
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].Distance.Score < matches[j].Distance.Score
+	})
+
+	if limit <= 0 {
+		limit = defaultIdentifyLimit
+	}
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+
+	// Ensure we always return an array, even if empty
+	if matches == nil {
+		matches = []*domain.TemplateMatch{}
+	}
+
+	return matches, nil
+}
+
+// templateFingerprint returns the stored hashes of a template, computing and saving them
+// for templates whose image was uploaded before hashes were recorded
+func (uc *TemplateUsecase) templateFingerprint(template *domain.Template) (*imagehash.Fingerprint, bool) {
+	if template.Hashes != nil {
+		return template.Hashes, true
+	}
+
+	imageData, _, err := uc.GetTemplateImage(template.Name)
+	if err != nil {
+		return nil, false
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(imageData))
+	if err != nil {
+		log.Printf("Failed to decode image of template %s: %v", template.Name, err)
+		return nil, false
 	}
 
-	return nil, "", nil
+	fingerprint := imagehash.Compute(img)
+	template.Hashes = &fingerprint
+
+	// Only the hashes are saved, and only while the template still has the image they were computed from;
+	// a template deleted in the meantime stays deleted
+	_, err = uc.templateRepo.Mutate(template.Name, func(stored *domain.Template) error {
+		if stored.Hashes == nil && imageBlob(stored.Image) == imageBlob(template.Image) {
+			stored.Hashes = &fingerprint
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, domain.ErrTemplateNotFound) {
+		log.Printf("Failed to save hashes of template %s: %v", template.Name, err)
+	}
+
+	return template.Hashes, true
+}
+
+// readableTemplates passes on a template listing for lookups that can do without the templates whose
+// metadata cannot be read; those are logged and left out
+func readableTemplates(templates []*domain.Template, err error) ([]*domain.Template, error) {
+	if names := domain.CorruptEntries(err); names != nil {
+		log.Printf("Leaving out templates with unreadable metadata: %s", strings.Join(names, ", "))
+		return templates, nil
+	}
+	return templates, err
 }
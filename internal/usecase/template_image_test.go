@@ -0,0 +1,342 @@
+package usecase
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"testing"
+
+	"memes-generator/internal/domain"
+	"memes-generator/internal/meme"
+)
+
+// fakeTemplateRepository keeps templates in memory, copying them on every read and write like a real
+// backend does
+type fakeTemplateRepository struct {
+	templates map[string][]byte
+}
+
+func newFakeTemplateRepository() *fakeTemplateRepository {
+	return &fakeTemplateRepository{templates: make(map[string][]byte)}
+}
+
+func (r *fakeTemplateRepository) Create(template *domain.Template) error {
+	if _, ok := r.templates[template.Name]; ok {
+		return fmt.Errorf("template %s: %w", template.Name, domain.ErrTemplateExists)
+	}
+	return r.put(template)
+}
+
+func (r *fakeTemplateRepository) GetByName(name string) (*domain.Template, error) {
+	data, ok := r.templates[name]
+	if !ok {
+		return nil, fmt.Errorf("template %s: %w", name, domain.ErrTemplateNotFound)
+	}
+	template := &domain.Template{}
+	if err := json.Unmarshal(data, template); err != nil {
+		return nil, err
+	}
+	return template, nil
+}
+
+func (r *fakeTemplateRepository) Update(template *domain.Template) error {
+	if _, ok := r.templates[template.Name]; !ok {
+		return fmt.Errorf("template %s: %w", template.Name, domain.ErrTemplateNotFound)
+	}
+	return r.put(template)
+}
+
+func (r *fakeTemplateRepository) Mutate(name string, mutate func(*domain.Template) error) (*domain.Template, error) {
+	template, err := r.GetByName(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := mutate(template); err != nil {
+		return nil, err
+	}
+	return template, r.put(template)
+}
+
+func (r *fakeTemplateRepository) List() ([]*domain.Template, error) {
+	var templates []*domain.Template
+	for name := range r.templates {
+		template, err := r.GetByName(name)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, template)
+	}
+	return templates, nil
+}
+
+func (r *fakeTemplateRepository) ListByTag(tag string) ([]*domain.Template, error) {
+	return nil, nil
+}
+
+func (r *fakeTemplateRepository) Delete(name string) error {
+	delete(r.templates, name)
+	return nil
+}
+
+func (r *fakeTemplateRepository) DeleteIf(name string, check func() error) error {
+	if _, ok := r.templates[name]; !ok {
+		return fmt.Errorf("template %s: %w", name, domain.ErrTemplateNotFound)
+	}
+	if err := check(); err != nil {
+		return err
+	}
+	return r.Delete(name)
+}
+
+func (r *fakeTemplateRepository) put(template *domain.Template) error {
+	data, err := json.Marshal(template)
+	if err != nil {
+		return err
+	}
+	r.templates[template.Name] = data
+	return nil
+}
+
+// fakeImageStore keeps images as in-memory blobs referenced from the template metadata, the way the blob
+// image store does
+type fakeImageStore struct {
+	templates *fakeTemplateRepository
+	blobs     map[string][]byte
+}
+
+func newFakeImageStore(templates *fakeTemplateRepository) *fakeImageStore {
+	return &fakeImageStore{templates: templates, blobs: make(map[string][]byte)}
+}
+
+func (s *fakeImageStore) StatTemplateImage(name string) (meme.ImageInfo, error) {
+	template, err := s.templates.GetByName(name)
+	if err != nil {
+		return meme.ImageInfo{}, err
+	}
+	if template.Image == nil {
+		return meme.ImageInfo{}, fmt.Errorf("template %s: %w", name, domain.ErrImageNotFound)
+	}
+	return meme.ImageInfo{Name: template.Image.Name, ContentType: template.Image.ContentType, Size: template.Image.Size, Version: template.Image.Blob}, nil
+}
+
+func (s *fakeImageStore) OpenTemplateImage(name string) (io.ReadCloser, meme.ImageInfo, error) {
+	info, err := s.StatTemplateImage(name)
+	if err != nil {
+		return nil, meme.ImageInfo{}, err
+	}
+	return io.NopCloser(bytes.NewReader(s.blobs[info.Version])), info, nil
+}
+
+func (s *fakeImageStore) SaveTemplateImage(name string, data []byte, contentType string, update func(*domain.Template, *domain.ImageRef) error) error {
+	hash, _ := s.SaveSnapshot(data)
+	_, err := s.templates.Mutate(name, func(template *domain.Template) error {
+		previous := template.Image
+		template.Image = &domain.ImageRef{Blob: hash, Name: "template" + imageExtension(contentType), ContentType: contentType, Size: int64(len(data))}
+		if update == nil {
+			return nil
+		}
+		return update(template, previous)
+	})
+	return err
+}
+
+func (s *fakeImageStore) OpenMemeImage(id string) (io.ReadCloser, meme.ImageInfo, error) {
+	return nil, meme.ImageInfo{}, fmt.Errorf("meme %s: %w", id, domain.ErrImageNotFound)
+}
+
+func (s *fakeImageStore) SaveMemeImage(id, filename string, data []byte) error {
+	return fmt.Errorf("meme %s: %w", id, domain.ErrMemeNotFound)
+}
+
+func (s *fakeImageStore) SaveSnapshot(data []byte) (string, error) {
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	s.blobs[hash] = data
+	return hash, nil
+}
+
+func (s *fakeImageStore) OpenSnapshot(hash string) (io.ReadCloser, meme.ImageInfo, error) {
+	data, ok := s.blobs[hash]
+	if !ok {
+		return nil, meme.ImageInfo{}, fmt.Errorf("snapshot %s: %w", hash, domain.ErrImageNotFound)
+	}
+	return io.NopCloser(bytes.NewReader(data)), meme.ImageInfo{Size: int64(len(data)), Version: hash}, nil
+}
+
+// newTestTemplateUsecase returns a template usecase on fakes holding the named templates without images
+func newTestTemplateUsecase(t *testing.T, names ...string) (*TemplateUsecase, *fakeTemplateRepository, *fakeImageStore) {
+	t.Helper()
+	templates := newFakeTemplateRepository()
+	images := newFakeImageStore(templates)
+	for _, name := range names {
+		if err := templates.Create(&domain.Template{Name: name, DisplayName: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return NewTemplateUsecase(templates, nil, nil, images), templates, images
+}
+
+// testImage encodes a PNG whose pattern depends on seed, so images of different seeds are no duplicates
+func testImage(t *testing.T, seed int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			v := uint8(0)
+			if (x/(8+seed*4)+y/(8+seed*2))%2 == 0 {
+				v = 255
+			}
+			img.Set(x, y, color.RGBA{R: v, G: uint8(x * 4), B: uint8(y * 4), A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestSaveAndGetTemplateImage(t *testing.T) {
+	uc, templates, _ := newTestTemplateUsecase(t, "drake")
+	data := testImage(t, 0)
+
+	if err := uc.SaveTemplateImage("drake", data, "image/png", false, "alice"); err != nil {
+		t.Fatalf("SaveTemplateImage: %v", err)
+	}
+	got, contentType, err := uc.GetTemplateImage("drake")
+	if err != nil {
+		t.Fatalf("GetTemplateImage: %v", err)
+	}
+	if !bytes.Equal(got, data) || contentType != "image/png" {
+		t.Errorf("GetTemplateImage returned %d bytes of %s, want the saved %d bytes of image/png", len(got), contentType, len(data))
+	}
+
+	template, err := templates.GetByName("drake")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if template.Hashes == nil {
+		t.Error("saved image has no perceptual hashes")
+	}
+}
+
+func TestSaveTemplateImageErrors(t *testing.T) {
+	uc, templates, images := newTestTemplateUsecase(t, "drake")
+
+	tests := []struct {
+		name     string
+		template string
+		data     []byte
+		want     error
+	}{
+		{"invalid name", "../drake", testImage(t, 0), domain.ErrInvalidName},
+		{"unknown template", "missing", testImage(t, 0), domain.ErrTemplateNotFound},
+		{"undecodable image", "drake", []byte("not an image"), domain.ErrInvalidImage},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := uc.SaveTemplateImage(tt.template, tt.data, "image/png", false, "alice")
+			if !errors.Is(err, tt.want) {
+				t.Errorf("SaveTemplateImage() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	template, err := templates.GetByName("drake")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if template.Image != nil || len(images.blobs) != 0 {
+		t.Error("a rejected image was stored")
+	}
+}
+
+func TestSaveTemplateImageDuplicate(t *testing.T) {
+	uc, _, _ := newTestTemplateUsecase(t, "drake", "copy")
+	data := testImage(t, 0)
+	if err := uc.SaveTemplateImage("drake", data, "image/png", false, "alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	var duplicate *domain.DuplicateTemplateError
+	if err := uc.SaveTemplateImage("copy", data, "image/png", false, "alice"); !errors.As(err, &duplicate) || duplicate.Existing != "drake" {
+		t.Fatalf("SaveTemplateImage() error = %v, want a duplicate of drake", err)
+	}
+	if _, _, err := uc.GetTemplateImage("copy"); !errors.Is(err, domain.ErrImageNotFound) {
+		t.Errorf("GetTemplateImage() error = %v after a rejected duplicate, want %v", err, domain.ErrImageNotFound)
+	}
+
+	if err := uc.SaveTemplateImage("copy", data, "image/png", true, "alice"); err != nil {
+		t.Errorf("SaveTemplateImage() with force: %v", err)
+	}
+	if err := uc.SaveTemplateImage("copy", testImage(t, 3), "image/png", false, "alice"); err != nil {
+		t.Errorf("SaveTemplateImage() of a distinct image: %v", err)
+	}
+}
+
+func TestGetTemplateImageErrors(t *testing.T) {
+	uc, _, _ := newTestTemplateUsecase(t, "drake")
+
+	tests := []struct {
+		name     string
+		template string
+		want     error
+	}{
+		{"invalid name", "a/b", domain.ErrInvalidName},
+		{"unknown template", "missing", domain.ErrTemplateNotFound},
+		{"no image", "drake", domain.ErrImageNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := uc.GetTemplateImage(tt.template); !errors.Is(err, tt.want) {
+				t.Errorf("GetTemplateImage() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+// deletingTemplateRepository deletes a template right before it is mutated, as a concurrent delete would
+type deletingTemplateRepository struct {
+	*fakeTemplateRepository
+}
+
+func (r deletingTemplateRepository) Mutate(name string, mutate func(*domain.Template) error) (*domain.Template, error) {
+	r.Delete(name)
+	return r.fakeTemplateRepository.Mutate(name, mutate)
+}
+
+func TestTemplateFingerprintSavesHashes(t *testing.T) {
+	uc, templates, images := newTestTemplateUsecase(t, "drake")
+	if err := uc.SaveTemplateImage("drake", testImage(t, 0), "image/png", false, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	template, err := templates.Mutate("drake", func(template *domain.Template) error {
+		template.Hashes = nil
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := uc.templateFingerprint(template); !ok {
+		t.Fatal("templateFingerprint() found no hashes")
+	}
+	if stored, err := templates.GetByName("drake"); err != nil || stored.Hashes == nil {
+		t.Errorf("computed hashes were not saved (error %v)", err)
+	}
+
+	// A template deleted while its hashes are computed must not come back
+	template.Hashes = nil
+	deleting := NewTemplateUsecase(deletingTemplateRepository{templates}, nil, nil, images)
+	deleting.templateFingerprint(template)
+	if _, err := templates.GetByName("drake"); !errors.Is(err, domain.ErrTemplateNotFound) {
+		t.Errorf("GetByName() of a template deleted before its hashes were saved error = %v, want %v", err, domain.ErrTemplateNotFound)
+	}
+}
@@ -0,0 +1,639 @@
+package usecase
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"memes-generator/internal/domain"
+	"memes-generator/internal/meme"
+)
+
+const (
+	// packManifestName is the archive path of the template pack manifest, written after the images
+	packManifestName = "manifest.json"
+
+	// maxPackManifestSize is the largest manifest an import reads
+	maxPackManifestSize = 16 << 20
+
+	// maxPackImageSize is the largest template image file an import reads
+	maxPackImageSize = 32 << 20
+
+	// maxPackImagePixels is the largest number of pixels of a template image an import decodes, checked
+	// from the image header so a small file cannot decode into an image that exhausts memory
+	maxPackImagePixels = 8192 * 8192
+
+	// maxPackTotalSize is the largest total uncompressed size of the files of a pack
+	maxPackTotalSize = 1 << 30
+
+	// maxPackTemplates is the largest number of templates a pack may hold
+	maxPackTemplates = 1000
+)
+
+// ExportTemplates writes a zip template pack of the templates matching a query to w: the image of every
+// template under templates/<name>/, the images of its variants under templates/<name>/variants/ and a
+// manifest.json with the portable metadata of the templates and the checksum of every image. Pagination
+// of the query is ignored, so the pack holds every match.
+// Templates have no fonts of their own, as captions are drawn with the built-in font.
+func (uc *TemplateUsecase) ExportTemplates(w io.Writer, query domain.TemplateQuery) (*domain.TemplatePackManifest, error) {
+	query.Limit, query.Offset = 0, 0
+	result, err := uc.SearchTemplates(query)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := &domain.TemplatePackManifest{
+		FormatVersion: domain.TemplatePackFormatVersion,
+		CreatedAt:     time.Now().UTC(),
+		Templates:     []domain.PackTemplate{},
+		Files:         []domain.BackupFile{},
+	}
+
+	zw := zip.NewWriter(w)
+	for _, template := range result.Templates {
+		packed := domain.PackTemplate{
+			Name:           template.Name,
+			DisplayName:    templateTitle(template),
+			Aliases:        template.Aliases,
+			Description:    template.Description,
+			Category:       template.Category,
+			Tags:           template.Tags,
+			Layout:         template.Layout,
+			DefaultStyle:   template.DefaultStyle,
+			DefaultVariant: template.DefaultVariant,
+		}
+
+		data, contentType, err := uc.GetTemplateImage(template.Name)
+		switch {
+		case errors.Is(err, domain.ErrImageNotFound):
+		case err != nil:
+			return nil, fmt.Errorf("failed to read image of template %s: %w", template.Name, err)
+		default:
+			packed.Image = path.Join("templates", template.Name, "image"+imageExtension(contentType))
+			if err := addPackFile(zw, manifest, packed.Image, data, contentType); err != nil {
+				return nil, err
+			}
+		}
+		for _, variant := range template.Variants {
+			data, err := uc.readVariantImage(&variant)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read image of template %s variant %s: %w", template.Name, variant.Name, err)
+			}
+			imagePath := path.Join("templates", template.Name, "variants", variant.Name+imageExtension(variant.Image.ContentType))
+			if err := addPackFile(zw, manifest, imagePath, data, variant.Image.ContentType); err != nil {
+				return nil, err
+			}
+			packed.Variants = append(packed.Variants, domain.PackVariant{Name: variant.Name, Layout: variant.Layout, Image: imagePath})
+		}
+		manifest.Templates = append(manifest.Templates, packed)
+	}
+
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode manifest: %w", err)
+	}
+	if err := writeZipFile(zw, packManifestName, manifestData); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write template pack: %w", err)
+	}
+
+	return manifest, nil
+}
+
+// addPackFile writes an image to the pack and records its checksum in the manifest
+func addPackFile(zw *zip.Writer, manifest *domain.TemplatePackManifest, name string, data []byte, contentType string) error {
+	sum := sha256.Sum256(data)
+	manifest.Files = append(manifest.Files, domain.BackupFile{
+		Path:        name,
+		Size:        int64(len(data)),
+		SHA256:      hex.EncodeToString(sum[:]),
+		ContentType: contentType,
+	})
+	return writeZipFile(zw, name, data)
+}
+
+// writeZipFile writes a single file to a zip archive. Images are stored rather than deflated, as they
+// are compressed already.
+func writeZipFile(zw *zip.Writer, name string, data []byte) error {
+	method := zip.Deflate
+	if name != packManifestName {
+		method = zip.Store
+	}
+	dst, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: method, Modified: time.Now().UTC()})
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	if _, err := dst.Write(data); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	return nil
+}
+
+// imageExtension returns the file extension of a template image based on its MIME type
+func imageExtension(contentType string) string {
+	switch contentType {
+	case "image/png":
+		return ".png"
+	case "image/gif":
+		return ".gif"
+	default:
+		return ".jpg"
+	}
+}
+
+// templatePack is a template pack that passed verification. Images are read from the archive again
+// when their template is stored, so only the images of one template are held in memory at a time.
+type templatePack struct {
+	entries   map[string]*zip.File
+	checksums map[string]domain.BackupFile
+	templates []*packedTemplate
+}
+
+// packedTemplate is a template of a pack that passed validation, with its normalized metadata and the
+// archive paths of its images
+type packedTemplate struct {
+	template *domain.Template
+	image    string
+	variants []packedVariant
+}
+
+// packedVariant is a template variant of a pack that passed validation, with the archive path of its image
+type packedVariant struct {
+	name   string
+	layout *meme.Layout
+	image  string
+}
+
+// ImportTemplates imports a zip template pack. The whole pack is verified before anything is written:
+// every file must match the checksum of the manifest, every image must decode and every template must
+// pass the same validation as one created through the API. The policy decides what happens to templates
+// whose slug is already taken. Aliases that belong to another template are dropped and reported, and
+// imported images are not checked for near-duplicates of existing templates. Imported images and caption
+// options are recorded as template versions by the author.
+func (uc *TemplateUsecase) ImportTemplates(src io.ReaderAt, size int64, policy domain.PackConflictPolicy, author string) (*domain.TemplatePackReport, error) {
+	policy, err := domain.ParsePackConflictPolicy(string(policy))
+	if err != nil {
+		return nil, err
+	}
+
+	pack, err := readTemplatePack(src, size)
+	if err != nil {
+		return nil, err
+	}
+
+	templates, err := readableTemplates(uc.templateRepo.List())
+	if err != nil {
+		return nil, err
+	}
+	owners := aliasOwners(templates)
+
+	report := &domain.TemplatePackReport{Policy: policy, Templates: []domain.PackImport{}}
+	for _, p := range pack.templates {
+		result, err := uc.importTemplate(pack, p, policy, owners, author)
+		if err != nil {
+			return report, err
+		}
+		switch result.Action {
+		case "created":
+			report.Created++
+		case "renamed":
+			report.Renamed++
+		case "overwritten":
+			report.Overwritten++
+		case "skipped":
+			report.Skipped++
+		}
+		report.Templates = append(report.Templates, *result)
+	}
+
+	return report, nil
+}
+
+// importTemplate stores one template of a pack according to the conflict policy. Overwriting keeps the
+// creation date, and the stored image when the pack has none for the template; the variants are replaced
+// by those of the pack. The changes are recorded as a single version. owners maps the lookup
+// key of every slug and alias to its template and is kept up to date for the templates that follow.
+func (uc *TemplateUsecase) importTemplate(pack *templatePack, p *packedTemplate, policy domain.PackConflictPolicy, owners map[string]string, author string) (*domain.PackImport, error) {
+	template := p.template
+	result := &domain.PackImport{Template: template.Name, Name: template.Name, Action: "created"}
+
+	existing, err := uc.templateRepo.GetByName(template.Name)
+	if err != nil && !errors.Is(err, domain.ErrTemplateNotFound) && !errors.Is(err, domain.ErrCorruptMetadata) {
+		return nil, err
+	}
+	_, owned := owners[template.Name]
+	taken := err == nil || errors.Is(err, domain.ErrCorruptMetadata) || owned
+	if taken {
+		switch {
+		case policy == domain.PackConflictSkip:
+			result.Action = "skipped"
+			return result, nil
+		case policy == domain.PackConflictOverwrite && existing != nil:
+			result.Action = "overwritten"
+		default:
+			// Slugs taken by an alias or by unreadable metadata cannot be overwritten, so they are renamed
+			result.Action = "renamed"
+			if result.Name, err = uc.freeSlug(template.Name, owners); err != nil {
+				return nil, err
+			}
+		}
+	}
+	template.Name = result.Name
+
+	var aliases []string
+	for _, alias := range template.Aliases {
+		key := domain.AliasKey(alias)
+		if owner, ok := owners[key]; ok && owner != template.Name || key == template.Name {
+			result.Dropped = append(result.Dropped, alias)
+			continue
+		}
+		owners[key] = template.Name
+		aliases = append(aliases, alias)
+	}
+	template.Aliases = aliases
+	owners[template.Name] = template.Name
+
+	// Variant images are plain blobs; ones left behind by a failed import are collected by gc
+	for _, variant := range p.variants {
+		data, contentType, err := readPackImage(pack.entries, pack.checksums, variant.image)
+		if err != nil {
+			return nil, fmt.Errorf("template %s variant %s: %w", template.Name, variant.name, err)
+		}
+		hash, err := uc.imageStore.SaveSnapshot(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to save image of template %s variant %s: %w", template.Name, variant.name, err)
+		}
+		template.Variants = append(template.Variants, domain.TemplateVariant{
+			Name:   variant.name,
+			Image:  &domain.ImageRef{Blob: hash, Name: "variant-" + variant.name + imageExtension(contentType), ContentType: contentType, Size: int64(len(data))},
+			Layout: variant.layout,
+		})
+	}
+
+	var imageData []byte
+	var contentType string
+	if p.image != "" {
+		if imageData, contentType, err = readPackImage(pack.entries, pack.checksums, p.image); err != nil {
+			return nil, fmt.Errorf("template %s: %w", template.Name, err)
+		}
+	}
+
+	now := time.Now()
+	var before domain.TemplateVersion
+	template.UpdatedAt = now
+	if existing != nil && result.Action == "overwritten" {
+		_, err := uc.templateRepo.Mutate(template.Name, func(stored *domain.Template) error {
+			// The stored image and its hashes stay until the image of the pack replaces them
+			template.CreatedAt = stored.CreatedAt
+			template.Image = stored.Image
+			template.Hashes = stored.Hashes
+			template.Version = stored.Version
+			template.Versions = stored.Versions
+			before = versionState(stored)
+			if imageData == nil && !sameVersionState(before, versionState(template)) {
+				recordVersion(template, before, domain.TemplateChangeImport, author)
+			}
+			*stored = *template
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		template.CreatedAt = now
+		if imageData == nil && !sameVersionState(domain.TemplateVersion{}, versionState(template)) {
+			recordVersion(template, domain.TemplateVersion{}, domain.TemplateChangeImport, author)
+		}
+		if err := uc.templateRepo.Create(template); err != nil {
+			return nil, err
+		}
+	}
+
+	if imageData != nil {
+		if err := uc.saveTemplateImage(template.Name, imageData, contentType, true, author, domain.TemplateChangeImport, &before); err != nil {
+			return nil, fmt.Errorf("failed to save image of template %s: %w", template.Name, err)
+		}
+	}
+	return result, nil
+}
+
+// freeSlug returns the first suffixed variant of a slug that no template uses
+func (uc *TemplateUsecase) freeSlug(base string, owners map[string]string) (string, error) {
+	for n := 2; ; n++ {
+		slug := domain.SlugCandidate(base, n)
+		if _, ok := owners[slug]; ok || domain.IsReservedSlug(slug) {
+			continue
+		}
+		_, err := uc.templateRepo.GetByName(slug)
+		if errors.Is(err, domain.ErrTemplateNotFound) {
+			return slug, nil
+		}
+		if err != nil && !errors.Is(err, domain.ErrCorruptMetadata) {
+			return "", err
+		}
+	}
+}
+
+// readTemplatePack reads and verifies a template pack, returning its templates in manifest order. Every
+// image is read, checked and decoded once here and then dropped, so the memory a pack needs does not
+// grow with the number of its images.
+func readTemplatePack(src io.ReaderAt, size int64) (*templatePack, error) {
+	zr, err := zip.NewReader(src, size)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", domain.ErrInvalidPack, err)
+	}
+
+	entries := make(map[string]*zip.File)
+	var total uint64
+	for _, file := range zr.File {
+		if _, ok := entries[file.Name]; ok {
+			return nil, fmt.Errorf("%w: duplicate file %s", domain.ErrInvalidPack, file.Name)
+		}
+		if strings.HasSuffix(file.Name, "/") {
+			continue
+		}
+		// The zip reader fails for files that inflate beyond their recorded size, so the recorded sizes
+		// bound what the import reads
+		if total += file.UncompressedSize64; total > maxPackTotalSize || total < file.UncompressedSize64 {
+			return nil, fmt.Errorf("%w: pack is larger than %d bytes uncompressed", domain.ErrInvalidPack, maxPackTotalSize)
+		}
+		entries[file.Name] = file
+	}
+
+	manifestFile, ok := entries[packManifestName]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s is missing", domain.ErrInvalidPack, packManifestName)
+	}
+	manifestData, err := readZipFile(manifestFile, maxPackManifestSize)
+	if err != nil {
+		return nil, err
+	}
+	manifest := &domain.TemplatePackManifest{}
+	if err := json.Unmarshal(manifestData, manifest); err != nil {
+		return nil, fmt.Errorf("%w: failed to decode manifest: %v", domain.ErrInvalidPack, err)
+	}
+	if manifest.FormatVersion < 1 || manifest.FormatVersion > domain.TemplatePackFormatVersion {
+		return nil, fmt.Errorf("%w: unsupported format version %d", domain.ErrInvalidPack, manifest.FormatVersion)
+	}
+	if len(manifest.Templates) > maxPackTemplates {
+		return nil, fmt.Errorf("%w: pack holds %d templates, more than %d", domain.ErrInvalidPack, len(manifest.Templates), maxPackTemplates)
+	}
+
+	checksums := make(map[string]domain.BackupFile)
+	for _, file := range manifest.Files {
+		if _, ok := checksums[file.Path]; ok {
+			return nil, fmt.Errorf("%w: duplicate manifest entry %s", domain.ErrInvalidPack, file.Path)
+		}
+		checksums[file.Path] = file
+	}
+	for name := range entries {
+		if _, ok := checksums[name]; !ok && name != packManifestName {
+			return nil, fmt.Errorf("%w: unexpected file %s", domain.ErrInvalidPack, name)
+		}
+	}
+
+	names := make(map[string]bool)
+	images := make(map[string]bool)
+	pack := &templatePack{entries: entries, checksums: checksums}
+	for i := range manifest.Templates {
+		p, err := validatePackTemplate(&manifest.Templates[i])
+		if err != nil {
+			return nil, err
+		}
+		if names[p.template.Name] {
+			return nil, fmt.Errorf("%w: template %s appears twice", domain.ErrInvalidPack, p.template.Name)
+		}
+		names[p.template.Name] = true
+
+		if imagePath := manifest.Templates[i].Image; imagePath != "" {
+			if images[imagePath] {
+				return nil, fmt.Errorf("%w: image %s is shared by several templates", domain.ErrInvalidPack, imagePath)
+			}
+			images[imagePath] = true
+			if err := verifyPackImage(entries, checksums, imagePath); err != nil {
+				return nil, fmt.Errorf("template %s: %w", p.template.Name, err)
+			}
+			p.image = imagePath
+		}
+		for j, variant := range manifest.Templates[i].Variants {
+			if images[variant.Image] {
+				return nil, fmt.Errorf("%w: image %s is shared by several templates or variants", domain.ErrInvalidPack, variant.Image)
+			}
+			images[variant.Image] = true
+			if err := verifyPackImage(entries, checksums, variant.Image); err != nil {
+				return nil, fmt.Errorf("template %s variant %s: %w", p.template.Name, variant.Name, err)
+			}
+			p.variants[j].image = variant.Image
+		}
+		pack.templates = append(pack.templates, p)
+	}
+	for name := range checksums {
+		if !images[name] {
+			return nil, fmt.Errorf("%w: %s belongs to no template", domain.ErrInvalidPack, name)
+		}
+	}
+
+	return pack, nil
+}
+
+// validatePackTemplate checks the metadata of a template of a pack the way CreateTemplate and
+// UpdateTemplate do and returns it normalized
+func validatePackTemplate(packed *domain.PackTemplate) (*packedTemplate, error) {
+	invalid := func(err error) error {
+		return fmt.Errorf("%w: template %q: %v", domain.ErrInvalidPack, packed.Name, err)
+	}
+
+	if err := domain.ValidateTemplateName(packed.Name); err != nil {
+		return nil, invalid(err)
+	}
+	if domain.IsReservedSlug(packed.Name) {
+		return nil, invalid(fmt.Errorf("%s is a reserved name", packed.Name))
+	}
+	displayName := packed.DisplayName
+	if strings.TrimSpace(displayName) == "" {
+		displayName = packed.Name
+	}
+	if err := domain.ValidateDisplayName(displayName); err != nil {
+		return nil, invalid(err)
+	}
+	var aliases []string
+	seen := make(map[string]bool)
+	for _, alias := range packed.Aliases {
+		if err := domain.ValidateDisplayName(alias); err != nil {
+			return nil, invalid(err)
+		}
+		if key := domain.AliasKey(alias); !seen[key] {
+			seen[key] = true
+			aliases = append(aliases, strings.TrimSpace(alias))
+		}
+	}
+	description, err := normalizeDescription(packed.Description)
+	if err != nil {
+		return nil, invalid(err)
+	}
+	category, err := normalizeCategory(packed.Category)
+	if err != nil {
+		return nil, invalid(err)
+	}
+	tags, err := domain.NormalizeTags(packed.Tags)
+	if err != nil {
+		return nil, invalid(err)
+	}
+	if packed.Layout != nil {
+		if err := packed.Layout.Validate(); err != nil {
+			return nil, invalid(err)
+		}
+		if packed.Layout.IsZero() {
+			packed.Layout = nil
+		}
+	}
+	if packed.DefaultStyle != nil {
+		if err := packed.DefaultStyle.Validate(); err != nil {
+			return nil, invalid(err)
+		}
+		if packed.DefaultStyle.IsZero() {
+			packed.DefaultStyle = nil
+		}
+	}
+	variants, err := validatePackVariants(packed)
+	if err != nil {
+		return nil, invalid(err)
+	}
+	defaultVariant := packed.DefaultVariant
+	if defaultVariant == domain.OriginalVariant {
+		defaultVariant = ""
+	}
+	if defaultVariant != "" && !slices.ContainsFunc(variants, func(v packedVariant) bool { return v.name == defaultVariant }) {
+		return nil, invalid(fmt.Errorf("default variant %s is not one of its variants", defaultVariant))
+	}
+
+	return &packedTemplate{
+		template: &domain.Template{
+			Name:           packed.Name,
+			DisplayName:    strings.TrimSpace(displayName),
+			Aliases:        aliases,
+			Description:    description,
+			Category:       category,
+			Tags:           tags,
+			Layout:         packed.Layout,
+			DefaultStyle:   packed.DefaultStyle,
+			DefaultVariant: defaultVariant,
+		},
+		variants: variants,
+	}, nil
+}
+
+// validatePackVariants checks the variants of a template of a pack the way SaveVariantImage and
+// UpdateVariant do; their images are read afterwards
+func validatePackVariants(packed *domain.PackTemplate) ([]packedVariant, error) {
+	if len(packed.Variants) > domain.MaxVariants {
+		return nil, fmt.Errorf("more than %d variants", domain.MaxVariants)
+	}
+	var variants []packedVariant
+	seen := make(map[string]bool)
+	for _, variant := range packed.Variants {
+		if err := validateVariant(packed.Name, variant.Name); err != nil {
+			return nil, err
+		}
+		if seen[variant.Name] {
+			return nil, fmt.Errorf("variant %s appears twice", variant.Name)
+		}
+		seen[variant.Name] = true
+		if variant.Image == "" {
+			return nil, fmt.Errorf("variant %s has no image", variant.Name)
+		}
+		layout := variant.Layout
+		if layout != nil {
+			if err := layout.Validate(); err != nil {
+				return nil, fmt.Errorf("variant %s: %v", variant.Name, err)
+			}
+			if layout.IsZero() {
+				layout = nil
+			}
+		}
+		variants = append(variants, packedVariant{name: variant.Name, layout: layout})
+	}
+	return variants, nil
+}
+
+// verifyPackImage checks that an image of a pack matches the manifest and decodes, without keeping it
+func verifyPackImage(entries map[string]*zip.File, checksums map[string]domain.BackupFile, name string) error {
+	data, _, err := readPackImage(entries, checksums, name)
+	if err != nil {
+		return err
+	}
+	if _, _, err := image.Decode(bytes.NewReader(data)); err != nil {
+		return fmt.Errorf("%w: %s: %v", domain.ErrInvalidImage, name, err)
+	}
+	return nil
+}
+
+// readPackImage reads an image of a pack, verifies it against the manifest and checks its header: the
+// format and the dimensions, which are bounded before anything decodes the pixels. The MIME type is taken
+// from the image format, not from the manifest.
+func readPackImage(entries map[string]*zip.File, checksums map[string]domain.BackupFile, name string) ([]byte, string, error) {
+	expected, ok := checksums[name]
+	if !ok {
+		return nil, "", fmt.Errorf("%w: image %s is not listed in the manifest", domain.ErrInvalidPack, name)
+	}
+	file, ok := entries[name]
+	if !ok {
+		return nil, "", fmt.Errorf("%w: image %s is missing", domain.ErrInvalidPack, name)
+	}
+	data, err := readZipFile(file, maxPackImageSize)
+	if err != nil {
+		return nil, "", err
+	}
+	sum := sha256.Sum256(data)
+	if int64(len(data)) != expected.Size || hex.EncodeToString(sum[:]) != expected.SHA256 {
+		return nil, "", fmt.Errorf("%w: checksum mismatch for %s", domain.ErrInvalidPack, name)
+	}
+
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %s: %v", domain.ErrInvalidImage, name, err)
+	}
+	if int64(config.Width)*int64(config.Height) > maxPackImagePixels {
+		return nil, "", fmt.Errorf("%w: %s: %dx%d pixels is more than %d", domain.ErrInvalidImage, name, config.Width, config.Height, maxPackImagePixels)
+	}
+	switch format {
+	case "jpeg", "png", "gif":
+		return data, "image/" + format, nil
+	default:
+		return nil, "", fmt.Errorf("%w: %s: unsupported format %s", domain.ErrInvalidImage, name, format)
+	}
+}
+
+// readZipFile reads a file of a zip archive, failing for files larger than limit however they claim
+// to be sized
+func readZipFile(file *zip.File, limit int64) ([]byte, error) {
+	if file.UncompressedSize64 > uint64(limit) {
+		return nil, fmt.Errorf("%w: %s is larger than %d bytes", domain.ErrInvalidPack, file.Name, limit)
+	}
+	src, err := file.Open()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", domain.ErrInvalidPack, file.Name, err)
+	}
+	defer src.Close()
+
+	data, err := io.ReadAll(io.LimitReader(src, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to read %s: %v", domain.ErrInvalidPack, file.Name, err)
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("%w: %s is larger than %d bytes", domain.ErrInvalidPack, file.Name, limit)
+	}
+	return data, nil
+}
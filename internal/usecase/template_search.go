@@ -0,0 +1,253 @@
+package usecase
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"memes-generator/internal/domain"
+)
+
+// Relevance scores of the ways a search text can match a template; a template scores its best match
+const (
+	scoreExact       = 100
+	scorePrefix      = 80
+	scoreWordPrefix  = 60
+	scoreTag         = 50
+	scoreSubstring   = 40
+	scoreFuzzy       = 30
+	scoreDescription = 20
+)
+
+// SearchTemplates finds the templates matching a query and returns the requested page of them.
+// Names are compared both as typed and transliterated to Latin, so a Cyrillic query finds a template by its
+// slug and a Latin query finds it by a Cyrillic display name. Small typos are tolerated in words of four
+// characters or more.
+func (uc *TemplateUsecase) SearchTemplates(query domain.TemplateQuery) (*domain.TemplateSearchResult, error) {
+	if query.Limit < 0 || query.Offset < 0 {
+		return nil, fmt.Errorf("%w: limit and offset must not be negative", domain.ErrInvalidQuery)
+	}
+	if query.Order != "" && query.Order != "asc" && query.Order != "desc" {
+		return nil, fmt.Errorf("%w: unknown order %q: use asc or desc", domain.ErrInvalidQuery, query.Order)
+	}
+	sortBy, err := domain.ParseTemplateSort(string(query.Sort))
+	if err != nil {
+		return nil, err
+	}
+
+	var templates []*domain.Template
+	if strings.TrimSpace(query.Tag) != "" {
+		tag, err := domain.NormalizeLabel(query.Tag)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", domain.ErrInvalidQuery, err)
+		}
+		templates, err = readableTemplates(uc.templateRepo.ListByTag(tag))
+		if err != nil {
+			return nil, err
+		}
+	} else if templates, err = readableTemplates(uc.templateRepo.List()); err != nil {
+		return nil, err
+	}
+
+	if strings.TrimSpace(query.Category) != "" {
+		category, err := domain.NormalizeLabel(query.Category)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", domain.ErrInvalidQuery, err)
+		}
+		var filtered []*domain.Template
+		for _, template := range templates {
+			if template.Category == category {
+				filtered = append(filtered, template)
+			}
+		}
+		templates = filtered
+	}
+
+	scores := make(map[string]int)
+	if text := newSearchText(query.Text); text.key != "" {
+		var matched []*domain.Template
+		for _, template := range templates {
+			if score := text.score(template); score > 0 {
+				scores[template.Name] = score
+				matched = append(matched, template)
+			}
+		}
+		templates = matched
+		if sortBy == "" {
+			sortBy = domain.TemplateSortRelevance
+		}
+	}
+	if sortBy == "" {
+		sortBy = domain.TemplateSortName
+	}
+
+	usages, err := uc.TemplateUsages()
+	if err != nil {
+		return nil, err
+	}
+
+	// Every sort falls back to the slug, so pages stay stable across requests
+	descending := query.Order == "desc" || query.Order == "" && sortBy != domain.TemplateSortName
+	sort.SliceStable(templates, func(i, j int) bool {
+		a, b := templates[i], templates[j]
+		var cmp int
+		switch sortBy {
+		case domain.TemplateSortRelevance:
+			cmp = scores[a.Name] - scores[b.Name]
+		case domain.TemplateSortName:
+			cmp = strings.Compare(domain.AliasKey(templateTitle(a)), domain.AliasKey(templateTitle(b)))
+		case domain.TemplateSortCreated:
+			cmp = a.CreatedAt.Compare(b.CreatedAt)
+		case domain.TemplateSortPopularity:
+			cmp = int(usageCount(usages[a.Name]) - usageCount(usages[b.Name]))
+		}
+		if cmp == 0 {
+			return a.Name < b.Name
+		}
+		return cmp < 0 != descending
+	})
+
+	result := &domain.TemplateSearchResult{Templates: []*domain.Template{}, Total: len(templates), Usage: usages}
+	if query.Offset < len(templates) {
+		page := templates[query.Offset:]
+		if query.Limit > 0 && len(page) > query.Limit {
+			page = page[:query.Limit]
+		}
+		result.Templates = page
+	}
+	return result, nil
+}
+
+// usageCount returns the number of uses of a template, zero for templates never used
+func usageCount(usage *domain.TemplateUsage) int64 {
+	if usage == nil {
+		return 0
+	}
+	return usage.Count
+}
+
+// templateTitle returns the name a template is listed under: its display name, or the slug for templates
+// created before display names
+func templateTitle(template *domain.Template) string {
+	if template.DisplayName != "" {
+		return template.DisplayName
+	}
+	return template.Name
+}
+
+// searchText is a search text normalized once for comparison with every template: key as typed, with case
+// and whitespace normalized, and latin transliterated to lowercase ASCII words
+type searchText struct {
+	key   string
+	latin string
+}
+
+// newSearchText normalizes a search text
+func newSearchText(text string) searchText {
+	return searchText{key: domain.AliasKey(text), latin: domain.Transliterate(text)}
+}
+
+// score rates how well the search text matches a template; zero means no match
+func (s searchText) score(template *domain.Template) int {
+	best := 0
+	names := append([]string{template.Name, template.DisplayName}, template.Aliases...)
+	for _, name := range names {
+		if name == "" {
+			continue
+		}
+		best = max(best, s.scoreName(domain.AliasKey(name), domain.Transliterate(name)))
+	}
+	if best >= scoreTag {
+		return best
+	}
+
+	for _, tag := range template.Tags {
+		if tag == s.key {
+			return scoreTag
+		}
+	}
+	if best > 0 {
+		return best
+	}
+	if strings.Contains(domain.AliasKey(template.Description), s.key) {
+		return scoreDescription
+	}
+	return 0
+}
+
+// scoreName rates a single name of a template, given both normalized and transliterated
+func (s searchText) scoreName(key, latin string) int {
+	switch {
+	case key == s.key || s.latin != "" && latin == s.latin:
+		return scoreExact
+	case strings.HasPrefix(key, s.key) || s.latin != "" && strings.HasPrefix(latin, s.latin):
+		return scorePrefix
+	case strings.Contains(" "+key, " "+s.key) || s.latin != "" && strings.Contains(" "+latin, " "+s.latin):
+		return scoreWordPrefix
+	case strings.Contains(key, s.key) || s.latin != "" && strings.Contains(latin, s.latin):
+		return scoreSubstring
+	case fuzzyMatch(s.key, key) || s.latin != "" && fuzzyMatch(s.latin, latin):
+		return scoreFuzzy
+	}
+	return 0
+}
+
+// fuzzyMatch reports whether a query matches a name despite typos: either the whole strings are within the
+// typo allowance of the query, or every query word is a prefix of, or within the allowance of, a name word
+func fuzzyMatch(query, name string) bool {
+	if editDistance(query, name) <= typoAllowance(query) {
+		return true
+	}
+
+	nameWords := strings.Fields(name)
+	for _, queryWord := range strings.Fields(query) {
+		allowance := typoAllowance(queryWord)
+		found := false
+		for _, nameWord := range nameWords {
+			if strings.HasPrefix(nameWord, queryWord) || allowance > 0 && editDistance(queryWord, nameWord) <= allowance {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// typoAllowance returns how many edits a query of the given length may be off by: none below four
+// characters, where a single edit makes most words match, one up to seven and two beyond
+func typoAllowance(query string) int {
+	switch n := utf8.RuneCountInString(query); {
+	case n < 4:
+		return 0
+	case n < 8:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// editDistance returns the Levenshtein distance between two strings in characters
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(rb)]
+}
@@ -0,0 +1,153 @@
+package usecase
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"memes-generator/internal/config"
+	"memes-generator/internal/domain"
+	"memes-generator/internal/meme"
+	"memes-generator/internal/repository"
+)
+
+func TestUpdateTemplateConcurrently(t *testing.T) {
+	t.Setenv(config.DataDirEnv, t.TempDir())
+	templates := repository.NewTemplateFileRepository()
+	if err := templates.Create(&domain.Template{Name: "drake", DisplayName: "Drake"}); err != nil {
+		t.Fatal(err)
+	}
+	uc := NewTemplateUsecase(templates, nil, nil, nil)
+
+	const updates = 20
+	var wg sync.WaitGroup
+	for i := 0; i < updates; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			layout := meme.Layout{Top: float64(i+1) / 100}
+			if _, err := uc.UpdateTemplate("drake", domain.TemplatePatch{Layout: &layout}, "alice"); err != nil {
+				t.Errorf("UpdateTemplate: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	template, err := templates.GetByName("drake")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(template.Versions) != updates {
+		t.Fatalf("got %d versions after %d concurrent layout changes, want one each", len(template.Versions), updates)
+	}
+	for i, version := range template.Versions {
+		if version.Version != i+1 {
+			t.Errorf("version %d is numbered %d", i+1, version.Version)
+		}
+	}
+	if latest := template.Versions[updates-1]; template.Layout == nil || latest.Layout == nil || *template.Layout != *latest.Layout {
+		t.Errorf("layout %+v is not the one of the latest version %+v", template.Layout, latest.Layout)
+	}
+}
+
+func TestCreateTemplateConcurrently(t *testing.T) {
+	t.Setenv(config.DataDirEnv, t.TempDir())
+	templates := repository.NewTemplateFileRepository()
+	uc := NewTemplateUsecase(templates, nil, nil, nil)
+
+	const creates = 10
+	var wg sync.WaitGroup
+	for i := 0; i < creates; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := uc.CreateTemplate("Drake", nil, domain.TemplateDetails{}); err != nil {
+				t.Errorf("CreateTemplate: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	created, err := templates.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(created) != creates {
+		t.Errorf("got %d templates after %d concurrent creates with the same display name, want one each", len(created), creates)
+	}
+}
+
+// slowListMemeRepository takes its time to list the memes of a template, so that memes created meanwhile
+// land between the listing and what the caller does with it
+type slowListMemeRepository struct {
+	domain.MemeRepository
+}
+
+func (r slowListMemeRepository) ListByTemplate(name string) ([]*domain.Meme, error) {
+	memes, err := r.MemeRepository.ListByTemplate(name)
+	time.Sleep(50 * time.Millisecond)
+	return memes, err
+}
+
+func TestDeleteTemplateWhileCreatingMemes(t *testing.T) {
+	t.Setenv(config.DataDirEnv, t.TempDir())
+	storage, err := repository.NewStorage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	templates := NewTemplateUsecase(storage.Templates, slowListMemeRepository{storage.Memes}, storage.Usage, storage.Images)
+	memes := NewMemeUsecase(storage.Memes, storage.Templates, storage.Usage, storage.Images, meme.NewRenderer(storage.Images))
+
+	for round := 0; round < 3; round++ {
+		if err := storage.Templates.Create(&domain.Template{Name: "drake", DisplayName: "Drake"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := templates.SaveTemplateImage("drake", testImage(t, 0), "image/png", true, "alice"); err != nil {
+			t.Fatal(err)
+		}
+
+		var wg sync.WaitGroup
+		var deleteErr error
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, deleteErr = templates.DeleteTemplate("drake", string(domain.TemplateDeleteBlock))
+		}()
+		time.Sleep(10 * time.Millisecond)
+		for i := 0; i < 3; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				memes.CreateMeme("drake", "", "top", "bottom", false)
+			}()
+		}
+		wg.Wait()
+
+		// Either the delete saw the memes made from the template and refused, or it won and later memes were
+		// made for an unknown template, without a snapshot
+		left, err := storage.Memes.ListByTemplate("drake")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, m := range left {
+			if deleteErr == nil && m.TemplateSnapshot != nil {
+				t.Fatalf("round %d: template deleted with the block policy while meme %s made from it uses it", round, m.ID)
+			}
+		}
+		if deleteErr != nil && !errors.Is(deleteErr, domain.ErrTemplateInUse) {
+			t.Fatalf("round %d: DeleteTemplate: %v", round, deleteErr)
+		}
+
+		for _, m := range left {
+			if err := storage.Memes.Delete(m.ID); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if deleteErr != nil {
+			if err := storage.Templates.Delete("drake"); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package usecase
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"memes-generator/internal/domain"
+)
+
+const (
+	// defaultTrendingLimit is the number of templates returned by TrendingTemplates when no limit is given
+	defaultTrendingLimit = 10
+
+	// trendingHalfLifeShare is the share of the trending window after which a day's uses count half
+	trendingHalfLifeShare = 0.25
+)
+
+// GetTemplateUsage returns how often and when a template was last used to create a meme
+func (uc *TemplateUsecase) GetTemplateUsage(name string) (*domain.TemplateUsage, error) {
+	if err := domain.ValidateTemplateName(name); err != nil {
+		return nil, err
+	}
+	return uc.usageRepo.Get(name)
+}
+
+// TemplateUsages returns the usage of every template used at least once, keyed by slug
+func (uc *TemplateUsecase) TemplateUsages() (map[string]*domain.TemplateUsage, error) {
+	usages, err := uc.usageRepo.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list template usage: %w", err)
+	}
+	byName := make(map[string]*domain.TemplateUsage, len(usages))
+	for _, usage := range usages {
+		byName[usage.Template] = usage
+	}
+	return byName, nil
+}
+
+// TrendingTemplates ranks the templates used within the last windowDays days, today included, by their
+// time-decayed use: a day's uses count half after a quarter of the window, so a template used a lot
+// recently outranks one used as much at the start of the window.
+func (uc *TemplateUsecase) TrendingTemplates(windowDays, limit int) ([]*domain.TrendingTemplate, error) {
+	usages, err := uc.usageRepo.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list template usage: %w", err)
+	}
+
+	now := time.Now()
+	halfLife := max(float64(windowDays)*trendingHalfLifeShare, 1)
+	trending := []*domain.TrendingTemplate{}
+	for _, usage := range usages {
+		score, uses := usage.TrendingScore(now, windowDays, halfLife)
+		if uses == 0 {
+			continue
+		}
+		// Counters of deleted templates are removed with them, but may race with a create
+		template, err := uc.templateRepo.GetByName(usage.Template)
+		if err != nil {
+			continue
+		}
+		trending = append(trending, &domain.TrendingTemplate{Template: template, Usage: usage, Score: score, Uses: uses})
+	}
+
+	sort.Slice(trending, func(i, j int) bool {
+		if trending[i].Score != trending[j].Score {
+			return trending[i].Score > trending[j].Score
+		}
+		return trending[i].Template.Name < trending[j].Template.Name
+	})
+
+	if limit <= 0 {
+		limit = defaultTrendingLimit
+	}
+	if len(trending) > limit {
+		trending = trending[:limit]
+	}
+	return trending, nil
+}
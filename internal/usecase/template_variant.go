@@ -0,0 +1,178 @@
+package usecase
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+	"time"
+
+	"memes-generator/internal/domain"
+	"memes-generator/internal/meme"
+)
+
+// SaveVariantImage saves the image of a named variant of a template, adding the variant when the template
+// has none by that name, and records the change as a version by the author. Variant images are stored as
+// blobs like template snapshots; the template image itself is the original variant and is saved with
+// SaveTemplateImage.
+func (uc *TemplateUsecase) SaveVariantImage(name, variantName string, imageData []byte, mimeType, author string) (*domain.Template, error) {
+	if err := validateVariant(name, variantName); err != nil {
+		return nil, err
+	}
+	template, err := uc.templateRepo.GetByName(name)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := template.FindVariant(variantName); !ok && len(template.Variants) >= domain.MaxVariants {
+		return nil, fmt.Errorf("%w: template %s already has %d variants", domain.ErrInvalidTemplate, name, domain.MaxVariants)
+	}
+	if _, _, err := image.Decode(bytes.NewReader(imageData)); err != nil {
+		return nil, fmt.Errorf("%w: %v", domain.ErrInvalidImage, err)
+	}
+
+	hash, err := uc.imageStore.SaveSnapshot(imageData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save variant image: %w", err)
+	}
+	ref := &domain.ImageRef{
+		Blob:        hash,
+		Name:        "variant-" + variantName + imageExtension(mimeType),
+		ContentType: mimeType,
+		Size:        int64(len(imageData)),
+	}
+
+	return uc.mutateVariants(name, author, func(template *domain.Template) error {
+		if variant, ok := template.FindVariant(variantName); ok {
+			variant.Image = ref
+			return nil
+		}
+		if len(template.Variants) >= domain.MaxVariants {
+			return fmt.Errorf("%w: template %s already has %d variants", domain.ErrInvalidTemplate, name, domain.MaxVariants)
+		}
+		template.Variants = append(template.Variants, domain.TemplateVariant{Name: variantName, Image: ref})
+		return nil
+	})
+}
+
+// UpdateVariant replaces the caption layout override of a variant of a template; a zero layout removes the
+// override, so memes made from the variant use the layout of the template again
+func (uc *TemplateUsecase) UpdateVariant(name, variantName string, layout meme.Layout, author string) (*domain.Template, error) {
+	if err := validateVariant(name, variantName); err != nil {
+		return nil, err
+	}
+	if err := layout.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", domain.ErrInvalidTemplate, err)
+	}
+	return uc.mutateVariants(name, author, func(template *domain.Template) error {
+		variant, ok := template.FindVariant(variantName)
+		if !ok {
+			return fmt.Errorf("template %s variant %s: %w", name, variantName, domain.ErrVariantNotFound)
+		}
+		variant.Layout = nil
+		if !layout.IsZero() {
+			variant.Layout = &layout
+		}
+		return nil
+	})
+}
+
+// DeleteVariant removes a variant from a template. A template whose default variant is removed falls back
+// to the template image. The image blob stays referenced by the versions recorded before.
+func (uc *TemplateUsecase) DeleteVariant(name, variantName, author string) (*domain.Template, error) {
+	if err := validateVariant(name, variantName); err != nil {
+		return nil, err
+	}
+	return uc.mutateVariants(name, author, func(template *domain.Template) error {
+		var variants []domain.TemplateVariant
+		for _, variant := range template.Variants {
+			if variant.Name != variantName {
+				variants = append(variants, variant)
+			}
+		}
+		if len(variants) == len(template.Variants) {
+			return fmt.Errorf("template %s variant %s: %w", name, variantName, domain.ErrVariantNotFound)
+		}
+		template.Variants = variants
+		if template.DefaultVariant == variantName {
+			template.DefaultVariant = ""
+		}
+		return nil
+	})
+}
+
+// GetVariantImage retrieves the image of a variant of a template together with its MIME type. An empty
+// variant name picks the default variant and the original variant is the template image itself.
+func (uc *TemplateUsecase) GetVariantImage(name, variantName string) ([]byte, string, error) {
+	if err := domain.ValidateTemplateName(name); err != nil {
+		return nil, "", err
+	}
+	if variantName != "" {
+		if err := domain.ValidateVariantName(variantName); err != nil {
+			return nil, "", err
+		}
+	}
+	template, err := uc.templateRepo.GetByName(name)
+	if err != nil {
+		return nil, "", err
+	}
+	variant, err := template.ResolveVariant(variantName)
+	if err != nil {
+		return nil, "", err
+	}
+	if variant == nil {
+		return uc.GetTemplateImage(name)
+	}
+
+	imageData, err := uc.readVariantImage(variant)
+	if err != nil {
+		return nil, "", err
+	}
+	return imageData, variant.Image.ContentType, nil
+}
+
+// readVariantImage reads the image blob of a variant
+func (uc *TemplateUsecase) readVariantImage(variant *domain.TemplateVariant) ([]byte, error) {
+	src, _, err := uc.imageStore.OpenSnapshot(variant.Image.Blob)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	imageData, err := io.ReadAll(src)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read variant image: %w", err)
+	}
+	return imageData, nil
+}
+
+// mutateVariants changes the variants of a template in one locked update, recording the change as a version
+func (uc *TemplateUsecase) mutateVariants(name, author string, change func(*domain.Template) error) (*domain.Template, error) {
+	return uc.templateRepo.Mutate(name, func(template *domain.Template) error {
+		before := versionState(template)
+		template.Variants = cloneVariants(template.Variants)
+		if err := change(template); err != nil {
+			return err
+		}
+		if sameVersionState(before, versionState(template)) {
+			return nil
+		}
+		template.UpdatedAt = time.Now()
+		recordVersion(template, before, domain.TemplateChangeVariant, author)
+		return nil
+	})
+}
+
+// validateVariant checks the template and variant names of a request for a named variant. The original
+// variant is the template image itself, which is not managed as a variant.
+func validateVariant(name, variantName string) error {
+	if err := domain.ValidateTemplateName(name); err != nil {
+		return err
+	}
+	if err := domain.ValidateVariantName(variantName); err != nil {
+		return err
+	}
+	if variantName == domain.OriginalVariant {
+		return fmt.Errorf("%w: variant %q is the template image itself", domain.ErrInvalidName, variantName)
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package usecase
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"memes-generator/internal/domain"
+)
+
+func TestVariantImages(t *testing.T) {
+	uc, _, _ := newTestTemplateUsecase(t, "drake")
+	original, variant := testImage(t, 0), testImage(t, 3)
+	if err := uc.SaveTemplateImage("drake", original, "image/png", false, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := uc.SaveVariantImage("drake", "wide", variant, "image/png", "alice"); err != nil {
+		t.Fatalf("SaveVariantImage: %v", err)
+	}
+
+	tests := []struct {
+		variant string
+		want    []byte
+	}{
+		{"wide", variant},
+		{domain.OriginalVariant, original},
+		{"", original},
+	}
+	for _, tt := range tests {
+		got, _, err := uc.GetVariantImage("drake", tt.variant)
+		if err != nil {
+			t.Errorf("GetVariantImage(%q): %v", tt.variant, err)
+			continue
+		}
+		if !bytes.Equal(got, tt.want) {
+			t.Errorf("GetVariantImage(%q) returned the wrong image", tt.variant)
+		}
+	}
+
+	if _, _, err := uc.GetVariantImage("drake", "tall"); !errors.Is(err, domain.ErrVariantNotFound) {
+		t.Errorf("GetVariantImage() of an unknown variant error = %v, want %v", err, domain.ErrVariantNotFound)
+	}
+	if _, err := uc.SaveVariantImage("drake", domain.OriginalVariant, variant, "image/png", "alice"); !errors.Is(err, domain.ErrInvalidName) {
+		t.Errorf("SaveVariantImage() of the original variant error = %v, want %v", err, domain.ErrInvalidName)
+	}
+}
@@ -0,0 +1,168 @@
+package usecase
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"memes-generator/internal/domain"
+	"memes-generator/internal/meme"
+)
+
+// ListTemplateVersions returns the recorded versions of a template, newest first, together with the
+// number of the current one
+func (uc *TemplateUsecase) ListTemplateVersions(name string) ([]domain.TemplateVersion, int, error) {
+	template, err := uc.GetTemplateByName(name)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	versions := make([]domain.TemplateVersion, 0, len(template.Versions))
+	for i := len(template.Versions) - 1; i >= 0; i-- {
+		versions = append(versions, template.Versions[i])
+	}
+	return versions, template.Version, nil
+}
+
+// RollbackTemplate restores the image, variants and caption options of an earlier version of a template. The
+// restored state is recorded as a new version, so the history is never rewritten and a rollback can be
+// rolled back in turn. Rolling back to a state equal to the current one changes nothing.
+func (uc *TemplateUsecase) RollbackTemplate(name string, number int, author string) (*domain.Template, error) {
+	if err := domain.ValidateTemplateName(name); err != nil {
+		return nil, err
+	}
+	template, err := uc.templateRepo.GetByName(name)
+	if err != nil {
+		return nil, err
+	}
+	target, ok := template.FindVersion(number)
+	if !ok {
+		return nil, fmt.Errorf("template %s version %d: %w", name, number, domain.ErrVersionNotFound)
+	}
+
+	// Images of old versions are kept as blobs; make sure these survived before pointing at them. Versions are
+	// never rewritten, so the target checked here is the one restored below.
+	images := []*domain.ImageRef{target.Image}
+	for _, variant := range target.Variants {
+		images = append(images, variant.Image)
+	}
+	for _, image := range images {
+		if image == nil {
+			continue
+		}
+		src, _, err := uc.imageStore.OpenSnapshot(image.Blob)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open image of version %d: %w", number, err)
+		}
+		src.Close()
+	}
+
+	changed := false
+	template, err = uc.templateRepo.Mutate(name, func(template *domain.Template) error {
+		target, ok := template.FindVersion(number)
+		if !ok {
+			return fmt.Errorf("template %s version %d: %w", name, number, domain.ErrVersionNotFound)
+		}
+		before := versionState(template)
+		template.Image = target.Image
+		template.Layout = target.Layout
+		template.DefaultStyle = target.DefaultStyle
+		template.Variants = cloneVariants(target.Variants)
+		template.DefaultVariant = target.DefaultVariant
+		if sameVersionState(before, versionState(template)) {
+			return nil
+		}
+		if imageBlob(before.Image) != imageBlob(target.Image) {
+			// The hashes of the restored image are computed again when next needed
+			template.Hashes = nil
+		}
+
+		template.UpdatedAt = time.Now()
+		recordVersion(template, before, domain.TemplateChangeRollback, author)
+		template.Versions[len(template.Versions)-1].Source = number
+		changed = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !changed {
+		return template, nil
+	}
+	meme.InvalidateTemplate(name)
+
+	return template, nil
+}
+
+// recordVersion appends the current image, variants and caption options of a template to its history as a new
+// version and makes it the current one. before is the state the change started from: templates with an
+// image but no history yet, such as ones whose legacy image migrate moved into the blob store, first get
+// it recorded as their initial version.
+func recordVersion(template *domain.Template, before domain.TemplateVersion, change domain.TemplateChange, author string) {
+	if len(template.Versions) == 0 && before.Image != nil {
+		before.Version = 1
+		before.Change = domain.TemplateChangeInitial
+		template.Versions = append(template.Versions, before)
+	}
+
+	number := 1
+	if n := len(template.Versions); n > 0 {
+		number = template.Versions[n-1].Version + 1
+	}
+	template.Versions = append(template.Versions, domain.TemplateVersion{
+		Version:        number,
+		Change:         change,
+		Image:          template.Image,
+		Layout:         template.Layout,
+		DefaultStyle:   template.DefaultStyle,
+		Variants:       cloneVariants(template.Variants),
+		DefaultVariant: template.DefaultVariant,
+		Author:         author,
+		CreatedAt:      template.UpdatedAt,
+	})
+	template.Version = number
+}
+
+// versionState captures the image, variants and caption options of a template as an unnumbered version
+// dated at its last modification
+func versionState(template *domain.Template) domain.TemplateVersion {
+	return domain.TemplateVersion{
+		Image:          template.Image,
+		Layout:         template.Layout,
+		DefaultStyle:   template.DefaultStyle,
+		Variants:       cloneVariants(template.Variants),
+		DefaultVariant: template.DefaultVariant,
+		CreatedAt:      template.UpdatedAt,
+	}
+}
+
+// sameVersionState reports whether two versions hold the same images, variants and caption options
+func sameVersionState(a, b domain.TemplateVersion) bool {
+	if imageBlob(a.Image) != imageBlob(b.Image) || a.DefaultVariant != b.DefaultVariant || len(a.Variants) != len(b.Variants) {
+		return false
+	}
+	for i := range a.Variants {
+		if a.Variants[i].Name != b.Variants[i].Name || imageBlob(a.Variants[i].Image) != imageBlob(b.Variants[i].Image) ||
+			!reflect.DeepEqual(a.Variants[i].Layout, b.Variants[i].Layout) {
+			return false
+		}
+	}
+	return reflect.DeepEqual(a.Layout, b.Layout) && reflect.DeepEqual(a.DefaultStyle, b.DefaultStyle)
+}
+
+// cloneVariants copies a list of variants, so versions do not share it with the template they were taken
+// from; an empty list is nil
+func cloneVariants(variants []domain.TemplateVariant) []domain.TemplateVariant {
+	if len(variants) == 0 {
+		return nil
+	}
+	return append([]domain.TemplateVariant(nil), variants...)
+}
+
+// imageBlob returns the hash of the blob an image reference points at, empty for none
+func imageBlob(ref *domain.ImageRef) string {
+	if ref == nil {
+		return ""
+	}
+	return ref.Blob
+}
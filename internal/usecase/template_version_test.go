@@ -0,0 +1,75 @@
+package usecase
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"memes-generator/internal/domain"
+)
+
+func TestTemplateImageVersions(t *testing.T) {
+	uc, templates, _ := newTestTemplateUsecase(t, "drake")
+	first, second := testImage(t, 0), testImage(t, 3)
+	if err := uc.SaveTemplateImage("drake", first, "image/png", false, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := uc.SaveTemplateImage("drake", second, "image/png", false, "bob"); err != nil {
+		t.Fatal(err)
+	}
+
+	template, err := templates.GetByName("drake")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		change domain.TemplateChange
+		author string
+	}{
+		{domain.TemplateChangeImage, "alice"},
+		{domain.TemplateChangeImage, "bob"},
+	}
+	if len(template.Versions) != len(want) {
+		t.Fatalf("versions = %+v, want %d image versions", template.Versions, len(want))
+	}
+	for i, w := range want {
+		if v := template.Versions[i]; v.Version != i+1 || v.Change != w.change || v.Author != w.author {
+			t.Errorf("version %d = %+v, want a %s change by %s", i+1, v, w.change, w.author)
+		}
+	}
+}
+
+func TestRollbackTemplate(t *testing.T) {
+	uc, templates, _ := newTestTemplateUsecase(t, "drake")
+	first, second := testImage(t, 0), testImage(t, 3)
+	if err := uc.SaveTemplateImage("drake", first, "image/png", false, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := uc.SaveTemplateImage("drake", second, "image/png", false, "alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	template, err := uc.RollbackTemplate("drake", 1, "bob")
+	if err != nil {
+		t.Fatalf("RollbackTemplate: %v", err)
+	}
+	latest := template.Versions[len(template.Versions)-1]
+	if latest.Version != 3 || latest.Change != domain.TemplateChangeRollback || latest.Source != 1 || latest.Author != "bob" {
+		t.Errorf("latest version = %+v, want version 3 rolling back to version 1 by bob", latest)
+	}
+	if got, _, err := uc.GetTemplateImage("drake"); err != nil || !bytes.Equal(got, first) {
+		t.Errorf("GetTemplateImage() after the rollback does not return the first image (error %v)", err)
+	}
+
+	// Rolling back to the current state records nothing
+	if _, err := uc.RollbackTemplate("drake", 3, "bob"); err != nil {
+		t.Fatalf("RollbackTemplate: %v", err)
+	}
+	if stored, err := templates.GetByName("drake"); err != nil || len(stored.Versions) != 3 {
+		t.Errorf("rolling back to the current version changed the history (error %v)", err)
+	}
+
+	if _, err := uc.RollbackTemplate("drake", 7, "bob"); !errors.Is(err, domain.ErrVersionNotFound) {
+		t.Errorf("RollbackTemplate() of an unknown version error = %v, want %v", err, domain.ErrVersionNotFound)
+	}
+}
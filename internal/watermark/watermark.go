@@ -0,0 +1,421 @@
+package watermark
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"hash/fnv"
+	"image"
+	"image/color"
+	"math"
+	"math/rand/v2"
+	"strings"
+
+	xdraw "golang.org/x/image/draw"
+
+	"memes-generator/internal/idgen"
+)
+
+const (
+	// MaxPayload is the largest payload in bytes that fits into a watermark
+	MaxPayload = 48
+
+	// canonicalSize is the side of the square luminance grid the watermark lives in.
+	// Embedding and detection both resample to it, which makes the mark survive resizing.
+	canonicalSize = 256
+	blockSize     = 8
+
+	// strength is the quantization step applied to carrier DCT coefficients
+	strength = 24.0
+
+	frameMagic = 0xA7
+	frameBytes = 2 + MaxPayload + 4 // magic, length, payload, CRC32
+	frameBits  = frameBytes * 8
+
+	embedPasses = 4
+)
+
+// coefficients lists the mid-frequency DCT positions that carry watermark bits in every block
+var coefficients = [][2]int{{1, 2}, {2, 1}, {2, 2}, {1, 3}, {3, 1}}
+
+var (
+	// ErrNotFound is returned when no valid watermark can be recovered from an image
+	ErrNotFound = errors.New("no watermark detected")
+
+	// ErrPayloadTooLarge is returned when a payload exceeds MaxPayload bytes
+	ErrPayloadTooLarge = fmt.Errorf("watermark payload exceeds %d bytes", MaxPayload)
+)
+
+// dctBasis holds the orthonormal 8x8 DCT-II basis
+var dctBasis = func() [blockSize][blockSize]float64 {
+	var basis [blockSize][blockSize]float64
+	for k := 0; k < blockSize; k++ {
+		scale := math.Sqrt(2.0 / blockSize)
+		if k == 0 {
+			scale = math.Sqrt(1.0 / blockSize)
+		}
+		for n := 0; n < blockSize; n++ {
+			basis[k][n] = scale * math.Cos(math.Pi*float64(2*n+1)*float64(k)/(2*blockSize))
+		}
+	}
+	return basis
+}()
+
+// Embed returns a copy of img carrying payload as an invisible watermark keyed by key
+func Embed(img image.Image, payload []byte, key string) (*image.RGBA, error) {
+	if len(payload) > MaxPayload {
+		return nil, ErrPayloadTooLarge
+	}
+
+	bits := frameToBits(encodeFrame(payload))
+	slots := slotMapping(key)
+
+	bounds := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	xdraw.Draw(dst, dst.Bounds(), img, bounds.Min, xdraw.Src)
+
+	// Resampling and 8-bit rounding blur the quantized coefficients, so the
+	// embedding is repeated against its own result until it settles.
+	for pass := 0; pass < embedPasses; pass++ {
+		luma := canonicalLuma(dst)
+		delta := make([]float64, len(luma))
+		changed := false
+
+		forEachBlock(luma, func(block int, coeffs *[blockSize][blockSize]float64) bool {
+			modified := false
+			for i, pos := range coefficients {
+				bit := bits[slots[block*len(coefficients)+i]]
+				c := coeffs[pos[0]][pos[1]]
+				target := quantize(c, bit, strength)
+				if math.Abs(target-c) > strength/8 {
+					coeffs[pos[0]][pos[1]] = target
+					modified = true
+				}
+			}
+			return modified
+		}, delta)
+
+		for _, d := range delta {
+			if d != 0 {
+				changed = true
+				break
+			}
+		}
+		if !changed {
+			break
+		}
+		applyDelta(dst, delta)
+	}
+
+	return dst, nil
+}
+
+// Detect recovers a watermark payload embedded with the same key
+func Detect(img image.Image, key string) ([]byte, error) {
+	slots := slotMapping(key)
+	luma := canonicalLuma(img)
+	votes := make([]float64, frameBits)
+
+	forEachBlock(luma, func(block int, coeffs *[blockSize][blockSize]float64) bool {
+		for i, pos := range coefficients {
+			c := coeffs[pos[0]][pos[1]]
+			// Positive votes favour bit 1, weighted by how clearly the coefficient sits on a lattice
+			votes[slots[block*len(coefficients)+i]] += latticeDistance(c, 0, strength) - latticeDistance(c, 1, strength)
+		}
+		return false
+	}, nil)
+
+	bits := make([]byte, frameBits)
+	for i, v := range votes {
+		if v > 0 {
+			bits[i] = 1
+		}
+	}
+
+	return decodeFrame(bitsToFrame(bits))
+}
+
+// encodeFrame packs a payload with a magic byte, its length and a CRC32 into a fixed-size frame
+func encodeFrame(payload []byte) []byte {
+	frame := make([]byte, frameBytes)
+	frame[0] = frameMagic
+	frame[1] = byte(len(payload))
+	copy(frame[2:], payload)
+	binary.BigEndian.PutUint32(frame[frameBytes-4:], crc32.ChecksumIEEE(frame[:frameBytes-4]))
+	return frame
+}
+
+// decodeFrame validates a frame and returns its payload
+func decodeFrame(frame []byte) ([]byte, error) {
+	if frame[0] != frameMagic || int(frame[1]) > MaxPayload {
+		return nil, ErrNotFound
+	}
+	if crc32.ChecksumIEEE(frame[:frameBytes-4]) != binary.BigEndian.Uint32(frame[frameBytes-4:]) {
+		return nil, ErrNotFound
+	}
+	payload := make([]byte, frame[1])
+	copy(payload, frame[2:])
+	return payload, nil
+}
+
+// frameToBits expands a frame into one byte per bit, most significant bit first
+func frameToBits(frame []byte) []byte {
+	bits := make([]byte, 0, len(frame)*8)
+	for _, b := range frame {
+		for i := 7; i >= 0; i-- {
+			bits = append(bits, (b>>i)&1)
+		}
+	}
+	return bits
+}
+
+// bitsToFrame packs one-byte-per-bit values back into a frame
+func bitsToFrame(bits []byte) []byte {
+	frame := make([]byte, len(bits)/8)
+	for i, bit := range bits {
+		frame[i/8] |= bit << (7 - i%8)
+	}
+	return frame
+}
+
+// slotMapping assigns every carrier coefficient to a frame bit using a key-seeded permutation,
+// so each bit is repeated across the whole image and the layout is unknown without the key
+func slotMapping(key string) []int {
+	blocks := (canonicalSize / blockSize) * (canonicalSize / blockSize)
+	total := blocks * len(coefficients)
+
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	seed := h.Sum64()
+	rng := rand.New(rand.NewPCG(seed, seed^0x9E3779B97F4A7C15))
+
+	slots := make([]int, total)
+	for i, p := range rng.Perm(total) {
+		slots[i] = p % frameBits
+	}
+	return slots
+}
+
+// quantize moves a coefficient onto the lattice that encodes bit
+func quantize(c float64, bit byte, step float64) float64 {
+	offset := float64(bit) * step / 2
+	return offset + step*math.Round((c-offset)/step)
+}
+
+// latticeDistance returns how far a coefficient is from the lattice that encodes bit
+func latticeDistance(c float64, bit byte, step float64) float64 {
+	return math.Abs(c - quantize(c, bit, step))
+}
+
+// canonicalLuma resamples an image to the canonical grid and returns its luminance
+func canonicalLuma(img image.Image) []float64 {
+	small := image.NewRGBA(image.Rect(0, 0, canonicalSize, canonicalSize))
+	xdraw.BiLinear.Scale(small, small.Bounds(), img, img.Bounds(), xdraw.Src, nil)
+
+	luma := make([]float64, canonicalSize*canonicalSize)
+	for y := 0; y < canonicalSize; y++ {
+		for x := 0; x < canonicalSize; x++ {
+			px := small.RGBAAt(x, y)
+			luma[y*canonicalSize+x] = 0.299*float64(px.R) + 0.587*float64(px.G) + 0.114*float64(px.B)
+		}
+	}
+	return luma
+}
+
+// forEachBlock runs fn on the DCT of every 8x8 block of the canonical luminance grid.
+// When fn reports a change and delta is not nil, the spatial difference is accumulated into delta.
+func forEachBlock(luma []float64, fn func(block int, coeffs *[blockSize][blockSize]float64) bool, delta []float64) {
+	perRow := canonicalSize / blockSize
+	for by := 0; by < perRow; by++ {
+		for bx := 0; bx < perRow; bx++ {
+			var pixels [blockSize][blockSize]float64
+			for y := 0; y < blockSize; y++ {
+				for x := 0; x < blockSize; x++ {
+					pixels[y][x] = luma[(by*blockSize+y)*canonicalSize+bx*blockSize+x]
+				}
+			}
+
+			coeffs := dct(pixels)
+			original := coeffs
+			if !fn(by*perRow+bx, &coeffs) || delta == nil {
+				continue
+			}
+
+			var diff [blockSize][blockSize]float64
+			for v := 0; v < blockSize; v++ {
+				for u := 0; u < blockSize; u++ {
+					diff[v][u] = coeffs[v][u] - original[v][u]
+				}
+			}
+			spatial := idct(diff)
+			for y := 0; y < blockSize; y++ {
+				for x := 0; x < blockSize; x++ {
+					delta[(by*blockSize+y)*canonicalSize+bx*blockSize+x] = spatial[y][x]
+				}
+			}
+		}
+	}
+}
+
+// dct computes the orthonormal 2D DCT-II of a block
+func dct(block [blockSize][blockSize]float64) [blockSize][blockSize]float64 {
+	var out [blockSize][blockSize]float64
+	for v := 0; v < blockSize; v++ {
+		for u := 0; u < blockSize; u++ {
+			sum := 0.0
+			for y := 0; y < blockSize; y++ {
+				for x := 0; x < blockSize; x++ {
+					sum += dctBasis[v][y] * dctBasis[u][x] * block[y][x]
+				}
+			}
+			out[v][u] = sum
+		}
+	}
+	return out
+}
+
+// idct computes the inverse of dct
+func idct(coeffs [blockSize][blockSize]float64) [blockSize][blockSize]float64 {
+	var out [blockSize][blockSize]float64
+	for y := 0; y < blockSize; y++ {
+		for x := 0; x < blockSize; x++ {
+			sum := 0.0
+			for v := 0; v < blockSize; v++ {
+				for u := 0; u < blockSize; u++ {
+					sum += dctBasis[v][y] * dctBasis[u][x] * coeffs[v][u]
+				}
+			}
+			out[y][x] = sum
+		}
+	}
+	return out
+}
+
+// applyDelta bilinearly upsamples a canonical luminance delta and adds it to every colour channel
+func applyDelta(img *image.RGBA, delta []float64) {
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+	for y := 0; y < height; y++ {
+		fy := (float64(y)+0.5)*canonicalSize/float64(height) - 0.5
+		for x := 0; x < width; x++ {
+			fx := (float64(x)+0.5)*canonicalSize/float64(width) - 0.5
+			d := sampleBilinear(delta, fx, fy)
+			if d == 0 {
+				continue
+			}
+
+			px := img.RGBAAt(x, y)
+			img.SetRGBA(x, y, color.RGBA{
+				R: clamp(float64(px.R) + d),
+				G: clamp(float64(px.G) + d),
+				B: clamp(float64(px.B) + d),
+				A: px.A,
+			})
+		}
+	}
+}
+
+// sampleBilinear interpolates the canonical grid at fractional coordinates
+func sampleBilinear(grid []float64, fx, fy float64) float64 {
+	x0, y0 := int(math.Floor(fx)), int(math.Floor(fy))
+	tx, ty := fx-float64(x0), fy-float64(y0)
+
+	at := func(x, y int) float64 {
+		x = min(max(x, 0), canonicalSize-1)
+		y = min(max(y, 0), canonicalSize-1)
+		return grid[y*canonicalSize+x]
+	}
+
+	top := at(x0, y0)*(1-tx) + at(x0+1, y0)*tx
+	bottom := at(x0, y0+1)*(1-tx) + at(x0+1, y0+1)*tx
+	return top*(1-ty) + bottom*ty
+}
+
+// clamp rounds a channel value into the 0..255 range
+func clamp(v float64) uint8 {
+	return uint8(min(max(math.Round(v), 0), 255))
+}
+
+// Payload identifies the meme and the requester a watermarked copy was served to. The watermark only has
+// room for a hash of the requester ID, so a detected payload carries RequesterHash, which MatchesRequester
+// compares with candidate requester IDs; RequesterID is only set for payloads in the legacy format.
+type Payload struct {
+	MemeID        string `json:"meme_id"`
+	RequesterID   string `json:"requester_id,omitempty"`
+	RequesterHash string `json:"requester_hash,omitempty"`
+}
+
+const (
+	// payloadFormat starts every encoded payload; legacy payloads are plain text and never start with it
+	payloadFormat = 0x01
+
+	// memeIDCompact and memeIDText tell whether an encoded payload holds a packed ULID-style meme ID or the
+	// meme ID as length-prefixed text, as needed for legacy nanosecond IDs
+	memeIDCompact = 0x01
+	memeIDText    = 0x02
+
+	// memeIDPrefix is the prefix of the meme IDs packed into payloads
+	memeIDPrefix = "meme_"
+
+	// requesterHashBytes is the number of bytes of the SHA-256 of the requester ID kept in a payload
+	requesterHashBytes = 16
+
+	// legacyPayloadSeparator separates the meme ID from the requester ID in a legacy payload
+	legacyPayloadSeparator = "|"
+)
+
+// RequesterHash returns the hash of a requester ID as it is kept in a payload, hex encoded
+func RequesterHash(requesterID string) string {
+	sum := sha256.Sum256([]byte(requesterID))
+	return hex.EncodeToString(sum[:requesterHashBytes])
+}
+
+// MatchesRequester reports whether the payload was served to requesterID
+func (p Payload) MatchesRequester(requesterID string) bool {
+	if p.RequesterHash == "" {
+		return p.RequesterID == requesterID
+	}
+	return p.RequesterHash == RequesterHash(requesterID)
+}
+
+// Encode serializes the payload: a format byte, the meme ID packed into 16 bytes and a 16-byte hash of the
+// requester ID, 34 bytes for every meme ID the generator creates and a requester ID of any length
+func (p Payload) Encode() ([]byte, error) {
+	data := []byte{payloadFormat}
+	if id, ok := idgen.Compact(memeIDPrefix, p.MemeID); ok {
+		data = append(append(data, memeIDCompact), id...)
+	} else {
+		if len(p.MemeID) > MaxPayload-3-requesterHashBytes {
+			return nil, fmt.Errorf("%w: meme ID %s takes %d bytes", ErrPayloadTooLarge, p.MemeID, len(p.MemeID))
+		}
+		data = append(append(data, memeIDText, byte(len(p.MemeID))), p.MemeID...)
+	}
+	sum := sha256.Sum256([]byte(p.RequesterID))
+	return append(data, sum[:requesterHashBytes]...), nil
+}
+
+// ParsePayload decodes a payload produced by Payload.Encode, or the meme ID and requester ID of a legacy
+// payload that kept both as text
+func ParsePayload(data []byte) (Payload, error) {
+	if len(data) == 0 || data[0] != payloadFormat {
+		memeID, requesterID, _ := strings.Cut(string(data), legacyPayloadSeparator)
+		return Payload{MemeID: memeID, RequesterID: requesterID}, nil
+	}
+
+	var p Payload
+	rest := data[1:]
+	switch {
+	case len(rest) == 1+idgen.CompactSize+requesterHashBytes && rest[0] == memeIDCompact:
+		p.MemeID, _ = idgen.Expand(memeIDPrefix, rest[1:1+idgen.CompactSize])
+		rest = rest[1+idgen.CompactSize:]
+	case len(rest) >= 2 && rest[0] == memeIDText && len(rest) == 2+int(rest[1])+requesterHashBytes:
+		p.MemeID = string(rest[2 : 2+int(rest[1])])
+		rest = rest[2+int(rest[1]):]
+	default:
+		return Payload{}, fmt.Errorf("malformed watermark payload of %d bytes", len(data))
+	}
+	p.RequesterHash = hex.EncodeToString(rest)
+	return p, nil
+}
@@ -0,0 +1,91 @@
+package watermark
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"memes-generator/internal/idgen"
+)
+
+// testImage returns a photo-like image with smooth gradients and some texture
+func testImage() image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 480, 360))
+	for y := 0; y < 360; y++ {
+		for x := 0; x < 480; x++ {
+			texture := uint8((x*7 + y*13) % 32)
+			img.Set(x, y, color.RGBA{R: uint8(x/2) + texture, G: uint8(y/2) + texture, B: 128 + texture, A: 255})
+		}
+	}
+	return img
+}
+
+func TestEmbedAndDetectPayload(t *testing.T) {
+	memeID := idgen.NewULIDGenerator("meme_").NewID()
+	requesterID := "firstname.middlename.lastname@subsidiary.example.com"
+	payload, err := Payload{MemeID: memeID, RequesterID: requesterID}.Encode()
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+
+	marked, err := Embed(testImage(), payload, "secret")
+	if err != nil {
+		t.Fatalf("Embed: %v", err)
+	}
+	data, err := Detect(marked, "secret")
+	if err != nil {
+		t.Fatalf("Detect: %v", err)
+	}
+	detected, err := ParsePayload(data)
+	if err != nil {
+		t.Fatalf("ParsePayload: %v", err)
+	}
+
+	if detected.MemeID != memeID {
+		t.Errorf("detected meme ID %q, want %q", detected.MemeID, memeID)
+	}
+	if !detected.MatchesRequester(requesterID) {
+		t.Errorf("detected payload %+v does not match requester %q", detected, requesterID)
+	}
+	if detected.MatchesRequester("someone.else@example.com") {
+		t.Error("detected payload matches another requester")
+	}
+}
+
+func TestParsePayload(t *testing.T) {
+	tests := []struct {
+		name      string
+		payload   Payload
+		requester string
+	}{
+		{"generated ID", Payload{MemeID: "meme_1760000000000_0J8ZC4XW4T7QF2AH", RequesterID: "alice@example.com"}, "alice@example.com"},
+		{"legacy ID", Payload{MemeID: "meme_1700000000000000000", RequesterID: "bob"}, "bob"},
+		{"empty requester", Payload{MemeID: "meme_1760000000000_0J8ZC4XW4T7QF2AH"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := tt.payload.Encode()
+			if err != nil {
+				t.Fatalf("Encode: %v", err)
+			}
+			if len(data) > MaxPayload {
+				t.Fatalf("encoded payload takes %d bytes, more than %d", len(data), MaxPayload)
+			}
+			got, err := ParsePayload(data)
+			if err != nil {
+				t.Fatalf("ParsePayload: %v", err)
+			}
+			if got.MemeID != tt.payload.MemeID || !got.MatchesRequester(tt.requester) {
+				t.Errorf("ParsePayload() = %+v, want meme %s served to %q", got, tt.payload.MemeID, tt.requester)
+			}
+		})
+	}
+
+	legacy, err := ParsePayload([]byte("meme_1700000000000000000|bob"))
+	if err != nil || legacy.MemeID != "meme_1700000000000000000" || legacy.RequesterID != "bob" {
+		t.Errorf("ParsePayload() of a legacy payload = %+v, %v", legacy, err)
+	}
+	if _, err := ParsePayload([]byte{payloadFormat, memeIDCompact, 1, 2}); err == nil {
+		t.Error("ParsePayload() of a truncated payload succeeded")
+	}
+}
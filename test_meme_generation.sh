@@ -53,4 +53,410 @@ curl -X POST http://localhost:8080/api/memes -H "Content-Type: application/json"
 kill $PID
 echo "Test 3 completed"
 
+# Test 4: S3 storage backend against the local MinIO stand-in
+# Start it first with: docker compose up -d minio minio-init
+echo "Test 4: S3 storage backend"
+unset GENERATE_MEME_MODE
+export STORAGE_BACKEND=s3
+export S3_ENDPOINT=${S3_ENDPOINT:-http://localhost:9000}
+export S3_REGION=${S3_REGION:-us-east-1}
+export S3_BUCKET=${S3_BUCKET:-memes}
+export S3_PREFIX=test-$$
+export S3_ACCESS_KEY_ID=${S3_ACCESS_KEY_ID:-minioadmin}
+export S3_SECRET_ACCESS_KEY=${S3_SECRET_ACCESS_KEY:-minioadmin}
+go run cmd/web/main.go &
+PID=$!
+sleep 2
+
+# Create a template and a meme stored in the bucket
+curl -X POST http://localhost:8080/api/templates -H "Content-Type: application/json" -d '{"name":"test"}'
+MEME_ID=$(curl -s -X POST http://localhost:8080/api/memes -H "Content-Type: application/json" -d '{"template":"test","text_top":"Hello","text_bottom":"S3"}' | sed -n 's/.*"id":"\([^"]*\)".*/\1/p')
+
+# The meme must be listed and its image served from the bucket
+curl -s http://localhost:8080/api/memes | grep -q "$MEME_ID" && echo "Meme $MEME_ID listed from S3" || echo "FAIL: meme not listed from S3"
+curl -s -o /dev/null -w "%{content_type}\n" http://localhost:8080/memes/$MEME_ID/image | grep -q "image/png" && echo "Meme image served from S3" || echo "FAIL: meme image not served from S3"
+
+# The CLI regenerates the same meme by ID
+go run ./cmd/generate --meme-id "$MEME_ID"
+
+kill $PID
+unset STORAGE_BACKEND
+echo "Test 4 completed"
+
+# Test 5: Hostile template names and meme IDs must not reach outside the data directory
+echo "Test 5: Path traversal hardening"
+export DATA_DIR=$(mktemp -d)/data
+OUTSIDE=$(dirname "$DATA_DIR")/outside
+mkdir -p "$DATA_DIR/memes" "$OUTSIDE/images"
+echo "canary" > "$OUTSIDE/canary.txt"
+go run cmd/web/main.go &
+PID=$!
+sleep 2
+
+# expect_status <status> <method> <url> [json body]
+expect_status() {
+  local status
+  if [ -n "$4" ]; then
+    status=$(curl -s -o /dev/null -w "%{http_code}" -X "$2" "$3" -H "Content-Type: application/json" -d "$4")
+  else
+    status=$(curl -s -o /dev/null -w "%{http_code}" -X "$2" "$3")
+  fi
+  [ "$status" = "$1" ] && echo "OK $2 $3 $4 -> $status" || echo "FAIL: $2 $3 $4 -> $status, expected $1"
+}
+
+# Display names are free-form: hostile ones only ever produce a harmless slug
+expect_status 400 POST http://localhost:8080/api/templates '{"name":"nul\u0000byte"}'
+expect_status 400 POST http://localhost:8080/api/templates '{"name":"   "}'
+curl -s -X POST http://localhost:8080/api/templates -H "Content-Type: application/json" -d '{"name":"../../outside"}' | grep -q '"name":"outside"' && echo "OK ../../outside stored as slug outside" || echo "FAIL: unexpected slug for ../../outside"
+curl -s -X POST http://localhost:8080/api/templates -H "Content-Type: application/json" -d '{"name":".locks"}' | grep -q '"name":"locks"' && echo "OK .locks stored as slug locks" || echo "FAIL: unexpected slug for .locks"
+expect_status 400 POST http://localhost:8080/api/templates/%2E%2E/image
+expect_status 400 POST http://localhost:8080/api/memes '{"template":"../../escape","text_top":"x"}'
+expect_status 400 POST http://localhost:8080/api/memes/preview '{"template":"../escape"}'
+expect_status 400 DELETE http://localhost:8080/api/memes/%2E%2E
+expect_status 400 GET http://localhost:8080/api/memes/%2E%2E
+expect_status 400 GET http://localhost:8080/memes/%2E%2E/image
+expect_status 400 GET "http://localhost:8080/templates/..%5Coutside/image"
+expect_status 400 POST http://localhost:8080/api/admin/templates/merge '{"survivor":"test","duplicates":["../outside"]}'
+
+# A meme directory that is a symlink is refused and deleting it never touches the target
+ln -s "$OUTSIDE" "$DATA_DIR/memes/meme_symlink"
+expect_status 400 DELETE http://localhost:8080/api/memes/meme_symlink
+
+# Symlinks inside a meme directory are not followed out of the data directory
+mkdir -p "$DATA_DIR/memes/meme_escape"
+echo '{"id":"meme_escape","template":"test","created_at":"2025-01-01T00:00:00Z"}' > "$DATA_DIR/memes/meme_escape/metadata.json"
+printf '\x89PNG\r\n\x1a\n' > "$OUTSIDE/images/generated_meme.png"
+ln -s "$OUTSIDE/images" "$DATA_DIR/memes/meme_escape/images"
+curl -s -o /dev/null -w "%{content_type}\n" http://localhost:8080/memes/meme_escape/image | grep -q "image/svg+xml" && echo "OK symlinked image not served" || echo "FAIL: image served through symlink"
+
+[ -f "$OUTSIDE/canary.txt" ] && [ -f "$OUTSIDE/images/generated_meme.png" ] && echo "OK files outside the data directory are intact" || echo "FAIL: files outside the data directory were removed"
+[ ! -e "$(dirname "$DATA_DIR")/outside/metadata.json" ] && echo "OK nothing written outside the data directory" || echo "FAIL: metadata written outside the data directory"
+
+kill $PID
+export DATA_DIR=./data
+echo "Test 5 completed"
+
+# Test 6: Template slugs, display names and aliases
+echo "Test 6: Template slugs"
+export DATA_DIR=$(mktemp -d)/data
+go run cmd/web/main.go &
+PID=$!
+sleep 2
+
+# Cyrillic display names are transliterated, duplicates get a numeric suffix
+curl -s -X POST http://localhost:8080/api/templates -H "Content-Type: application/json" -d '{"display_name":"Кот в шоке","aliases":["shocked cat"]}' | grep -q '"name":"kot-v-shoke"' && echo "OK slug kot-v-shoke" || echo "FAIL: unexpected slug"
+curl -s -X POST http://localhost:8080/api/templates -H "Content-Type: application/json" -d '{"display_name":"Кот  в шоке!"}' | grep -q '"name":"kot-v-shoke-2"' && echo "OK slug kot-v-shoke-2" || echo "FAIL: slug not de-duplicated"
+expect_status 409 POST http://localhost:8080/api/templates '{"display_name":"Другой кот","aliases":["Shocked Cat"]}'
+
+# Aliases redirect to the canonical slug and resolve when creating memes
+curl -s -o /dev/null -w "%{http_code} %{redirect_url}\n" "http://localhost:8080/api/templates/shocked%20cat" | grep -q "301 .*/api/templates/kot-v-shoke$" && echo "OK alias redirected" || echo "FAIL: alias not redirected"
+curl -s -X POST http://localhost:8080/api/memes -H "Content-Type: application/json" -d '{"template":"Shocked Cat","text_top":"Alias"}' | grep -q '"template":"kot-v-shoke"' && echo "OK meme stored with slug" || echo "FAIL: meme template not canonical"
+
+kill $PID
+export DATA_DIR=./data
+echo "Test 6 completed"
+
+# Test 7: Template update and delete policies
+echo "Test 7: Template update and delete"
+export DATA_DIR=$(mktemp -d)/data
+go run cmd/web/main.go &
+PID=$!
+sleep 2
+
+curl -s -X POST http://localhost:8080/api/templates -H "Content-Type: application/json" -d '{"display_name":"Old name"}' > /dev/null
+curl -s -X POST http://localhost:8080/api/memes -H "Content-Type: application/json" -d '{"template":"old-name","text_top":"Kept"}' > /dev/null
+
+# A new display name keeps the old one as an alias and bumps updated_at
+curl -s -X PATCH http://localhost:8080/api/templates/old-name -H "Content-Type: application/json" -d '{"display_name":"New name","description":"Test","layout":{"top":0.2},"default_style":{"color":"#ffff00","uppercase":true}}' | grep -q '"aliases":\["Old name"\]' && echo "OK display name changed" || echo "FAIL: display name not changed"
+expect_status 400 PATCH http://localhost:8080/api/templates/old-name '{"layout":{"bottom":2}}'
+expect_status 400 PATCH http://localhost:8080/api/templates/old-name '{"default_style":{"color":"yellow"}}'
+expect_status 404 PATCH http://localhost:8080/api/templates/missing '{"description":"x"}'
+
+# The default block policy refuses to delete a template in use
+expect_status 409 DELETE http://localhost:8080/api/templates/old-name
+expect_status 400 DELETE "http://localhost:8080/api/templates/old-name?policy=bogus"
+expect_status 200 DELETE "http://localhost:8080/api/templates/old-name?policy=orphan"
+curl -s http://localhost:8080/api/memes | grep -q '"template":"old-name"' && echo "OK orphaned meme kept" || echo "FAIL: orphaned meme removed"
+
+# Cascade removes the memes together with the template
+curl -s -X POST http://localhost:8080/api/templates -H "Content-Type: application/json" -d '{"display_name":"Doomed"}' > /dev/null
+curl -s -X POST http://localhost:8080/api/memes -H "Content-Type: application/json" -d '{"template":"doomed","text_top":"Gone"}' > /dev/null
+expect_status 200 DELETE "http://localhost:8080/api/templates/doomed?policy=cascade"
+curl -s http://localhost:8080/api/memes | grep -q '"template":"doomed"' && echo "FAIL: cascaded meme kept" || echo "OK cascaded meme removed"
+
+kill $PID
+export DATA_DIR=./data
+echo "Test 7 completed"
+
+# Test 8: Memes render from the template snapshot taken when they were created
+echo "Test 8: Template snapshots"
+export DATA_DIR=$(mktemp -d)/data
+go run cmd/web/main.go &
+PID=$!
+sleep 2
+
+# Rendered memes serve as template images, so no fixture files are needed
+curl -s -X POST http://localhost:8080/api/templates -H "Content-Type: application/json" -d '{"display_name":"Snapshot"}' > /dev/null
+BLANK_ID=$(curl -s -X POST http://localhost:8080/api/memes -H "Content-Type: application/json" -d '{"template":"snapshot","text_top":"Background"}' | grep -o '"id":"[^"]*"' | cut -d'"' -f4)
+curl -s http://localhost:8080/memes/$BLANK_ID/image -o "$DATA_DIR/../background.png"
+curl -s -X POST http://localhost:8080/api/templates/snapshot/image -F "image=@$DATA_DIR/../background.png" > /dev/null
+
+RESPONSE=$(curl -s -X POST http://localhost:8080/api/memes -H "Content-Type: application/json" -d '{"template":"snapshot","text_top":"Frozen"}')
+SNAP_ID=$(echo "$RESPONSE" | grep -o '"id":"[^"]*"' | cut -d'"' -f4)
+HASH=$(echo "$RESPONSE" | grep -o '"template_image":"[^"]*"' | cut -d'"' -f4)
+[ -f "$DATA_DIR/blobs/${HASH:0:2}/$HASH" ] && echo "OK snapshot stored" || echo "FAIL: snapshot missing"
+curl -s http://localhost:8080/memes/$SNAP_ID/image -o "$DATA_DIR/../frozen.png"
+BEFORE=$(sha256sum "$DATA_DIR/../frozen.png" | cut -d' ' -f1)
+
+# Replacing the template image does not change how the meme re-renders
+curl -s -X POST http://localhost:8080/api/templates/snapshot/image -F "image=@$DATA_DIR/../frozen.png" > /dev/null
+go run ./cmd/generate --meme-id "$SNAP_ID" > /dev/null
+AFTER=$(curl -s http://localhost:8080/memes/$SNAP_ID/image | sha256sum | cut -d' ' -f1)
+[ "$BEFORE" = "$AFTER" ] && echo "OK re-render used the snapshot" || echo "FAIL: re-render used the new template image"
+
+kill $PID
+export DATA_DIR=./data
+echo "Test 8 completed"
+
+# Test 9: Images are stored once as content-addressed blobs and collected when unreferenced
+echo "Test 9: Blob store"
+export DATA_DIR=$(mktemp -d)/data
+go run cmd/web/main.go &
+PID=$!
+sleep 2
+
+curl -s -X POST http://localhost:8080/api/templates -H "Content-Type: application/json" -d '{"display_name":"Blob source"}' > /dev/null
+SOURCE_ID=$(curl -s -X POST http://localhost:8080/api/memes -H "Content-Type: application/json" -d '{"template":"blob-source","text_top":"Blob"}' | grep -o '"id":"[^"]*"' | cut -d'"' -f4)
+curl -s http://localhost:8080/memes/$SOURCE_ID/image -o "$DATA_DIR/../source.png"
+[ ! -d "$DATA_DIR/memes/$SOURCE_ID/images" ] && echo "OK meme image kept as a blob" || echo "FAIL: meme image written to images/"
+
+# The meme image uploaded to two templates is stored once
+for name in first second; do
+  curl -s -X POST http://localhost:8080/api/templates -H "Content-Type: application/json" -d "{\"display_name\":\"$name\"}" > /dev/null
+  curl -s -X POST "http://localhost:8080/api/templates/$name/image?force=true" -F "image=@$DATA_DIR/../source.png" > /dev/null
+done
+HASH=$(sha256sum "$DATA_DIR/../source.png" | cut -d' ' -f1)
+[ -f "$DATA_DIR/blobs/${HASH:0:2}/$HASH" ] && echo "OK template image stored under its hash" || echo "FAIL: template image blob missing"
+[ "$(find "$DATA_DIR/blobs" -type f | wc -l)" = "1" ] && echo "OK identical images deduplicated" || echo "FAIL: unexpected number of blobs"
+
+# Blobs stay while any meme or template references them
+curl -s -X DELETE "http://localhost:8080/api/memes/$SOURCE_ID" > /dev/null
+curl -s -X DELETE "http://localhost:8080/api/templates/first" > /dev/null
+go run ./cmd/generate gc --grace 0 | grep -q '"collected": \[\]' && echo "OK referenced blob kept" || echo "FAIL: referenced blob collected"
+curl -s -X DELETE "http://localhost:8080/api/templates/second" > /dev/null
+go run ./cmd/generate gc --grace 0 > /dev/null
+[ ! -f "$DATA_DIR/blobs/${HASH:0:2}/$HASH" ] && echo "OK unreferenced blob collected" || echo "FAIL: unreferenced blob kept"
+
+kill $PID
+export DATA_DIR=./data
+echo "Test 9 completed"
+
+# Test 10: Template tags, categories and search
+echo "Test 10: Template search"
+export DATA_DIR=$(mktemp -d)/data
+go run cmd/web/main.go &
+PID=$!
+sleep 2
+
+curl -s -X POST http://localhost:8080/api/templates -H "Content-Type: application/json" -d '{"display_name":"Кот в шоке","category":"Animals","tags":["Reaction","cats"]}' > /dev/null
+curl -s -X POST http://localhost:8080/api/templates -H "Content-Type: application/json" -d '{"display_name":"Distracted Boyfriend","category":"people","tags":["reaction"]}' > /dev/null
+curl -s -X POST http://localhost:8080/api/templates -H "Content-Type: application/json" -d '{"display_name":"Doge","category":"animals"}' > /dev/null
+search() { curl -s "http://localhost:8080/api/templates?$1" | grep -o '"name":"[^"]*"' | cut -d'"' -f4 | tr '\n' ' '; }
+[ "$(search 'q=kot')" = "kot-v-shoke " ] && echo "OK Latin query finds Cyrillic template" || echo "FAIL: q=kot"
+[ "$(search 'q=%D0%BA%D0%BE%D1%82%20%D0%B2%20%D1%88%D0%B0%D0%BA%D0%B5')" = "kot-v-shoke " ] && echo "OK fuzzy Cyrillic query" || echo "FAIL: fuzzy Cyrillic query"
+[ "$(search 'q=distracted%20boyfirend')" = "distracted-boyfriend " ] && echo "OK fuzzy Latin query" || echo "FAIL: fuzzy Latin query"
+[ "$(search 'tag=reaction&sort=name')" = "distracted-boyfriend kot-v-shoke " ] && echo "OK tag filter" || echo "FAIL: tag filter"
+[ "$(search 'category=ANIMALS&sort=name&order=desc')" = "kot-v-shoke doge " ] && echo "OK category filter" || echo "FAIL: category filter"
+curl -s -D - -o /dev/null "http://localhost:8080/api/templates?limit=1&offset=1" | grep -qi '^X-Total-Count: 3' && echo "OK total count header" || echo "FAIL: total count header"
+[ "$(search 'sort=name&limit=1&offset=1')" = "doge " ] && echo "OK pagination" || echo "FAIL: pagination"
+[ "$(curl -s -o /dev/null -w '%{http_code}' 'http://localhost:8080/api/templates?sort=bogus')" = "400" ] && echo "OK invalid sort rejected" || echo "FAIL: invalid sort accepted"
+
+kill $PID
+export DATA_DIR=./data
+echo "Test 10 completed"
+
+# Test 11: Template usage counters and trending ranking
+echo "Test 11: Template usage"
+export DATA_DIR=$(mktemp -d)/data
+go run cmd/web/main.go &
+PID=$!
+sleep 2
+
+for name in Busy Quiet; do
+  curl -s -X POST http://localhost:8080/api/templates -H "Content-Type: application/json" -d "{\"display_name\":\"$name\"}" > /dev/null
+done
+# Concurrent creates must all be counted
+CURLS=""
+for i in $(seq 1 10); do
+  curl -s -X POST http://localhost:8080/api/memes -H "Content-Type: application/json" -d '{"template":"busy"}' > /dev/null &
+  CURLS="$CURLS $!"
+done
+wait $CURLS
+curl -s -X POST http://localhost:8080/api/memes -H "Content-Type: application/json" -d '{"template":"quiet"}' > /dev/null
+curl -s http://localhost:8080/api/templates/busy | grep -q '"usage_count":10' && echo "OK concurrent uses counted" || echo "FAIL: usage count"
+curl -s http://localhost:8080/api/templates/busy | grep -q '"last_used_at"' && echo "OK last use recorded" || echo "FAIL: last_used_at missing"
+[ "$(curl -s 'http://localhost:8080/api/templates/trending?window=7d' | grep -o '"name":"[^"]*"' | cut -d'"' -f4 | tr '\n' ' ')" = "busy quiet " ] && echo "OK trending ranking" || echo "FAIL: trending ranking"
+[ "$(curl -s -o /dev/null -w '%{http_code}' 'http://localhost:8080/api/templates/trending?window=week')" = "400" ] && echo "OK invalid window rejected" || echo "FAIL: invalid window accepted"
+
+# Counters survive a restart
+kill $PID
+wait $PID 2>/dev/null
+go run cmd/web/main.go &
+PID=$!
+sleep 2
+curl -s http://localhost:8080/api/templates/busy | grep -q '"usage_count":10' && echo "OK usage survives restart" || echo "FAIL: usage lost on restart"
+
+kill $PID
+export DATA_DIR=./data
+echo "Test 11 completed"
+
+# Test 12: Template pack export and import
+echo "Test 12: Template packs"
+export DATA_DIR=$(mktemp -d)/data
+PACK_DIR=$(mktemp -d)
+go run cmd/web/main.go &
+PID=$!
+sleep 2
+
+curl -s -X POST http://localhost:8080/api/templates -H "Content-Type: application/json" -d '{"display_name":"Drake","aliases":["Hotline"],"tags":["classic"]}' > /dev/null
+curl -s -X PATCH http://localhost:8080/api/templates/drake -H "Content-Type: application/json" -d '{"layout":{"top":0.1}}' > /dev/null
+curl -s -X POST http://localhost:8080/api/templates -H "Content-Type: application/json" -d '{"display_name":"Other"}' > /dev/null
+ID=$(curl -s -X POST http://localhost:8080/api/memes -H "Content-Type: application/json" -d '{"template":"other","text_top":"Pack"}' | grep -o '"id":"[^"]*"' | cut -d'"' -f4)
+curl -s http://localhost:8080/memes/$ID/image -o $PACK_DIR/drake.png
+curl -s -X POST http://localhost:8080/api/templates/drake/image -F "image=@$PACK_DIR/drake.png;type=image/png" > /dev/null
+
+curl -s -o $PACK_DIR/classic.zip "http://localhost:8080/api/templates/export?tag=classic"
+unzip -l $PACK_DIR/classic.zip | grep -q "templates/drake/image.png" && echo "OK pack holds image" || echo "FAIL: image missing from pack"
+unzip -l $PACK_DIR/classic.zip | grep -q "templates/other" && echo "FAIL: untagged template exported" || echo "OK tag filter applied"
+curl -s -X POST http://localhost:8080/api/templates/import -F "archive=@$PACK_DIR/classic.zip" | grep -q '"skipped":1' && echo "OK skip policy" || echo "FAIL: skip policy"
+curl -s -X POST http://localhost:8080/api/templates/import -F "archive=@$PACK_DIR/classic.zip" -F on_conflict=rename | grep -q '"name":"drake-2"' && echo "OK rename policy" || echo "FAIL: rename policy"
+curl -s http://localhost:8080/api/templates/drake-2 | grep -q '"top":0.1' && echo "OK layout imported" || echo "FAIL: layout lost"
+[ "$(curl -s -o /dev/null -w '%{http_code}' http://localhost:8080/templates/drake-2/image)" = "200" ] && echo "OK imported image served" || echo "FAIL: imported image missing"
+curl -s -X PATCH http://localhost:8080/api/templates/drake -H "Content-Type: application/json" -d '{"tags":["changed"]}' > /dev/null
+curl -s -X POST http://localhost:8080/api/templates/import -F "archive=@$PACK_DIR/classic.zip" -F on_conflict=overwrite > /dev/null
+curl -s http://localhost:8080/api/templates/drake | grep -q '"tags":\["classic"\]' && echo "OK overwrite policy" || echo "FAIL: overwrite policy"
+echo "not a zip" > $PACK_DIR/broken.zip
+[ "$(curl -s -o /dev/null -w '%{http_code}' -X POST http://localhost:8080/api/templates/import -F "archive=@$PACK_DIR/broken.zip")" = "400" ] && echo "OK broken pack rejected" || echo "FAIL: broken pack accepted"
+
+# The CLI imports the pack into another data directory
+kill $PID
+wait $PID 2>/dev/null
+export DATA_DIR=$(mktemp -d)/data
+go run ./cmd/generate import-templates $PACK_DIR/classic.zip | grep -q '"created": 1' && echo "OK CLI import" || echo "FAIL: CLI import"
+go run ./cmd/generate export-templates --output $PACK_DIR/copy.zip > /dev/null && unzip -l $PACK_DIR/copy.zip | grep -q "templates/drake/image.png" && echo "OK CLI export" || echo "FAIL: CLI export"
+
+rm -rf $PACK_DIR
+export DATA_DIR=./data
+echo "Test 12 completed"
+
+# Test 13: Template versions and rollback
+echo "Test 13: Template versions"
+export DATA_DIR=$(mktemp -d)/data
+TRUSTED_PROXIES=127.0.0.1,::1 go run cmd/web/main.go &
+PID=$!
+sleep 2
+
+curl -s -X POST http://localhost:8080/api/templates -H "Content-Type: application/json" -d '{"display_name":"Versioned"}' > /dev/null
+curl -s -X POST http://localhost:8080/api/templates -H "Content-Type: application/json" -d '{"display_name":"Source"}' > /dev/null
+for text in First Second; do
+  ID=$(curl -s -X POST http://localhost:8080/api/memes -H "Content-Type: application/json" -d "{\"template\":\"source\",\"text_top\":\"$text\"}" | grep -o '"id":"[^"]*"' | cut -d'"' -f4)
+  curl -s http://localhost:8080/memes/$ID/image -o "$DATA_DIR/../$text.png"
+done
+curl -s -X POST http://localhost:8080/api/templates/versioned/image -H "X-Requester-ID: alice" -F "image=@$DATA_DIR/../First.png" > /dev/null
+MEME=$(curl -s -X POST http://localhost:8080/api/memes -H "Content-Type: application/json" -d '{"template":"versioned"}')
+echo "$MEME" | grep -q '"template_version":1' && echo "OK meme records template version" || echo "FAIL: meme template version"
+curl -s -X POST "http://localhost:8080/api/templates/versioned/image?force=true" -H "X-Requester-ID: bob" -F "image=@$DATA_DIR/../Second.png" > /dev/null
+curl -s -X PATCH http://localhost:8080/api/templates/versioned -H "Content-Type: application/json" -d '{"layout":{"top":0.2}}' > /dev/null
+curl -s -X PATCH http://localhost:8080/api/templates/versioned -H "Content-Type: application/json" -d '{"description":"not versioned"}' > /dev/null
+VERSIONS=$(curl -s http://localhost:8080/api/templates/versioned/versions)
+[ "$(echo "$VERSIONS" | grep -o '"version":[0-9]*' | tr '\n' ' ')" = '"version":3 "version":2 "version":1 ' ] && echo "OK versions listed newest first" || echo "FAIL: versions $VERSIONS"
+echo "$VERSIONS" | grep -q '"author":"bob"' && echo "OK author recorded" || echo "FAIL: author missing"
+
+curl -s -X POST http://localhost:8080/api/templates/versioned/rollback -H "Content-Type: application/json" -d '{"version":1}' | grep -q '"version":4' && echo "OK rollback recorded as new version" || echo "FAIL: rollback"
+curl -s http://localhost:8080/templates/versioned/image | cmp -s - "$DATA_DIR/../First.png" && echo "OK rollback restored image" || echo "FAIL: image not restored"
+[ "$(curl -s -o /dev/null -w '%{http_code}' -X POST http://localhost:8080/api/templates/versioned/rollback -H "Content-Type: application/json" -d '{"version":42}')" = "404" ] && echo "OK unknown version rejected" || echo "FAIL: unknown version accepted"
+
+# Images of earlier versions are not garbage
+go run ./cmd/generate gc --grace 0 | grep -q '"collected": \[\]' && echo "OK version images kept by gc" || echo "FAIL: version image collected"
+
+kill $PID
+export DATA_DIR=./data
+echo "Test 13 completed"
+
+# Test 14: Template variants
+echo "Test 14: Template variants"
+export DATA_DIR=$(mktemp -d)/data
+go run cmd/web/main.go &
+PID=$!
+sleep 2
+
+curl -s -X POST http://localhost:8080/api/templates -H "Content-Type: application/json" -d '{"display_name":"Varied"}' > /dev/null
+curl -s -X POST http://localhost:8080/api/templates -H "Content-Type: application/json" -d '{"display_name":"Source"}' > /dev/null
+for text in Base Dark; do
+  ID=$(curl -s -X POST http://localhost:8080/api/memes -H "Content-Type: application/json" -d "{\"template\":\"source\",\"text_top\":\"$text\"}" | grep -o '"id":"[^"]*"' | cut -d'"' -f4)
+  curl -s http://localhost:8080/memes/$ID/image -o "$DATA_DIR/../$text.png"
+done
+curl -s -X POST http://localhost:8080/api/templates/varied/image -F "image=@$DATA_DIR/../Base.png" > /dev/null
+curl -s -X POST http://localhost:8080/api/templates/varied/variants/dark/image -F "image=@$DATA_DIR/../Dark.png;type=image/png" | grep -q '"name":"dark"' && echo "OK variant added" || echo "FAIL: variant not added"
+curl -s http://localhost:8080/templates/varied/variants/dark/image | cmp -s - "$DATA_DIR/../Dark.png" && echo "OK variant image served" || echo "FAIL: variant image"
+curl -s http://localhost:8080/templates/varied/image | cmp -s - "$DATA_DIR/../Base.png" && echo "OK template image unchanged" || echo "FAIL: template image changed"
+[ "$(curl -s -o /dev/null -w '%{http_code}' http://localhost:8080/templates/varied/variants/missing/image)" = "404" ] && echo "OK unknown variant image is 404" || echo "FAIL: unknown variant image"
+
+curl -s -X PATCH http://localhost:8080/api/templates/varied/variants/dark -H "Content-Type: application/json" -d '{"layout":{"top":0.3}}' > /dev/null
+MEME=$(curl -s -X POST http://localhost:8080/api/memes -H "Content-Type: application/json" -d '{"template":"varied","variant":"dark"}')
+echo "$MEME" | grep -q '"variant":"dark"' && echo "OK meme picks variant" || echo "FAIL: meme variant $MEME"
+[ "$(curl -s -o /dev/null -w '%{http_code}' -X POST http://localhost:8080/api/memes -H "Content-Type: application/json" -d '{"template":"varied","variant":"missing"}')" = "400" ] && echo "OK unknown variant rejected" || echo "FAIL: unknown variant accepted"
+
+curl -s -X PATCH http://localhost:8080/api/templates/varied -H "Content-Type: application/json" -d '{"default_variant":"dark"}' | grep -q '"default_variant":"dark"' && echo "OK default variant set" || echo "FAIL: default variant"
+curl -s -X POST http://localhost:8080/api/memes -H "Content-Type: application/json" -d '{"template":"varied"}' | grep -q '"variant":"dark"' && echo "OK default variant used" || echo "FAIL: default variant not used"
+curl -s -X POST http://localhost:8080/api/memes -H "Content-Type: application/json" -d '{"template":"varied","variant":"original"}' | grep -q '"variant"' && echo "FAIL: original variant recorded" || echo "OK original variant picks template image"
+
+curl -s -X DELETE http://localhost:8080/api/templates/varied/variants/dark | grep -q '"default_variant":"original"' && echo "OK deleting default variant resets default" || echo "FAIL: default variant kept"
+curl -s http://localhost:8080/api/templates/varied/versions | grep -q '"change":"variant"' && echo "OK variant changes versioned" || echo "FAIL: variant changes not versioned"
+
+# Images of deleted variants stay referenced by versions and memes
+go run ./cmd/generate gc --grace 0 | grep -q '"collected": \[\]' && echo "OK variant images kept by gc" || echo "FAIL: variant image collected"
+
+kill $PID
+export DATA_DIR=./data
+echo "Test 14 completed"
+
+# Test 15: Maintenance commands repair, upgrade and restore the data directory
+echo "Test 15: fsck, migrate and restore"
+export DATA_DIR=$(mktemp -d)/data
+go run cmd/web/main.go &
+PID=$!
+sleep 2
+
+curl -s -X POST http://localhost:8080/api/templates -H "Content-Type: application/json" -d '{"display_name":"Kept"}' > /dev/null
+KEPT=$(curl -s -X POST http://localhost:8080/api/memes -H "Content-Type: application/json" -d '{"template":"kept","text_top":"Kept"}' | grep -o '"id":"[^"]*"' | cut -d'"' -f4)
+curl -s http://localhost:8080/memes/$KEPT/image -o "$DATA_DIR/../kept.png"
+curl -s -X POST http://localhost:8080/api/templates/kept/image -F "image=@$DATA_DIR/../kept.png" > /dev/null
+go run ./cmd/generate fsck > /dev/null 2>&1 && echo "OK fsck finds no problems" || echo "FAIL: fsck on a clean data directory"
+go run ./cmd/generate backup --output "$DATA_DIR/../backup.tar.gz" > /dev/null && echo "OK backup written" || echo "FAIL: backup"
+LATER=$(curl -s -X POST http://localhost:8080/api/memes -H "Content-Type: application/json" -d '{"template":"kept","text_top":"Later"}' | grep -o '"id":"[^"]*"' | cut -d'"' -f4)
+
+# Unreadable metadata is reported and stops the commands acting on every meme
+echo '{' > "$DATA_DIR/memes/$KEPT/metadata.json"
+curl -s -D - -o /dev/null http://localhost:8080/api/memes | grep -q "X-Corrupt-Memes: $KEPT" && echo "OK listing names corrupt meme" || echo "FAIL: corrupt meme not reported"
+go run ./cmd/generate fsck 2>/dev/null | grep -q '"kind": "corrupt_metadata"' && echo "OK fsck reports corrupt metadata" || echo "FAIL: fsck missed corrupt metadata"
+go run ./cmd/generate migrate > /dev/null 2>&1 && echo "FAIL: migrate ignored corrupt metadata" || echo "OK migrate stops on corrupt metadata"
+go run ./cmd/generate gc --grace 0 > /dev/null 2>&1 && echo "FAIL: gc ignored corrupt metadata" || echo "OK gc stops on corrupt metadata"
+
+# Replacing restores the backup and then deletes what it does not hold
+go run ./cmd/generate restore --mode replace "$DATA_DIR/../backup.tar.gz" > /dev/null && echo "OK restore replaced data" || echo "FAIL: restore"
+curl -s http://localhost:8080/api/memes/$KEPT | grep -q '"text_top":"Kept"' && echo "OK corrupt meme restored" || echo "FAIL: corrupt meme not restored"
+[ "$(curl -s -o /dev/null -w '%{http_code}' http://localhost:8080/api/memes/$LATER)" = "404" ] && echo "OK meme created after backup removed" || echo "FAIL: later meme kept"
+go run ./cmd/generate fsck > /dev/null 2>&1 && echo "OK fsck clean after restore" || echo "FAIL: fsck after restore"
+
+# Documents of older schema versions are upgraded in bulk
+sed -i '/schema_version/d' "$DATA_DIR/templates/kept/metadata.json"
+go run ./cmd/generate migrate --dry-run | grep -q '"from": 0' && echo "OK migrate dry run reports old template" || echo "FAIL: dry run"
+grep -q schema_version "$DATA_DIR/templates/kept/metadata.json" && echo "FAIL: dry run rewrote metadata" || echo "OK dry run writes nothing"
+go run ./cmd/generate migrate > /dev/null && grep -q schema_version "$DATA_DIR/templates/kept/metadata.json" && echo "OK migrate upgraded template" || echo "FAIL: migrate"
+
+kill $PID
+export DATA_DIR=./data
+echo "Test 15 completed"
+
 echo "All tests completed!"
\ No newline at end of file
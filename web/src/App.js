@@ -266,7 +266,7 @@ function CreateMeme() {
                       e.target.src = 'data:image/svg+xml;base64,PHN2ZyB3aWR0aD0iMTAwIiBoZWlnaHQ9IjEwMCIgeG1sbnM9Imh0dHA6Ly93d3cudzMub3JnLzIwMDAvc3ZnIj48cmVjdCB3aWR0aD0iMTAwJSIgaGVpZ2h0PSIxMDAlIiBmaWxsPSIjZGRkIi8+PHRleHQgeD0iNTAlIiB5PSI1MCUiIGZvbnQtZmFtaWx5PSJBcmlhbCIgZm9udC1zaXplPSIxMiIgZmlsbD0iIzk5OSIgdGV4dC1hbmNob3I9Im1pZGRsZSIgZHk9Ii4zZW0iPlRlbXBsYXRlPC90ZXh0Pjwvc3ZnPg==';
                     }}
                   />
-                  <span className="template-name">{template.name}</span>
+                  <span className="template-name">{template.display_name || template.name}</span>
                 </div>
               ))}
             </div>
@@ -352,7 +352,7 @@ function TemplateGallery() {
               <Link to={`/templates/${template.name}`}>
                 <img
                   src={`/templates/${template.name}/image`}
-                  alt={`${template.name} template`}
+                  alt={`${template.display_name || template.name} template`}
                   className="template-thumbnail"
                   onError={(e) => {
                     // Fallback to a placeholder if image fails to load
@@ -360,7 +360,7 @@ function TemplateGallery() {
                   }}
                 />
               </Link>
-              <h3>{template.name}</h3>
+              <h3>{template.display_name || template.name}</h3>
               <small>Created: {template.created_at}</small>
             </div>
           ))}
@@ -389,7 +389,7 @@ function CreateTemplate() {
         headers: {
           'Content-Type': 'application/json',
         },
-        body: JSON.stringify({ name }),
+        body: JSON.stringify({ display_name: name }),
       });
 
       if (!response.ok) {
@@ -404,7 +404,7 @@ function CreateTemplate() {
         const formData = new FormData();
         formData.append('image', image);
 
-        const imageResponse = await fetch(`/api/templates/${name}/image`, {
+        const imageResponse = await fetch(`/api/templates/${encodeURIComponent(template.name)}/image`, {
           method: 'POST',
           body: formData,
         });